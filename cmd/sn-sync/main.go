@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jonhadfield/gosn-v2/cache"
@@ -14,6 +17,7 @@ import (
 
 	snsync "github.com/clayrosenthal/sn-sync/sn-sync"
 
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/viper"
 	"github.com/urfave/cli"
 )
@@ -21,23 +25,44 @@ import (
 // overwritten at build time
 var version, versionOutput, tag, sha, buildDate string
 
+// exit codes, so scripts can tell failures apart
+const (
+	exitError          = 1
+	exitInvalidSession = 2
+	exitNoRemoteItems  = 3
+	exitPathConflict   = 4
+	exitPathInvalid    = 5
+	exitConflict       = 6
+	exitPathsFailed    = 7
+	exitLocked         = 8
+	exitOffline        = 9
+	exitCancelled      = 130
+)
+
+const (
+	defaultMaxAddFiles    = 200
+	defaultMaxAddBytes    = 50 * 1024 * 1024
+	defaultMaxSyncItems   = 100
+	defaultMaxSyncPercent = 50
+)
+
 type configOptsOutput struct {
-	useStdOut  bool
-	display    bool
-	useSession bool
-	home       string
-	sessKey    string
-	server     string
-	pageSize   int
-	cacheDBDir string
-	debug      bool
+	display     bool
+	useSession  bool
+	home        string
+	sessKey     string
+	server      string
+	pageSize    int
+	cacheDBDir  string
+	snOptions   snsync.Options
+	debug       bool
+	lockWait    bool
+	lockTimeout time.Duration
 }
 
 func getOpts(c *cli.Context) (out configOptsOutput, err error) {
-	out.useStdOut = c.Bool("no-stdout")
-
-	if !c.GlobalBool("no-stdout") {
-		out.useStdOut = false
+	if err = loadConfig(c.GlobalString("config")); err != nil {
+		return
 	}
 
 	if c.GlobalBool("use-session") || viper.GetBool("use_session") {
@@ -68,29 +93,176 @@ func getOpts(c *cli.Context) (out configOptsOutput, err error) {
 
 	out.pageSize = c.GlobalInt("page-size")
 
+	out.snOptions.Offline = c.GlobalBool("offline")
+
+	out.lockWait = c.GlobalBool("wait")
+	out.lockTimeout = c.GlobalDuration("timeout")
+
+	secrets := &snsync.SecretSources{
+		EnvPrefix: snsync.DefaultSecretEnvPrefix,
+		File:      viper.GetString("secrets_file"),
+		Command:   viper.GetString("secrets_command"),
+	}
+	if c.GlobalString("secrets-file") != "" {
+		secrets.File = c.GlobalString("secrets-file")
+	}
+	if c.GlobalString("secrets-command") != "" {
+		secrets.Command = c.GlobalString("secrets-command")
+	}
+	out.snOptions.Secrets = secrets
+	out.snOptions.MergeFormats = viper.GetStringMapString("merge_formats")
+	out.snOptions.SecretScan.Allow = viper.GetStringSlice("secret_scan_allow")
+
+	out.snOptions.Limits = snsync.Limits{
+		MaxAddFiles:    viper.GetInt("limits.max_add_files"),
+		MaxAddBytes:    viper.GetInt64("limits.max_add_bytes"),
+		MaxSyncItems:   viper.GetInt("limits.max_sync_items"),
+		MaxSyncPercent: viper.GetInt("limits.max_sync_percent"),
+	}
+	out.snOptions.Force = c.Bool("force")
+	out.snOptions.IncludeProtected = c.Bool("include-protected")
+	out.snOptions.KeepGoing = c.Bool("keep-going")
+
+	if err = viper.UnmarshalKey("editors", &out.snOptions.Editors); err != nil {
+		return out, fmt.Errorf("invalid editors setting: %w", err)
+	}
+
+	out.snOptions.Encryption.Paths = viper.GetStringSlice("encrypt_paths")
+
+	keyfile := viper.GetString("encryption_keyfile")
+	if c.GlobalString("encryption-keyfile") != "" {
+		keyfile = c.GlobalString("encryption-keyfile")
+	}
+
+	switch {
+	case keyfile != "":
+		var key []byte
+
+		key, err = os.ReadFile(keyfile)
+		if err != nil {
+			return out, fmt.Errorf("failed to read encryption keyfile: %w", err)
+		}
+
+		out.snOptions.Encryption.Passphrase = []byte(strings.TrimRight(string(key), "\r\n"))
+	case viper.GetString("encryption_passphrase") != "":
+		out.snOptions.Encryption.Passphrase = []byte(viper.GetString("encryption_passphrase"))
+	}
+
+	out.snOptions.SecretScan.Confirm = confirmSecrets(c.StringSlice("allow-secret"), c.Bool("allow-secrets"),
+		out.home, isatty.IsTerminal(os.Stdin.Fd()))
+
 	out.debug = viper.GetBool("debug")
 	if c.GlobalBool("debug") {
 		out.debug = true
 	}
 
+	// progress goes to stderr so it's never mixed into the output, and debug output is logged line by line,
+	// so progress is too. --no-stdout hides only the progress, leaving the output that --quiet hides as well.
+	noProgress := !out.display || c.GlobalBool("no-stdout")
+
+	out.snOptions.Reporter = snsync.NewReporter(os.Stderr, noProgress, c.GlobalBool("progress"))
+	if out.debug && !noProgress {
+		out.snOptions.Reporter = snsync.NewLineReporter(os.Stderr)
+	}
+
 	return
 }
 
-func main() {
-	msg, display, err := startCLI(os.Args)
-	if err != nil {
-		fmt.Printf("error: %+v\n", err)
-		os.Exit(1)
+// confirmSecrets returns the function deciding whether a file that looks like it contains secrets is still
+// pushed. Paths given with --allow-secret are, as is everything with --allow-secrets. Otherwise, when run
+// in a terminal, the user is asked about each path.
+func confirmSecrets(allowed []string, allowAll bool, home string, interactive bool) func(string, []snsync.SecretFinding) bool {
+	allow := make(map[string]bool)
+
+	for _, p := range allowed {
+		if abs, err := filepath.Abs(p); err == nil {
+			if rel, rErr := filepath.Rel(home, abs); rErr == nil {
+				p = rel
+			}
+		}
+
+		allow[filepath.ToSlash(p)] = true
 	}
 
+	return func(homeRelPath string, findings []snsync.SecretFinding) bool {
+		switch {
+		case allowAll || allow[filepath.ToSlash(homeRelPath)]:
+			return true
+		case !interactive:
+			return false
+		}
+
+		desc := make([]string, len(findings))
+		for i := range findings {
+			desc[i] = findings[i].String()
+		}
+
+		fmt.Printf("%s looks like it contains secrets: %s\npush it anyway? ", homeRelPath, strings.Join(desc, ", "))
+
+		var input string
+		_, err := fmt.Scanln(&input)
+
+		return err == nil && snsync.StringInSlice(input, []string{"y", "yes"}, false)
+	}
+}
+
+func main() {
+	// the first interrupt cancels the command, which stops at the next safe point and
+	// reports what was done; a second interrupt kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	msg, display, err := startCLI(ctx, os.Args)
+
+	// commands can return results along with an error, such as when paths failed
 	if display && msg != "" {
 		fmt.Println(msg)
 	}
 
+	if err != nil {
+		fmt.Printf("error: %+v\n", err)
+		os.Exit(exitCode(err))
+	}
+
 	os.Exit(0)
 }
 
-func startCLI(args []string) (msg string, display bool, err error) {
+// exitCode returns the exit code for the error returned by a command
+func exitCode(err error) int {
+	var pathConflict *snsync.ErrPathConflict
+
+	var pathInvalid *snsync.ErrPathInvalid
+
+	var locked *snsync.ErrLocked
+
+	switch {
+	case errors.Is(err, snsync.ErrInvalidSession):
+		return exitInvalidSession
+	case errors.Is(err, snsync.ErrNoRemoteItems):
+		return exitNoRemoteItems
+	case errors.As(err, &pathConflict):
+		return exitPathConflict
+	case errors.As(err, &pathInvalid):
+		return exitPathInvalid
+	case errors.Is(err, snsync.ErrConflict):
+		return exitConflict
+	case errors.Is(err, snsync.ErrPathsFailed):
+		return exitPathsFailed
+	case errors.As(err, &locked):
+		return exitLocked
+	case errors.Is(err, snsync.ErrOffline):
+		return exitOffline
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	default:
+		return exitError
+	}
+}
+
+func startCLI(ctx context.Context, args []string) (msg string, display bool, err error) {
 	viper.SetEnvPrefix("sn")
 
 	err = viper.BindEnv("email")
@@ -118,6 +290,31 @@ func startCLI(args []string) (msg string, display bool, err error) {
 		return "", false, err
 	}
 
+	err = viper.BindEnv("secrets_file")
+	if err != nil {
+		return "", false, err
+	}
+
+	err = viper.BindEnv("secrets_command")
+	if err != nil {
+		return "", false, err
+	}
+
+	err = viper.BindEnv("encryption_passphrase")
+	if err != nil {
+		return "", false, err
+	}
+
+	err = viper.BindEnv("encryption_keyfile")
+	if err != nil {
+		return "", false, err
+	}
+
+	viper.SetDefault("limits.max_add_files", defaultMaxAddFiles)
+	viper.SetDefault("limits.max_add_bytes", defaultMaxAddBytes)
+	viper.SetDefault("limits.max_sync_items", defaultMaxSyncItems)
+	viper.SetDefault("limits.max_sync_percent", defaultMaxSyncPercent)
+
 	if tag != "" && buildDate != "" {
 		versionOutput = fmt.Sprintf("[%s-%s] %s UTC", tag, sha, buildDate)
 	} else {
@@ -142,13 +339,21 @@ func startCLI(args []string) (msg string, display bool, err error) {
 
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{Name: "debug"},
-		cli.StringFlag{Name: "server"},
+		cli.StringFlag{Name: "config", Usage: "path to config file (default: <user config dir>/sn-sync/config.yaml)"},
+		cli.StringFlag{Name: "server", Usage: "Standard Notes server URL, or file:///path to keep items in a directory"},
 		cli.StringFlag{Name: "home-dir"},
 		cli.BoolFlag{Name: "use-session"},
 		cli.StringFlag{Name: "session-key"},
 		cli.IntFlag{Name: "page-size", Hidden: true, Value: snsync.DefaultPageSize},
 		cli.BoolFlag{Name: "quiet"},
-		cli.BoolFlag{Name: "no-stdout"},
+		cli.BoolFlag{Name: "no-stdout", Usage: "don't show progress, only the output"},
+		cli.BoolFlag{Name: "progress", Usage: "show a progress bar with the time remaining, rather than a spinner"},
+		cli.StringFlag{Name: "secrets-file", Usage: "file of name=value lines used to resolve {{secret \"name\"}} placeholders"},
+		cli.StringFlag{Name: "secrets-command", Usage: "command run with a secret name to resolve it, e.g. \"pass show\""},
+		cli.StringFlag{Name: "encryption-keyfile", Usage: "file containing the passphrase used to encrypt paths listed in encrypt_paths"},
+		cli.BoolFlag{Name: "wait", Usage: "wait for another sn-sync process using the same account to finish"},
+		cli.DurationFlag{Name: "timeout", Usage: "wait up to this long for another sn-sync process, e.g. 30s"},
+		cli.BoolFlag{Name: "offline", Usage: "use the items last synced and queue changes, without connecting to the server"},
 	}
 	app.CommandNotFound = func(c *cli.Context, command string) {
 		_, _ = fmt.Fprintf(c.App.Writer, "\ninvalid command: \"%s\" \n\n", command)
@@ -157,6 +362,12 @@ func startCLI(args []string) (msg string, display bool, err error) {
 	statusCmd := cli.Command{
 		Name:  "status",
 		Usage: "compare local and remote",
+		Flags: []cli.Flag{
+			cli.BoolTFlag{
+				Name:  "keep-going",
+				Usage: "report paths that can't be read and carry on (default true, use --keep-going=false to stop)",
+			},
+		},
 		Action: func(c *cli.Context) error {
 			var opts configOptsOutput
 			opts, err = getOpts(c)
@@ -164,18 +375,16 @@ func startCLI(args []string) (msg string, display bool, err error) {
 				return err
 			}
 			display = opts.display
+			opts.snOptions.KeepGoing = c.BoolT("keep-going")
 
 			var session cache.Session
-			session, _, err = cache.GetSession(opts.useSession, opts.sessKey, opts.server, opts.debug)
-
-			var cacheDBPath string
-			cacheDBPath, err = cache.GenCacheDBPath(session, opts.cacheDBDir, snsync.SNAppName)
-			if err != nil {
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
 				return err
 			}
-			session.CacheDBPath = cacheDBPath
+			defer func() { _ = lock.Release() }()
 
-			_, msg, err = snsync.Status(&session, opts.home, c.Args(), opts.pageSize, opts.debug, false)
+			_, msg, err = snsync.Status(ctx, &session, opts.home, c.Args(), opts.pageSize, opts.snOptions, opts.debug)
 			return err
 		},
 	}
@@ -188,9 +397,29 @@ func startCLI(args []string) (msg string, display bool, err error) {
 				Name:  "exclude",
 				Usage: "exlude path from sync",
 			},
+			cli.StringSliceFlag{
+				Name:  "allow-secret",
+				Usage: "push the file even if it looks like it contains secrets",
+			},
+			cli.BoolFlag{
+				Name:  "allow-secrets",
+				Usage: "push every file that looks like it contains secrets without asking, for scripts",
+			},
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "ignore safety limits",
+			},
+			cli.BoolFlag{
+				Name:  "include-protected",
+				Usage: "pull notes that are protected in Standard Notes",
+			},
+			cli.BoolFlag{
+				Name:  "keep-going",
+				Usage: "report paths that can't be read and sync the rest",
+			},
 		},
 		BashComplete: func(c *cli.Context) {
-			syncTasks := []string{"--exclude"}
+			syncTasks := []string{"--exclude", "--allow-secret", "--allow-secrets", "--force", "--include-protected", "--keep-going"}
 			for _, t := range syncTasks {
 				fmt.Println(t)
 			}
@@ -204,26 +433,28 @@ func startCLI(args []string) (msg string, display bool, err error) {
 			display = opts.display
 
 			var session cache.Session
-			session, _, err = cache.GetSession(opts.useSession,
-				opts.sessKey, opts.server, opts.debug)
-			var cacheDBPath string
-			cacheDBPath, err = cache.GenCacheDBPath(session, opts.cacheDBDir, snsync.SNAppName)
-			if err != nil {
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
 				return err
 			}
-			session.CacheDBPath = cacheDBPath
+			defer func() { _ = lock.Release() }()
+
+			opts.snOptions.StateDir = snsync.StateDir(session.CacheDBPath)
 
 			var so snsync.SyncOutput
-			so, err = snsync.Sync(snsync.SNDirSyncInput{
+			so, err = snsync.Sync(ctx, snsync.SNDirSyncInput{
 				Session:  &session,
 				Root:     opts.home,
 				Paths:    c.Args(),
 				Exclude:  c.StringSlice("exclude"),
 				PageSize: opts.pageSize,
+				Options:  opts.snOptions,
 				Debug:    opts.debug,
-			}, c.GlobalBool("no-stdout"))
+			})
 
-			if err != nil {
+			// results are still shown when only some paths failed or conflicted
+			if err != nil && !errors.Is(err, snsync.ErrPathsFailed) && !errors.Is(err, snsync.ErrConflict) &&
+				!errors.Is(err, context.Canceled) {
 				return err
 			}
 			msg = so.Msg
@@ -240,6 +471,22 @@ func startCLI(args []string) (msg string, display bool, err error) {
 				Name:  "all",
 				Usage: "add all sync (non-recursive)",
 			},
+			cli.StringSliceFlag{
+				Name:  "allow-secret",
+				Usage: "push the file even if it looks like it contains secrets",
+			},
+			cli.BoolFlag{
+				Name:  "allow-secrets",
+				Usage: "push every file that looks like it contains secrets without asking, for scripts",
+			},
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "ignore safety limits",
+			},
+			cli.BoolFlag{
+				Name:  "keep-going",
+				Usage: "report paths that can't be read and add the rest",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			var opts configOptsOutput
@@ -276,22 +523,19 @@ func startCLI(args []string) (msg string, display bool, err error) {
 			}
 
 			var session cache.Session
-			session, _, err = cache.GetSession(opts.useSession,
-				opts.sessKey, opts.server, opts.debug)
-			var cacheDBPath string
-			cacheDBPath, err = cache.GenCacheDBPath(session, opts.cacheDBDir, snsync.SNAppName)
-			if err != nil {
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
 				return err
 			}
-			session.CacheDBPath = cacheDBPath
+			defer func() { _ = lock.Release() }()
 
 			ai := snsync.AddInput{Session: &session, Home: opts.home, Paths: absPaths,
-				PageSize: opts.pageSize, All: c.Bool("all")}
+				PageSize: opts.pageSize, All: c.Bool("all"), Options: opts.snOptions}
 
 			var ao snsync.AddOutput
 
-			ao, err = snsync.Add(ai, true)
-			if err != nil {
+			ao, err = snsync.Add(ctx, ai)
+			if err != nil && !errors.Is(err, snsync.ErrPathsFailed) {
 				return err
 			}
 
@@ -304,6 +548,12 @@ func startCLI(args []string) (msg string, display bool, err error) {
 	removeCmd := cli.Command{
 		Name:  "remove",
 		Usage: "stop tracking file(s)",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "ignore safety limits",
+			},
+		},
 		Action: func(c *cli.Context) error {
 			if len(c.Args()) == 0 {
 				_ = cli.ShowCommandHelp(c, "remove")
@@ -324,27 +574,24 @@ func startCLI(args []string) (msg string, display bool, err error) {
 			}
 
 			var session cache.Session
-			session, _, err = cache.GetSession(opts.useSession,
-				opts.sessKey, opts.server,
-				opts.debug)
-			var cacheDBPath string
-			cacheDBPath, err = cache.GenCacheDBPath(session, opts.cacheDBDir, snsync.SNAppName)
-			if err != nil {
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
 				return err
 			}
-			session.CacheDBPath = cacheDBPath
+			defer func() { _ = lock.Release() }()
 
 			ri := snsync.RemoveInput{
 				Session:  &session,
 				Home:     opts.home,
 				Paths:    c.Args(),
 				PageSize: opts.pageSize,
+				Options:  opts.snOptions,
 				Debug:    opts.debug,
 			}
 
 			var ro snsync.RemoveOutput
 
-			ro, err = snsync.Remove(ri, c.Bool("no-stdout"))
+			ro, err = snsync.Remove(ctx, ri)
 			if err != nil {
 				return err
 			}
@@ -354,9 +601,9 @@ func startCLI(args []string) (msg string, display bool, err error) {
 		},
 	}
 
-	diffCmd := cli.Command{
-		Name:  "diff",
-		Usage: "display differences between local and remote",
+	repairCmd := cli.Command{
+		Name:  "repair",
+		Usage: "reset tracked notes opened in an editor other than plain text",
 		Action: func(c *cli.Context) error {
 			var opts configOptsOutput
 			opts, err = getOpts(c)
@@ -366,19 +613,217 @@ func startCLI(args []string) (msg string, display bool, err error) {
 			display = opts.display
 
 			var session cache.Session
-			session, _, err = cache.GetSession(opts.useSession,
-				opts.sessKey, opts.server, opts.debug)
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
+				return err
+			}
+			defer func() { _ = lock.Release() }()
 
-			var cacheDBPath string
+			var ro snsync.RepairOutput
 
-			cacheDBPath, err = cache.GenCacheDBPath(session, opts.cacheDBDir, snsync.SNAppName)
+			ro, err = snsync.Repair(ctx, snsync.RepairInput{
+				Session:  &session,
+				Home:     opts.home,
+				Paths:    c.Args(),
+				PageSize: opts.pageSize,
+				Options:  opts.snOptions,
+				Debug:    opts.debug,
+			})
 			if err != nil {
 				return err
 			}
+			msg = ro.Msg
 
-			session.CacheDBPath = cacheDBPath
+			return err
+		},
+	}
 
-			_, msg, err = snsync.Diff(&session, opts.home, c.Args(), opts.pageSize, true, c.Bool("no-stdout"))
+	verifyCmd := cli.Command{
+		Name:  "verify",
+		Usage: "check tracked notes and local files against the checksums recorded when last synced",
+		Action: func(c *cli.Context) error {
+			var opts configOptsOutput
+			opts, err = getOpts(c)
+			if err != nil {
+				return err
+			}
+			display = opts.display
+
+			var session cache.Session
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
+				return err
+			}
+			defer func() { _ = lock.Release() }()
+
+			opts.snOptions.StateDir = snsync.StateDir(session.CacheDBPath)
+
+			var vo snsync.VerifyOutput
+
+			vo, err = snsync.Verify(ctx, snsync.VerifyInput{
+				Session:  &session,
+				Home:     opts.home,
+				Paths:    c.Args(),
+				PageSize: opts.pageSize,
+				Options:  opts.snOptions,
+				Debug:    opts.debug,
+			})
+			if err != nil {
+				return err
+			}
+			msg = vo.Msg
+
+			// fail so verify can be run from cron
+			if vo.NoCorrupt > 0 {
+				return fmt.Errorf("%d items failed verification", vo.NoCorrupt)
+			}
+
+			return err
+		},
+	}
+
+	doctorCmd := cli.Command{
+		Name:  "doctor",
+		Usage: "find and fix problems in the remote tree that stop paths being synced",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "fix",
+				Usage: "fix every problem found without asking",
+			},
+			cli.BoolFlag{
+				Name:  "interactive",
+				Usage: "ask whether to fix each problem found",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			var opts configOptsOutput
+			opts, err = getOpts(c)
+			if err != nil {
+				return err
+			}
+			display = opts.display
+
+			var session cache.Session
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
+				return err
+			}
+			defer func() { _ = lock.Release() }()
+
+			di := snsync.DoctorInput{
+				Session:  &session,
+				Home:     opts.home,
+				PageSize: opts.pageSize,
+				Fix:      c.Bool("fix"),
+				Options:  opts.snOptions,
+				Debug:    opts.debug,
+			}
+
+			if c.Bool("interactive") {
+				di.Confirm = func(problem, fix string) bool {
+					fmt.Printf("%s\nfix: %s? ", problem, fix)
+					var input string
+					_, err = fmt.Scanln(&input)
+
+					return err == nil && snsync.StringInSlice(input, []string{"y", "yes"}, false)
+				}
+			}
+
+			var do snsync.DoctorOutput
+
+			do, err = snsync.Doctor(ctx, di)
+			if err != nil {
+				return err
+			}
+			msg = do.Msg
+
+			return err
+		},
+	}
+
+	setEditorCmd := cli.Command{
+		Name:  "set-editor",
+		Usage: "set tracked notes to open with the editor configured for their path",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "editor",
+				Usage: "identifier or name of the editor to use for the given paths, e.g. " + snsync.CodeEditorIdentifier,
+			},
+			cli.StringFlag{
+				Name:  "mode",
+				Usage: "language mode of the editor, e.g. shell",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			var opts configOptsOutput
+			opts, err = getOpts(c)
+			if err != nil {
+				return err
+			}
+			display = opts.display
+
+			if c.String("editor") != "" {
+				if len(c.Args()) == 0 {
+					_ = cli.ShowCommandHelp(c, "set-editor")
+					return errors.New("paths must be specified with --editor")
+				}
+
+				opts.snOptions.Editors = map[string]snsync.EditorSetting{
+					"*": {Editor: c.String("editor"), Mode: c.String("mode")},
+				}
+			}
+
+			var session cache.Session
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
+				return err
+			}
+			defer func() { _ = lock.Release() }()
+
+			var so snsync.SetEditorOutput
+
+			so, err = snsync.SetEditor(ctx, snsync.SetEditorInput{
+				Session:  &session,
+				Home:     opts.home,
+				Paths:    c.Args(),
+				PageSize: opts.pageSize,
+				Options:  opts.snOptions,
+				Debug:    opts.debug,
+			})
+			if err != nil {
+				return err
+			}
+			msg = so.Msg
+
+			return err
+		},
+	}
+
+	diffCmd := cli.Command{
+		Name:  "diff",
+		Usage: "display differences between local and remote",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "keep-going",
+				Usage: "report paths that can't be read and compare the rest",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			var opts configOptsOutput
+			opts, err = getOpts(c)
+			if err != nil {
+				return err
+			}
+			display = opts.display
+
+			var session cache.Session
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
+				return err
+			}
+			defer func() { _ = lock.Release() }()
+
+			_, msg, err = snsync.Diff(ctx, &session, opts.home, c.Args(), opts.pageSize, opts.snOptions, true)
 
 			return err
 		},
@@ -476,6 +921,10 @@ func startCLI(args []string) (msg string, display bool, err error) {
 			}
 			display = opts.display
 
+			if _, ok := snsync.DirStorePath(opts.server); ok {
+				return errors.New("wipe doesn't support file:// servers, remove the directory instead")
+			}
+
 			var email string
 			var session cache.Session
 			session, email, err = cache.GetSession(opts.useSession,
@@ -488,6 +937,12 @@ func startCLI(args []string) (msg string, display bool, err error) {
 			}
 			session.CacheDBPath = cacheDBPath
 
+			var lock *snsync.Lock
+			if lock, err = lockCache(ctx, opts, cacheDBPath); err != nil {
+				return err
+			}
+			defer func() { _ = lock.Release() }()
+
 			var proceed bool
 			if c.Bool("force") {
 				proceed = true
@@ -501,7 +956,7 @@ func startCLI(args []string) (msg string, display bool, err error) {
 			}
 			if proceed {
 				var num int
-				num, err = snsync.WipeDotfileTagsAndNotes(&session, opts.pageSize, c.Bool("no-stdout"))
+				num, err = snsync.WipeDotfileTagsAndNotes(ctx, &session, opts.pageSize, opts.snOptions.Reporter)
 				if err != nil {
 					return err
 				}
@@ -514,12 +969,63 @@ func startCLI(args []string) (msg string, display bool, err error) {
 		},
 	}
 
+	queueCmd := cli.Command{
+		Name:  "queue",
+		Usage: "list changes made offline that are waiting to be pushed",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "clear",
+				Usage: "drop the queued changes, leaving local files as they are",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			var opts configOptsOutput
+			opts, err = getOpts(c)
+			if err != nil {
+				return err
+			}
+			display = opts.display
+
+			var session cache.Session
+			var lock *snsync.Lock
+			if session, lock, err = openAccount(ctx, &opts); err != nil {
+				return err
+			}
+			defer func() { _ = lock.Release() }()
+
+			if c.Bool("clear") {
+				var cleared int
+				if cleared, err = snsync.ClearQueue(&session); err != nil {
+					return err
+				}
+
+				msg = fmt.Sprintf("dropped %d queued changes", cleared)
+
+				return nil
+			}
+
+			var qo snsync.QueueOutput
+			if qo, err = snsync.Queue(&session); err != nil {
+				return err
+			}
+
+			msg = qo.Msg
+
+			return nil
+		},
+	}
+
 	app.Commands = []cli.Command{
 		statusCmd,
 		syncCmd,
 		addCmd,
 		removeCmd,
+		repairCmd,
+		setEditorCmd,
+		doctorCmd,
+		verifyCmd,
 		diffCmd,
+		queueCmd,
 		sessionCmd,
 		wipeCmd,
 	}
@@ -529,6 +1035,88 @@ func startCLI(args []string) (msg string, display bool, err error) {
 	return msg, display, app.Run(args)
 }
 
+// lockCache takes the lock for the account, so commands using the same cache db and files don't run at once
+func lockCache(ctx context.Context, opts configOptsOutput, cacheDBPath string) (*snsync.Lock, error) {
+	wait := opts.lockWait
+
+	if opts.lockTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, opts.lockTimeout)
+		defer cancel()
+
+		wait = true
+	}
+
+	return snsync.AcquireLock(ctx, cacheDBPath, wait, opts.debug)
+}
+
+// openAccount signs in to the server and takes the lock for the account. If the server is a file:// directory,
+// it's used as the store instead, with the email and password used to encrypt its items, and the session only
+// holds the path used for its local sync state.
+func openAccount(ctx context.Context, opts *configOptsOutput) (s cache.Session, lock *snsync.Lock, err error) {
+	if dir, ok := snsync.DirStorePath(opts.server); ok {
+		email, password, _, errMsg := session.GetCredentials(opts.server)
+		if errMsg != "" {
+			return s, nil, errors.New(errMsg)
+		}
+
+		s = cache.Session{Session: &session.Session{Debug: opts.debug, Server: opts.server}}
+
+		if s.CacheDBPath, err = snsync.DirStoreLocalPath(dir, opts.cacheDBDir); err != nil {
+			return
+		}
+
+		opts.snOptions.Store = snsync.NewDirStore(dir, email, password, opts.debug)
+	} else {
+		// signing in needs the server, so a saved session is needed offline
+		if opts.snOptions.Offline && !opts.useSession {
+			return s, nil, fmt.Errorf("%w: a session saved with the session command is needed, use --use-session", snsync.ErrOffline)
+		}
+
+		if s, _, err = cache.GetSession(opts.useSession, opts.sessKey, opts.server, opts.debug); err != nil {
+			return
+		}
+
+		if s.CacheDBPath, err = cache.GenCacheDBPath(s, opts.cacheDBDir, snsync.SNAppName); err != nil {
+			return
+		}
+	}
+
+	lock, err = lockCache(ctx, *opts, s.CacheDBPath)
+
+	return s, lock, err
+}
+
+// loadConfig reads the config file, if one exists, so its settings can be retrieved with viper
+func loadConfig(path string) error {
+	if path != "" {
+		viper.SetConfigFile(path)
+
+		return viper.ReadInConfig()
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil
+	}
+
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(filepath.Join(configDir, snsync.SNAppName))
+
+	if err = viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
 func numTrue(in ...bool) (total int) {
 	for _, i := range in {
 		if i {
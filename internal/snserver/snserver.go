@@ -0,0 +1,538 @@
+// Package snserver is an in-process fake of the Standard Notes API, so sn-sync can be tested without an account.
+// It implements sign in and items sync for a single account using 004 encryption, as used by gosn-v2.
+package snserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonhadfield/gosn-v2/auth"
+	"github.com/jonhadfield/gosn-v2/common"
+	"github.com/jonhadfield/gosn-v2/crypto"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/jonhadfield/gosn-v2/session"
+	"github.com/lithammer/shortuuid"
+)
+
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+// Server is a Standard Notes server holding one account, created with an items key so items can be encrypted
+type Server struct {
+	*httptest.Server
+
+	Email    string
+	Password string
+
+	mu             sync.Mutex
+	keyParams      auth.KeyParams
+	serverPassword string
+	challenge      string
+	// tokens are the access tokens of the sessions signed in, mapped to their refresh tokens
+	tokens map[string]string
+	items  map[string]stored
+	// changes counts the saves made, and is the sync token given to clients
+	changes  int64
+	lastTime int64
+	failures []failure
+	pushes   int
+	onPush   func()
+}
+
+// stored is an item and the change that last saved it
+type stored struct {
+	item   items.EncryptedItem
+	change int64
+}
+
+type failure struct {
+	status int
+	save   bool
+}
+
+type conflict struct {
+	ServerItem  *items.EncryptedItem `json:"server_item,omitempty"`
+	UnsavedItem *items.EncryptedItem `json:"unsaved_item,omitempty"`
+	Type        string               `json:"type"`
+}
+
+type syncRequest struct {
+	Items     items.EncryptedItems `json:"items"`
+	SyncToken string               `json:"sync_token"`
+	Limit     int                  `json:"limit"`
+}
+
+type syncResponse struct {
+	RetrievedItems items.EncryptedItems `json:"retrieved_items"`
+	SavedItems     items.EncryptedItems `json:"saved_items"`
+	Conflicts      []conflict           `json:"conflicts"`
+	SyncToken      string               `json:"sync_token"`
+}
+
+// New starts a server with an account for the email and password
+func New(email, password string) (*Server, error) {
+	s := &Server{
+		Email:    email,
+		Password: password,
+		keyParams: auth.KeyParams{
+			Created:     strconv.FormatInt(time.Now().UnixMilli(), 10),
+			Identifier:  email,
+			Origination: "registration",
+			PwNonce:     hex.EncodeToString(crypto.GenerateNonce())[:32],
+			Version:     common.DefaultSNVersion,
+		},
+		items:  make(map[string]stored),
+		tokens: make(map[string]string),
+	}
+
+	mk, sp, err := crypto.GenerateMasterKeyAndServerPassword004(crypto.GenerateEncryptedPasswordInput{
+		UserPassword:  password,
+		Identifier:    email,
+		PasswordNonce: s.keyParams.PwNonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.serverPassword = sp
+
+	ik, err := newItemsKey(mk, s.keyParams)
+	if err != nil {
+		return nil, err
+	}
+
+	s.save(ik)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(common.AuthParamsPath, s.handleLoginParams)
+	mux.HandleFunc(common.SignInPath, s.handleLogin)
+	mux.HandleFunc(common.AuthRefreshPath, s.handleRefresh)
+	mux.HandleFunc(common.SyncPath, s.handleSync)
+
+	s.Server = httptest.NewServer(mux)
+
+	return s, nil
+}
+
+// Session signs in to the server, as the CLI does, and returns the session
+func (s *Server) Session(debug bool) (*session.Session, error) {
+	so, err := auth.SignIn(auth.SignInInput{
+		Email:     s.Email,
+		Password:  s.Password,
+		APIServer: s.URL,
+		Debug:     debug,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &session.Session{
+		Debug:             debug,
+		Server:            s.URL,
+		MasterKey:         so.Session.MasterKey,
+		KeyParams:         so.Session.KeyParams,
+		AccessToken:       so.Session.AccessToken,
+		RefreshToken:      so.Session.RefreshToken,
+		AccessExpiration:  so.Session.AccessExpiration,
+		RefreshExpiration: so.Session.RefreshExpiration,
+		PasswordNonce:     so.Session.PasswordNonce,
+	}, nil
+}
+
+// FailPushes makes the next n sync requests that save items fail with the status. If save is set, the items
+// are saved before the failure is returned, as when the connection drops after the server has handled a push.
+func (s *Server) FailPushes(n, status int, save bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status: status, save: save})
+	}
+}
+
+// OnNextPush runs f before the next sync request that saves items is handled, such as to change an item
+// as another device would between the client fetching and pushing it
+func (s *Server) OnNextPush(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onPush = f
+}
+
+// Touch saves the item again without changing it, as if edited on another device, returning false if
+// it doesn't exist
+func (s *Server) Touch(uuid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, found := s.items[uuid]
+	if !found || st.item.Deleted {
+		return false
+	}
+
+	s.save(st.item)
+
+	return true
+}
+
+// Pushes returns the number of sync requests that saved items, or failed to
+func (s *Server) Pushes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pushes
+}
+
+// Items returns the items of the content type that aren't deleted, oldest first
+func (s *Server) Items(contentType string) (its items.EncryptedItems) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, st := range s.items {
+		if !st.item.Deleted && st.item.ContentType == contentType {
+			its = append(its, st.item)
+		}
+	}
+
+	sort.Slice(its, func(i, j int) bool {
+		return its[i].UpdatedAtTimestamp < its[j].UpdatedAtTimestamp
+	})
+
+	return its
+}
+
+// Reset deletes every item but the items key, as if the account were new
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for uuid, st := range s.items {
+		if st.item.ContentType != common.SNItemTypeItemsKey {
+			delete(s.items, uuid)
+		}
+	}
+
+	s.failures = nil
+	s.onPush = nil
+}
+
+// newItemsKey creates an items key encrypted with the master key, as a client does on registration
+func newItemsKey(mk string, kp auth.KeyParams) (ei items.EncryptedItem, err error) {
+	content, err := json.Marshal(items.ItemsKeyContent{
+		ItemsKey: crypto.GenerateItemKey(64),
+		Version:  common.DefaultSNVersion,
+		Default:  true,
+	})
+	if err != nil {
+		return
+	}
+
+	ei.UUID = items.GenUUID()
+	ei.ContentType = common.SNItemTypeItemsKey
+
+	authData := base64.StdEncoding.EncodeToString([]byte(auth.GenerateAuthData(ei.ContentType, ei.UUID, kp)))
+	itemKey := crypto.GenerateItemKey(64)
+
+	ei.Content, err = encrypt(string(content), itemKey, authData)
+	if err != nil {
+		return
+	}
+
+	ei.EncItemKey, err = encrypt(itemKey, mk, authData)
+
+	return ei, err
+}
+
+func encrypt(plainText, key, authData string) (string, error) {
+	nonce := hex.EncodeToString(crypto.GenerateNonce())
+
+	cipherText, err := crypto.EncryptString(plainText, key, nonce, authData, 32)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("004:%s:%s:%s", nonce, cipherText, authData), nil
+}
+
+// save stores the item as the latest change, setting its updated time. Deleted items keep only their metadata.
+func (s *Server) save(ei items.EncryptedItem) items.EncryptedItem {
+	now := time.Now().UTC().UnixMicro()
+	if now <= s.lastTime {
+		now = s.lastTime + 1
+	}
+
+	s.lastTime = now
+	s.changes++
+
+	if existing, found := s.items[ei.UUID]; found {
+		ei.CreatedAt = existing.item.CreatedAt
+		ei.CreatedAtTimestamp = existing.item.CreatedAtTimestamp
+	}
+
+	if ei.CreatedAtTimestamp == 0 {
+		ei.CreatedAtTimestamp = now
+		ei.CreatedAt = time.UnixMicro(now).UTC().Format(timeLayout)
+	}
+
+	ei.UpdatedAtTimestamp = now
+	ei.UpdatedAt = time.UnixMicro(now).UTC().Format(timeLayout)
+
+	if ei.Deleted {
+		ei.Content = ""
+		ei.EncItemKey = ""
+		ei.ItemsKeyID = ""
+	}
+
+	s.items[ei.UUID] = stored{item: ei, change: s.changes}
+
+	return ei
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{
+		"data": map[string]interface{}{
+			"error": map[string]string{"message": msg},
+		},
+	})
+}
+
+func (s *Server) handleLoginParams(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email         string `json:"email"`
+		CodeChallenge string `json:"code_challenge"`
+	}
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.challenge = req.CodeChallenge
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]string{
+			"identifier": s.keyParams.Identifier,
+			"pw_nonce":   s.keyParams.PwNonce,
+			"version":    s.keyParams.Version,
+		},
+	})
+}
+
+// challengeFor returns the code challenge for the verifier, as generated by gosn
+func challengeFor(verifier string) string {
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(verifier)))
+
+	return base64.URLEncoding.EncodeToString([]byte(hash))[:86]
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		CodeVerifier string `json:"code_verifier"`
+	}
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.challenge == "" || challengeFor(req.CodeVerifier) != s.challenge:
+		writeError(w, http.StatusUnauthorized, "Invalid login code")
+
+		return
+	case req.Email != s.Email || req.Password != s.serverPassword:
+		writeError(w, http.StatusUnauthorized, "Invalid email or password")
+
+		return
+	}
+
+	s.challenge = ""
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"session":    s.newTokens(),
+			"key_params": s.keyParams,
+			"user": auth.User{
+				UUID:            "user-" + s.keyParams.PwNonce[:8],
+				Email:           s.Email,
+				ProtocolVersion: s.keyParams.Version,
+			},
+		},
+	})
+}
+
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if refresh, ok := s.tokens[req.AccessToken]; !ok || refresh != req.RefreshToken {
+		writeError(w, http.StatusUnauthorized, "Invalid refresh token")
+
+		return
+	}
+
+	delete(s.tokens, req.AccessToken)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"session": s.newTokens(),
+		},
+	})
+}
+
+// newTokens creates tokens for a session, returning them with their expiry times in milliseconds
+func (s *Server) newTokens() map[string]interface{} {
+	access, refresh := shortuuid.New(), shortuuid.New()
+	s.tokens[access] = refresh
+
+	return map[string]interface{}{
+		"access_token":       access,
+		"refresh_token":      refresh,
+		"access_expiration":  time.Now().Add(24 * time.Hour).UnixMilli(),
+		"refresh_expiration": time.Now().Add(30 * 24 * time.Hour).UnixMilli(),
+		"readonly_access":    false,
+	}
+}
+
+// handleSync saves the items sent and returns those changed since the sync token. An item is refused with
+// a sync conflict if it's been saved since the client fetched it, so its updated time doesn't match.
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	var req syncRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if len(req.Items) > 0 {
+		s.mu.Lock()
+		onPush := s.onPush
+		s.onPush = nil
+		s.mu.Unlock()
+
+		if onPush != nil {
+			onPush()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]; !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid login credentials")
+
+		return
+	}
+
+	var fail *failure
+
+	if len(req.Items) > 0 {
+		s.pushes++
+
+		if len(s.failures) > 0 {
+			fail = &s.failures[0]
+			s.failures = s.failures[1:]
+
+			if !fail.save {
+				writeError(w, fail.status, "push failed")
+
+				return
+			}
+		}
+	}
+
+	since, _ := strconv.ParseInt(strings.TrimSpace(req.SyncToken), 10, 64)
+
+	resp := syncResponse{
+		RetrievedItems: items.EncryptedItems{},
+		SavedItems:     items.EncryptedItems{},
+		Conflicts:      []conflict{},
+	}
+
+	handled := make(map[string]bool)
+
+	for _, ei := range req.Items {
+		handled[ei.UUID] = true
+
+		if existing, found := s.items[ei.UUID]; found && existing.item.UpdatedAtTimestamp != ei.UpdatedAtTimestamp {
+			serverItem := existing.item
+			resp.Conflicts = append(resp.Conflicts, conflict{ServerItem: &serverItem, Type: "sync_conflict"})
+
+			continue
+		}
+
+		resp.SavedItems = append(resp.SavedItems, s.save(ei))
+	}
+
+	if fail != nil {
+		writeError(w, fail.status, "push failed")
+
+		return
+	}
+
+	for _, st := range s.items {
+		if st.change <= since || handled[st.item.UUID] {
+			continue
+		}
+
+		// deletions are only sent to clients that have the item
+		if st.item.Deleted && since == 0 {
+			continue
+		}
+
+		resp.RetrievedItems = append(resp.RetrievedItems, st.item)
+	}
+
+	sort.Slice(resp.RetrievedItems, func(i, j int) bool {
+		return resp.RetrievedItems[i].UpdatedAtTimestamp < resp.RetrievedItems[j].UpdatedAtTimestamp
+	})
+
+	resp.SyncToken = strconv.FormatInt(s.changes, 10)
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -1,26 +1,25 @@
 package snsync
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
-	"github.com/asdine/storm/v3"
-	"github.com/briandowns/spinner"
 	"github.com/jonhadfield/gosn-v2/cache"
 	"github.com/jonhadfield/gosn-v2/items"
 	"github.com/ryanuber/columnize"
 )
 
 // Add tracks local Paths by pushing the local dir as a tag representation and the filename as a note title
-func Add(ai AddInput, useStdErr bool) (ao AddOutput, err error) {
-	// validate session
-	if !ai.Session.Valid() {
-		err = errors.New("invalid session")
+func Add(ctx context.Context, ai AddInput) (ao AddOutput, err error) {
+	r := ai.Options.reporter()
+	defer func() { r.Finished(err) }()
+
+	if err = checkSession(ai.Session, ai.Options); err != nil {
 		return
 	}
 
@@ -51,58 +50,150 @@ func Add(ai AddInput, useStdErr bool) (ao AddOutput, err error) {
 
 	debugPrint(ai.Session.Debug, fmt.Sprintf("Add | paths after dedupe: %d", len(ai.Paths)))
 
-	if !ai.Session.Debug {
-		prefix := HiWhite("syncing ")
-		if _, err = os.Stat(ai.Session.CacheDBPath); os.IsNotExist(err) {
-			prefix = HiWhite("initializing ")
-		}
+	r.PhaseStarted(loadPhase(ai.Session, ai.Options), 0)
 
-		s := spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stdout))
-		if useStdErr {
-			s = spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stderr))
-		}
+	store := remoteStore(ai.Options, ai.Session)
+
+	var twn tagsWithNotes
+
+	var allItems items.Items
+
+	twn, allItems, err = getTagsWithNotes(ctx, store)
+	if err != nil {
+		return
+	}
+	// run pre-checks
+	err = checkNoteTagConflicts(twn)
+	if err != nil {
+		_ = store.Close()
 
-		s.Prefix = prefix
-		s.Start()
-		defer s.Stop()
+		return
 	}
 
-	// get populated db
-	si := cache.SyncInput{
-		Session: ai.Session,
-		Close:   false,
+	ai.Twn = twn
+
+	if len(ai.Options.Editors) > 0 {
+		ai.Options.editorComponents = getEditorComponents(allItems)
 	}
 
-	var cso cache.SyncOutput
+	b := &batch{}
 
-	cso, err = cache.Sync(si)
+	ao, err = add(ctx, b, ai, noRecurse)
 	if err != nil {
+		_ = store.Close()
+
 		return
 	}
 
-	var twn tagsWithNotes
+	// push the new notes and tags
+	var rejected []string
 
-	twn, err = getTagsWithNotes(cso.DB, ai.Session)
+	r.PhaseStarted(PhasePushing, len(ao.PathsAdded))
+
+	rejected, err = pushChanges(ctx, store, b)
 	if err != nil {
 		return
 	}
-	// run pre-checks
-	err = checkNoteTagConflicts(twn)
-	if err != nil {
+
+	for _, path := range ao.PathsAdded {
+		r.ItemPushed(stripHome(path, ai.Home))
+	}
+
+	// record the content added as the base for merging later changes on both sides
+	if err = recordAdded(ai.Options, ao.added); err != nil {
 		return
 	}
 
-	ai.Twn = twn
+	ao.Msg = appendQueueResults(ao.Msg, store)
+
+	// report paths that couldn't be read once the rest are added
+	if len(ao.PathsFailed) > 0 {
+		defer func() {
+			if err == nil {
+				err = pathsFailedError(len(ao.PathsFailed))
+			}
+		}()
+	}
+
+	// only existing tags can be refused, as new notes and tags have only just been created
+	var rejectedTags []string
 
-	ao, err = add(cso.DB, ai, noRecurse)
-	si.CacheDB = cso.DB
-	// syncDBwithFS db back to SN
-	si.Close = true
-	cso, err = cache.Sync(si)
+	for _, uuid := range rejected {
+		if _, found := ao.tagRefs[uuid]; found {
+			rejectedTags = append(rejectedTags, uuid)
+		}
+	}
+
+	if len(rejectedTags) == 0 {
+		return
+	}
+
+	// tags changed on another device are fetched again, so add the references to the server's version
+	if err = retagRejected(ctx, store, rejectedTags, ao.tagRefs); err != nil {
+		return ao, fmt.Errorf("tags changed on another device while adding, run add again: %w", err)
+	}
 
 	return
 }
 
+// recordAdded records the plain text of each note added as synced
+func recordAdded(opts Options, added []ItemDiff) error {
+	for _, d := range added {
+		text := d.remote.Content.GetText()
+		if isEncrypted(text) {
+			var err error
+
+			if text, err = decryptText(text, opts.Encryption.Passphrase); err != nil {
+				return err
+			}
+		}
+
+		if err := recordSynced(opts.StateDir, d, text); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retagRejected adds the references of notes just added to the tags the server refused, and pushes them again
+func retagRejected(ctx context.Context, store RemoteStore, rejected []string, tagRefs map[string]items.ItemReferences) (err error) {
+	var twn tagsWithNotes
+
+	twn, _, err = getTagsWithNotes(ctx, store)
+	if err != nil {
+		return
+	}
+
+	b := &batch{}
+
+	for _, t := range twn {
+		if !StringInSlice(t.tag.UUID, rejected, true) {
+			continue
+		}
+
+		tag := t.tag
+		tag.Content.UpsertReferences(tagRefs[tag.UUID])
+		b.add(&tag)
+	}
+
+	if len(b.items) != len(rejected) {
+		_ = store.Close()
+
+		return fmt.Errorf("%w: tag deleted on another device", ErrConflict)
+	}
+
+	if rejected, err = pushChanges(ctx, store, b); err != nil {
+		return
+	}
+
+	if len(rejected) > 0 {
+		return fmt.Errorf("%w: tag changed again", ErrConflict)
+	}
+
+	return nil
+}
+
 type AddInput struct {
 	Session  *cache.Session
 	Home     string
@@ -110,35 +201,60 @@ type AddInput struct {
 	All      bool
 	Twn      tagsWithNotes
 	PageSize int
+	Options  Options
 }
 
 type AddOutput struct {
 	TagsPushed, NotesPushed                 int
 	PathsAdded, PathsExisting, PathsInvalid []string
-	Msg                                     string
+	PathsRefused                            []string
+	// PathsFailed are the paths that couldn't be read when Options.KeepGoing is set
+	PathsFailed []string
+	Msg         string
+	// tagRefs are the references added to existing tags, by tag uuid
+	tagRefs map[string]items.ItemReferences
+	// added are the notes added, by path, to record as synced once pushed
+	added []ItemDiff
 }
 
-func add(db *storm.DB, ai AddInput, noRecurse bool) (ao AddOutput, err error) {
+func add(ctx context.Context, b *batch, ai AddInput, noRecurse bool) (ao AddOutput, err error) {
 	var tagToItemMap map[string]items.Items
 
 	var fsPathsToAdd []string
 
+	var failures []ItemDiff
+
 	// generate list of Paths to add
-	fsPathsToAdd, err = getLocalFSPaths(ai.Paths, noRecurse)
+	fsPathsToAdd, failures, err = getLocalFSPaths(ctx, ai.Paths, ai.Home, noRecurse, ai.Options.KeepGoing)
 	if err != nil {
 		return
 	}
 
+	for _, f := range failures {
+		ao.PathsFailed = append(ao.PathsFailed, f.path)
+		ai.Options.reporter().Error(f.homeRelPath, f.err)
+	}
+
+	summary, _ := failureSummary(failures)
+
 	if len(fsPathsToAdd) == 0 {
+		ao.Msg = summary
+
 		return
 	}
 
 	var statusLines []string
 
-	statusLines, tagToItemMap, ao.PathsAdded, ao.PathsExisting, err = generateTagItemMap(fsPathsToAdd, ai.Home, ai.Twn)
+	statusLines, tagToItemMap, ao.PathsAdded, ao.PathsExisting, ao.PathsRefused, err = generateTagItemMap(fsPathsToAdd, ai.Home, ai.Twn, ai.Options)
 	if err != nil {
 		return
 	}
+
+	if !ai.Options.Force {
+		if err = ai.Options.Limits.checkAdd(ao.PathsAdded); err != nil {
+			return
+		}
+	}
 	// add DotFilesTag tag if missing
 	_, dotFilesTagInTagToItemMap := tagToItemMap[DotFilesTag]
 	if !tagExists("sync", ai.Twn) && !dotFilesTagInTagToItemMap {
@@ -147,27 +263,52 @@ func add(db *storm.DB, ai AddInput, noRecurse bool) (ao AddOutput, err error) {
 		tagToItemMap[DotFilesTag] = items.Items{}
 	}
 
+	ao.tagRefs = make(map[string]items.ItemReferences)
+
+	for tagTitle, notes := range tagToItemMap {
+		if tag, found := getTagIfExists(tagTitle, ai.Twn); found {
+			for _, note := range notes {
+				ao.tagRefs[tag.UUID] = append(ao.tagRefs[tag.UUID], items.ItemReference{
+					UUID:        note.GetUUID(),
+					ContentType: "Note",
+				})
+			}
+		}
+	}
+
 	// addToDB and tag items
-	ao.TagsPushed, ao.NotesPushed, err = pushAndTag(db, ai.Session, tagToItemMap, ai.Twn)
+	ao.TagsPushed, ao.NotesPushed, err = pushAndTag(b, tagToItemMap, ai.Twn)
 	if err != nil {
 		return
 	}
 
 	debugPrint(ai.Session.Debug, fmt.Sprintf("Add | tags pushed: %d notes pushed %d", ao.TagsPushed, ao.NotesPushed))
 
-	ao.Msg = fmt.Sprint(columnize.SimpleFormat(statusLines))
+	for _, path := range ao.PathsAdded {
+		dir, filename := filepath.Split(path)
+
+		for _, it := range tagToItemMap[pathToTag(stripHome(dir, ai.Home))] {
+			if note, ok := it.(*items.Note); ok && note.Content.GetTitle() == filename {
+				ao.added = append(ao.added, ItemDiff{path: path, homeRelPath: stripHome(path, ai.Home), remote: *note})
+			}
+		}
+	}
+
+	ao.Msg = fmt.Sprint(columnize.SimpleFormat(statusLines)) + summary
 
 	return ao, err
 }
 
-func generateTagItemMap(fsPaths []string, home string, twn tagsWithNotes) (statusLines []string,
-	tagToItemMap map[string]items.Items, pathsAdded, pathsExisting []string, err error) {
+func generateTagItemMap(fsPaths []string, home string, twn tagsWithNotes, opts Options) (statusLines []string,
+	tagToItemMap map[string]items.Items, pathsAdded, pathsExisting, pathsRefused []string, err error) {
 	tagToItemMap = make(map[string]items.Items)
 
 	var added []string
 
 	var existing []string
 
+	var refusedLines []string
+
 	for _, path := range fsPaths {
 		dir, filename := filepath.Split(path)
 		homeRelPath := stripHome(dir+filename, home)
@@ -185,41 +326,65 @@ func generateTagItemMap(fsPaths []string, home string, twn tagsWithNotes) (statu
 			continue
 		} else if existingCount > 1 {
 			err = fmt.Errorf("duplicate items found with name '%s' and tag '%s'", filename, remoteTagTitle)
-			return statusLines, tagToItemMap, pathsAdded, pathsExisting, err
+			return statusLines, tagToItemMap, pathsAdded, pathsExisting, pathsRefused, err
 		}
-		// now add
-		pathsAdded = append(pathsAdded, path)
 
 		var itemToAdd items.Note
 
-		itemToAdd, err = createItem(path, filename)
+		itemToAdd, err = createItem(path, homeRelPath, filename, opts)
 		if err != nil {
 			return
 		}
 
+		// refuse to push anything that looks like a private key or credential
+		if sErr := checkForSecrets(homeRelPath, itemToAdd.Content.GetText(), opts); sErr != nil {
+			refusedLines = append(refusedLines, fmt.Sprintf("%s | %s | %s", boldHomeRelPath, red(refused), sErr))
+			pathsRefused = append(pathsRefused, path)
+
+			continue
+		}
+
+		// now add
+		pathsAdded = append(pathsAdded, path)
+
 		tagToItemMap[remoteTagTitle] = append(tagToItemMap[remoteTagTitle], &itemToAdd)
 		added = append(added, fmt.Sprintf("%s | %s", boldHomeRelPath, green("now tracked")))
 	}
 
 	statusLines = append(statusLines, existing...)
+	statusLines = append(statusLines, refusedLines...)
 	statusLines = append(statusLines, added...)
 
-	return statusLines, tagToItemMap, pathsAdded, pathsExisting, err
+	return statusLines, tagToItemMap, pathsAdded, pathsExisting, pathsRefused, err
 }
 
-func getLocalFSPaths(paths []string, noRecurse bool) (finalPaths []string, err error) {
+func getLocalFSPaths(ctx context.Context, paths []string, home string, noRecurse, keepGoing bool) (finalPaths []string, failures []ItemDiff, err error) {
+	// record the path and carry on if keeping going, otherwise stop
+	fail := func(path string, pErr error) error {
+		if !keepGoing {
+			return pErr
+		}
+
+		failures = append(failures, failedItemDiff(path, home, pErr))
+
+		return nil
+	}
+
 	// check for directories
 	for _, path := range paths {
 		// if path is directory, then walk to generate list of additional Paths
 		var stat os.FileInfo
 		if stat, err = os.Stat(path); err == nil && stat.IsDir() && !noRecurse {
 			err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+				if cErr := ctx.Err(); cErr != nil {
+					return cErr
+				}
 				if err != nil {
-					return fmt.Errorf("failed to read path %q: %v", path, err)
+					return fail(path, fmt.Errorf("failed to read path %q: %v", path, err))
 				}
 				stat, err = os.Stat(path)
 				if err != nil {
-					return err
+					return fail(path, err)
 				}
 				// if it's a dir, then carry on
 				if stat.IsDir() {
@@ -230,7 +395,7 @@ func getLocalFSPaths(paths []string, noRecurse bool) (finalPaths []string, err e
 				var valid bool
 				valid, err = pathValid(path)
 				if err != nil {
-					return err
+					return fail(path, err)
 				}
 				if valid {
 					finalPaths = append(finalPaths, path)
@@ -247,7 +412,11 @@ func getLocalFSPaths(paths []string, noRecurse bool) (finalPaths []string, err e
 			var valid bool
 			valid, err = pathValid(path)
 			if err != nil {
-				return
+				if err = fail(path, err); err != nil {
+					return
+				}
+
+				continue
 			}
 			if valid {
 				finalPaths = append(finalPaths, path)
@@ -257,10 +426,10 @@ func getLocalFSPaths(paths []string, noRecurse bool) (finalPaths []string, err e
 	// dedupe
 	finalPaths = dedupe(finalPaths)
 
-	return finalPaths, err
+	return finalPaths, failures, err
 }
 
-func createItem(path, title string) (item items.Note, err error) {
+func createItem(path, homeRelPath, title string, opts Options) (item items.Note, err error) {
 	// read file content
 	var file *os.File
 
@@ -285,7 +454,14 @@ func createItem(path, title string) (item items.Note, err error) {
 	//TODO fill in references
 	var references items.ItemReferences
 
-	localStr := string(localBytes)
+	// never push resolved secrets
+	var localStr string
+
+	localStr, err = redactSecrets(trackedContent(string(localBytes)), "", opts.Secrets)
+	if err != nil {
+		return
+	}
+
 	// addToDB item
 	item, err = items.NewNote(title, localStr, references)
 	if err != nil {
@@ -293,6 +469,25 @@ func createItem(path, title string) (item items.Note, err error) {
 	}
 	item.Content.SetPrefersPlainEditor(true)
 
+	if opts.Encryption.encrypts(homeRelPath) {
+		var text string
+
+		text, err = encryptText(localStr, opts.Encryption.Passphrase)
+		if err != nil {
+			return
+		}
+
+		item.Content.SetText(text)
+	}
+
+	setChecksum(&item)
+
+	if setting, found := editorFor(homeRelPath, opts.Editors); found {
+		if err = applyEditor(&item, setting, opts.editorComponents); err != nil {
+			return
+		}
+	}
+
 	return item, err
 }
 
@@ -359,28 +554,28 @@ func pathValid(path string) (valid bool, err error) {
 	switch {
 	case mode.IsRegular():
 		if pSize > 10240000 {
-			err = fmt.Errorf("file too large: %s", path)
+			err = &ErrPathInvalid{Path: path, Reason: "file too large"}
 			return false, err
 		}
 
 		return true, nil
 	case mode&os.ModeSymlink != 0:
-		return false, fmt.Errorf("symlink not supported: %s", path)
+		return false, &ErrPathInvalid{Path: path, Reason: "symlink not supported"}
 	case mode.IsDir():
 		return true, nil
 	case mode&os.ModeSocket != 0:
-		return false, fmt.Errorf("sockets not supported: %s", path)
+		return false, &ErrPathInvalid{Path: path, Reason: "sockets not supported"}
 	case mode&os.ModeCharDevice != 0:
-		return false, fmt.Errorf("char device file not supported: %s", path)
+		return false, &ErrPathInvalid{Path: path, Reason: "char device file not supported"}
 	case mode&os.ModeDevice != 0:
-		return false, fmt.Errorf("device file not supported: %s", path)
+		return false, &ErrPathInvalid{Path: path, Reason: "device file not supported"}
 	case mode&os.ModeNamedPipe != 0:
-		return false, fmt.Errorf("named pipe not supported: %s", path)
+		return false, &ErrPathInvalid{Path: path, Reason: "named pipe not supported"}
 	case mode&os.ModeTemporary != 0:
-		return false, fmt.Errorf("temporary file not supported: %s", path)
+		return false, &ErrPathInvalid{Path: path, Reason: "temporary file not supported"}
 	case mode&os.ModeIrregular != 0:
-		return false, fmt.Errorf("irregular file not supported: %s", path)
+		return false, &ErrPathInvalid{Path: path, Reason: "irregular file not supported"}
 	default:
-		return false, fmt.Errorf("unknown file type: %s", path)
+		return false, &ErrPathInvalid{Path: path, Reason: "unknown file type"}
 	}
 }
@@ -1,13 +1,13 @@
 package snsync
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
 
-	"github.com/jonhadfield/gosn-v2"
 	"github.com/jonhadfield/gosn-v2/cache"
 	"github.com/lithammer/shortuuid"
 	"github.com/stretchr/testify/assert"
@@ -21,18 +21,13 @@ func removeDB(dbPath string) {
 	}
 }
 
+// CleanUp removes the cache db and queue, and deletes everything but the items key from the test server
 func CleanUp(session cache.Session) error {
 	removeDB(session.CacheDBPath)
-	err := gosn.DeleteContent(&gosn.Session{
-		Token:             testCacheSession.Token,
-		MasterKey:         testCacheSession.MasterKey,
-		Server:            testCacheSession.Server,
-		AccessToken:       testCacheSession.AccessToken,
-		AccessExpiration:  testCacheSession.AccessExpiration,
-		RefreshExpiration: testCacheSession.RefreshExpiration,
-		RefreshToken:      testCacheSession.RefreshToken,
-	})
-	return err
+	removeDB(QueuePath(session.CacheDBPath))
+	testServer.Reset()
+
+	return nil
 }
 
 func getTemporaryHome() string {
@@ -46,7 +41,7 @@ func TestAddNoPaths(t *testing.T) {
 		Home:    getTemporaryHome(),
 		Paths:   nil,
 	}
-	_, err := Add(ai, true)
+	_, err := Add(context.Background(), ai)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "paths")
 }
@@ -63,7 +58,7 @@ func TestAddInvalidSession(t *testing.T) {
 		CacheDB:     nil,
 		CacheDBPath: "",
 	}, Home: home, Paths: []string{gitConfigPath}}
-	_, err := Add(ai, true)
+	_, err := Add(context.Background(), ai)
 	assert.Error(t, err)
 }
 
@@ -85,7 +80,7 @@ func TestAddInvalidPath(t *testing.T) {
 
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{applePath, duffPath}}
 	var ao AddOutput
-	ao, err = Add(ai, true)
+	ao, err = Add(context.Background(), ai)
 
 	assert.Error(t, err)
 	assert.Equal(t, 0, len(ao.PathsAdded))
@@ -110,7 +105,7 @@ func TestAddOne(t *testing.T) {
 	// add item
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{applePath}}
 	var ao AddOutput
-	ao, err = Add(ai, true)
+	ao, err = Add(context.Background(), ai)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(ao.PathsAdded))
 	assert.Equal(t, applePath, ao.PathsAdded[0])
@@ -118,6 +113,30 @@ func TestAddOne(t *testing.T) {
 	assert.Equal(t, 0, len(ao.PathsInvalid))
 }
 
+func TestAddRecordsBase(t *testing.T) {
+	defer func() {
+		if err := CleanUp(*testCacheSession); err != nil {
+			fmt.Println("failed to wipe")
+		}
+	}()
+	home := getTemporaryHome()
+	stateDir := getTemporaryHome()
+
+	gitConfigPath := fmt.Sprintf("%s/.gitconfig", home)
+	assert.NoError(t, createTemporaryFiles(map[string]string{gitConfigPath: "[user]\nname = Jane\n"}))
+
+	// the content added is the base for merging the first changes made on both sides
+	ao, err := Add(context.Background(), AddInput{Session: testCacheSession, Home: home, Paths: []string{gitConfigPath},
+		Options: Options{StateDir: stateDir}})
+	assert.NoError(t, err)
+	assert.Len(t, ao.added, 1)
+
+	base, found, err := loadBase(stateDir, ao.added[0].remote.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "[user]\nname = Jane\n", base)
+}
+
 func TestAddTwoSameTag(t *testing.T) {
 	var err error
 	defer func() {
@@ -139,7 +158,7 @@ func TestAddTwoSameTag(t *testing.T) {
 	// add item
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{applePath, vwPath, bananaPath}}
 	var ao AddOutput
-	ao, err = Add(ai, true)
+	ao, err = Add(context.Background(), ai)
 	assert.NoError(t, err)
 	assert.Equal(t, 3, len(ao.PathsAdded))
 	assert.Contains(t, ao.PathsAdded, applePath)
@@ -170,7 +189,7 @@ func TestAddRecursive(t *testing.T) {
 	// add item
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{fruitPath, carsPath}}
 	var ao AddOutput
-	ao, err = Add(ai, true)
+	ao, err = Add(context.Background(), ai)
 	assert.NoError(t, err)
 	assert.Equal(t, 3, len(ao.PathsAdded))
 	assert.Contains(t, ao.PathsAdded, applePath)
@@ -198,7 +217,7 @@ func TestAddAll(t *testing.T) {
 	// add item
 	ai := AddInput{Session: testCacheSession, Home: home, All: true}
 	var ao AddOutput
-	ao, err = Add(ai, true)
+	ao, err = Add(context.Background(), ai)
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(ao.PathsAdded))
 	assert.Contains(t, ao.PathsAdded, file1Path)
@@ -226,6 +245,6 @@ func TestCheckPathValid(t *testing.T) {
 }
 
 func TestCreateItemInvalidPath(t *testing.T) {
-	_, err := createItem("invalid", "title")
+	_, err := createItem("invalid", "invalid", "title", Options{})
 	assert.Error(t, err)
 }
@@ -0,0 +1,223 @@
+package snsync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+)
+
+// batch stages the remote changes made by a command, so they're validated, saved to the cache db
+// and pushed to SN together
+type batch struct {
+	items items.Items
+}
+
+// add stages the items, replacing any already staged with the same uuid
+func (b *batch) add(its ...items.Item) {
+	for _, it := range its {
+		var replaced bool
+
+		for i := range b.items {
+			if b.items[i].GetUUID() == it.GetUUID() {
+				b.items[i] = it
+				replaced = true
+
+				break
+			}
+		}
+
+		if !replaced {
+			b.items = append(b.items, it)
+		}
+	}
+}
+
+func (b *batch) empty() bool {
+	return b == nil || len(b.items) == 0
+}
+
+func (b *batch) uuids() map[string]bool {
+	uuids := make(map[string]bool)
+
+	if b == nil {
+		return uuids
+	}
+
+	for _, it := range b.items {
+		uuids[it.GetUUID()] = true
+	}
+
+	return uuids
+}
+
+// validate checks the batch won't leave the remote tree inconsistent if pushed: each new note must be
+// referenced by a tag, and each new sync tag's parent must exist, in either the batch or the cache db
+func (b *batch) validate(db *storm.DB, session *cache.Session) error {
+	var cached cache.Items
+
+	if e := db.Select(q.In("ContentType", []string{"Note", "Tag"})).Find(&cached); e != nil {
+		if e.Error() != "not found" {
+			return e
+		}
+	}
+
+	var live cache.Items
+
+	existing := make(map[string]bool)
+
+	for _, ci := range cached {
+		if ci.Deleted {
+			continue
+		}
+
+		existing[ci.UUID] = true
+
+		if ci.ContentType == "Tag" {
+			live = append(live, ci)
+		}
+	}
+
+	cachedItems, err := live.ToItems(session)
+	if err != nil {
+		return err
+	}
+
+	return b.check(existing, cachedItems.Tags())
+}
+
+// check validates the batch against the uuids of the notes and tags in the cache db, and its tags
+func (b *batch) check(existing map[string]bool, cachedTags items.Tags) error {
+	// tags as they'll be once the batch is pushed
+	tags := make(map[string]items.Tag)
+
+	for _, t := range cachedTags {
+		tags[t.UUID] = t
+	}
+
+	for _, t := range b.items.Tags() {
+		tags[t.UUID] = t
+	}
+
+	referenced := make(map[string]bool)
+	titles := make(map[string]bool)
+
+	for _, t := range tags {
+		if t.IsDeleted() {
+			continue
+		}
+
+		titles[t.Content.GetTitle()] = true
+
+		for _, ref := range t.Content.References() {
+			referenced[ref.UUID] = true
+		}
+	}
+
+	for _, it := range b.items {
+		if it.IsDeleted() || existing[it.GetUUID()] {
+			continue
+		}
+
+		switch it.GetContentType() {
+		case "Note":
+			n := it.(*items.Note)
+
+			if err := validateNoteTitle(n.Content.GetTitle()); err != nil {
+				return err
+			}
+
+			if !referenced[n.UUID] {
+				return fmt.Errorf("new note %s isn't tagged", n.Content.GetTitle())
+			}
+		case "Tag":
+			title := it.(*items.Tag).Content.GetTitle()
+			if !strings.HasPrefix(title, DotFilesTag+".") {
+				continue
+			}
+
+			if parent := parentTagTitle(title); !titles[parent] {
+				return fmt.Errorf("new tag %s is missing its parent %s", title, parent)
+			}
+		}
+	}
+
+	return nil
+}
+
+// stage saves the batch to the cache db in a single transaction, so it's pushed on the next sync,
+// returning the cached items it replaced and the uuids of those it added
+func (b *batch) stage(db *storm.DB, session *cache.Session) (previous cache.Items, added []string, err error) {
+	// encrypt everything first, so a failure leaves the cache db unchanged
+	var eItems items.EncryptedItems
+
+	for _, it := range b.items {
+		var eItem items.EncryptedItem
+
+		eItem, err = items.EncryptItem(it, session.DefaultItemsKey, session.Session)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encrypt %s: %w", it.GetUUID(), err)
+		}
+
+		eItems = append(eItems, eItem)
+	}
+
+	for _, it := range b.items {
+		var ci cache.Item
+
+		switch e := db.One("UUID", it.GetUUID(), &ci); {
+		case e == nil:
+			previous = append(previous, ci)
+		case e == storm.ErrNotFound:
+			added = append(added, it.GetUUID())
+		default:
+			return nil, nil, e
+		}
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return
+	}
+
+	cItems := cache.ToCacheItems(eItems, false)
+	for i := range cItems {
+		if err = tx.Save(&cItems[i]); err != nil {
+			_ = tx.Rollback()
+
+			return nil, nil, err
+		}
+	}
+
+	return previous, added, tx.Commit()
+}
+
+// rollback restores the cache db to how it was before the batch was staged, so it doesn't hold changes that
+// didn't reach SN. Anything that did is fetched again on the next sync, as the sync token isn't updated.
+func rollback(db *storm.DB, previous cache.Items, added []string) error {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	for i := range previous {
+		if err = tx.Save(&previous[i]); err != nil {
+			_ = tx.Rollback()
+
+			return err
+		}
+	}
+
+	for _, uuid := range added {
+		if err = tx.DeleteStruct(&cache.Item{UUID: uuid}); err != nil && err != storm.ErrNotFound {
+			_ = tx.Rollback()
+
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,95 @@
+package snsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asdine/storm/v3"
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchAdd(t *testing.T) {
+	note := createNote(".bashrc", "old")
+	updated := note
+	updated.Content.SetText("new")
+
+	b := &batch{}
+	assert.True(t, b.empty())
+
+	b.add(&note)
+	b.add(&updated)
+
+	require.Len(t, b.items, 1)
+	assert.Equal(t, "new", b.items[0].(*items.Note).Content.GetText())
+	assert.Equal(t, map[string]bool{note.UUID: true}, b.uuids())
+
+	var nilBatch *batch
+	assert.True(t, nilBatch.empty())
+	assert.Empty(t, nilBatch.uuids())
+}
+
+func TestBatchCheck(t *testing.T) {
+	note := createNote(".vimrc", "x")
+	root, _ := items.NewTag(DotFilesTag, nil)
+	tag, _ := items.NewTag("sync.config.nvim", items.ItemReferences{{UUID: note.UUID, ContentType: "Note"}})
+	parent, _ := items.NewTag("sync.config", nil)
+
+	// a new note must be tagged
+	b := &batch{}
+	b.add(&note)
+	assert.ErrorContains(t, b.check(nil, items.Tags{root}), "isn't tagged")
+
+	// and a new tag must have its parent
+	b.add(&tag)
+	assert.ErrorContains(t, b.check(nil, items.Tags{root}), "missing its parent sync.config")
+
+	assert.NoError(t, b.check(nil, items.Tags{root, parent}))
+
+	b.add(&parent)
+	assert.NoError(t, b.check(nil, items.Tags{root}))
+
+	// existing items aren't checked
+	untagged := createNote(".zshrc", "y")
+	b = &batch{}
+	b.add(&untagged)
+	assert.NoError(t, b.check(map[string]bool{untagged.UUID: true}, nil))
+
+	// nor are deletions
+	untagged.Deleted = true
+	assert.NoError(t, (&batch{items: items.Items{&untagged}}).check(nil, nil))
+}
+
+func TestRollback(t *testing.T) {
+	home := getTemporaryHome()
+	require.NoError(t, os.MkdirAll(home, 0o700))
+
+	defer os.RemoveAll(home)
+
+	db, err := storm.Open(filepath.Join(home, "cache.db"))
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	require.NoError(t, cache.SaveCacheItems(db, cache.Items{{UUID: "existing", ContentType: "Note", Content: "old"}}, false))
+
+	var previous cache.Item
+	require.NoError(t, db.One("UUID", "existing", &previous))
+
+	// staged changes
+	require.NoError(t, cache.SaveCacheItems(db, cache.Items{
+		{UUID: "existing", ContentType: "Note", Content: "new", Dirty: true},
+		{UUID: "added", ContentType: "Note", Content: "added", Dirty: true},
+	}, false))
+
+	require.NoError(t, rollback(db, cache.Items{previous}, []string{"added", "missing"}))
+
+	var all cache.Items
+	require.NoError(t, db.All(&all))
+	require.Len(t, all, 1)
+	assert.Equal(t, "old", all[0].Content)
+	assert.False(t, all[0].Dirty)
+}
@@ -0,0 +1,102 @@
+package snsync
+
+import (
+	"strings"
+)
+
+const (
+	// ManagedBlockBegin marks the start of the only section of a file to be synced
+	ManagedBlockBegin = ">>> sn-sync:shared >>>"
+	// ManagedBlockEnd marks the end of the only section of a file to be synced
+	ManagedBlockEnd = "<<< sn-sync:shared <<<"
+)
+
+// markerCommentChars may precede a marker on its line, so it can be written as a comment in any file format,
+// e.g. "# >>> sn-sync:shared >>>" or "<!-- >>> sn-sync:shared >>> -->"
+const markerCommentChars = "#/;!%*-<\"' \t"
+
+// isMarkerLine returns true if the line is the marker, optionally commented, rather than merely containing it, so
+// a marker quoted within the content isn't taken for a real one
+func isMarkerLine(line, marker string) bool {
+	line = strings.TrimSpace(line)
+
+	i := strings.Index(line, marker)
+	if i == -1 || strings.Trim(line[:i], markerCommentChars) != "" {
+		return false
+	}
+
+	switch strings.TrimSpace(line[i+len(marker):]) {
+	case "", "*/", "-->":
+		return true
+	}
+
+	return false
+}
+
+// findManagedBlock returns the offsets of the managed block in content, from the start of the begin marker line
+// to the end of the end marker line, including its newline
+func findManagedBlock(content string) (start, end int, found bool) {
+	begun := false
+
+	for offset := 0; offset < len(content); {
+		line := content[offset:]
+		next := len(content)
+
+		if nl := strings.IndexByte(line, '\n'); nl != -1 {
+			line = line[:nl]
+			next = offset + nl + 1
+		}
+
+		switch {
+		case !begun && isMarkerLine(line, ManagedBlockBegin):
+			start, begun = offset, true
+		case begun && isMarkerLine(line, ManagedBlockEnd):
+			return start, next, true
+		}
+
+		offset = next
+	}
+
+	return 0, 0, false
+}
+
+// extractManagedBlock returns the managed block, including its marker lines, if content has one
+func extractManagedBlock(content string) (block string, found bool) {
+	start, end, found := findManagedBlock(content)
+	if !found {
+		return
+	}
+
+	return content[start:end], true
+}
+
+// isManagedBlock returns true if the text is a managed block rather than a whole file
+func isManagedBlock(text string) bool {
+	firstLine, _, _ := strings.Cut(text, "\n")
+
+	return isMarkerLine(firstLine, ManagedBlockBegin)
+}
+
+// spliceManagedBlock replaces the managed block in existing with block, leaving the rest
+// of the content alone. If existing has no managed block then block is appended.
+func spliceManagedBlock(existing, block string) string {
+	start, end, found := findManagedBlock(existing)
+	if found {
+		return existing[:start] + block + existing[end:]
+	}
+
+	if existing != "" && !strings.HasSuffix(existing, "\n") {
+		existing += "\n"
+	}
+
+	return existing + block
+}
+
+// trackedContent returns the part of a local file that is synced
+func trackedContent(local string) string {
+	if block, found := extractManagedBlock(local); found {
+		return block
+	}
+
+	return local
+}
@@ -0,0 +1,99 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBashrc = `# generated
+export PATH=/opt/bin:$PATH
+# >>> sn-sync:shared >>>
+alias ll='ls -l'
+# <<< sn-sync:shared <<<
+# machine specific
+`
+
+func TestExtractManagedBlock(t *testing.T) {
+	block, found := extractManagedBlock(testBashrc)
+	assert.True(t, found)
+	assert.Equal(t, "# >>> sn-sync:shared >>>\nalias ll='ls -l'\n# <<< sn-sync:shared <<<\n", block)
+	assert.True(t, isManagedBlock(block))
+	assert.False(t, isManagedBlock(testBashrc))
+
+	// missing end marker
+	_, found = extractManagedBlock("# >>> sn-sync:shared >>>\nalias ll='ls -l'\n")
+	assert.False(t, found)
+}
+
+func TestSpliceManagedBlock(t *testing.T) {
+	newBlock := "# >>> sn-sync:shared >>>\nalias la='ls -a'\n# <<< sn-sync:shared <<<\n"
+
+	res := spliceManagedBlock(testBashrc, newBlock)
+	assert.Equal(t, "# generated\nexport PATH=/opt/bin:$PATH\n"+newBlock+"# machine specific\n", res)
+
+	// block is appended if missing
+	res = spliceManagedBlock("export EDITOR=vim", newBlock)
+	assert.Equal(t, "export EDITOR=vim\n"+newBlock, res)
+}
+
+func TestCompareManagedBlock(t *testing.T) {
+	home := getTemporaryHome()
+	bashrcPath := fmt.Sprintf("%s/.bashrc", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{bashrcPath: testBashrc}))
+
+	defer os.RemoveAll(home)
+
+	block, _ := extractManagedBlock(testBashrc)
+	note := createNote(".bashrc", block)
+
+	// only the block is compared
+	iDiff, err := compareNoteWithFile(DotFilesTag, bashrcPath, home, note, Options{}, true)
+	require.NoError(t, err)
+	assert.Equal(t, identical, iDiff.diff)
+
+	// pulling a changed block leaves the rest of the file alone
+	newBlock := "# >>> sn-sync:shared >>>\nalias la='ls -a'\n# <<< sn-sync:shared <<<\n"
+	note = createNote(".bashrc", newBlock)
+	note.UpdatedAt = time.Now().Add(1 * time.Hour).Format("2006-01-02T15:04:05.000Z")
+
+	iDiff, err = compareNoteWithFile(DotFilesTag, bashrcPath, home, note, Options{}, true)
+	require.NoError(t, err)
+	assert.Equal(t, remoteNewer, iDiff.diff)
+	_, err = createLocal(context.Background(), []ItemDiff{iDiff}, home, Options{})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(bashrcPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "# generated\nexport PATH=/opt/bin:$PATH\n"+newBlock+"# machine specific\n", string(content))
+
+	// a local file without a block needs the block pulled
+	require.NoError(t, os.WriteFile(bashrcPath, []byte("export EDITOR=vim\n"), 0o644))
+	iDiff, err = compareNoteWithFile(DotFilesTag, bashrcPath, home, note, Options{}, true)
+	require.NoError(t, err)
+	assert.Equal(t, blockMissing, iDiff.diff)
+}
+
+func TestManagedBlockMarkerLines(t *testing.T) {
+	// markers quoted within a line aren't taken for real ones
+	quoted := "echo '# >>> sn-sync:shared >>>'\nalias ll='ls -l'\necho '# <<< sn-sync:shared <<<'\n"
+	_, found := extractManagedBlock(quoted)
+	assert.False(t, found)
+	assert.False(t, isManagedBlock(quoted))
+
+	// markers can be commented for other file formats
+	html := "<p>local</p>\n<!-- >>> sn-sync:shared >>> -->\n<p>shared</p>\n<!-- <<< sn-sync:shared <<< -->\n"
+	block, found := extractManagedBlock(html)
+	assert.True(t, found)
+	assert.Equal(t, "<!-- >>> sn-sync:shared >>> -->\n<p>shared</p>\n<!-- <<< sn-sync:shared <<< -->\n", block)
+
+	// an end marker quoted within the block doesn't end it
+	block, found = extractManagedBlock("; >>> sn-sync:shared >>>\nx = \"<<< sn-sync:shared <<<\"\n; <<< sn-sync:shared <<<")
+	assert.True(t, found)
+	assert.Equal(t, "; >>> sn-sync:shared >>>\nx = \"<<< sn-sync:shared <<<\"\n; <<< sn-sync:shared <<<", block)
+}
@@ -0,0 +1,78 @@
+package snsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareCancelled(t *testing.T) {
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	bashrc := createNote(".bashrc", "x")
+	bashrc.UpdatedAt = "2024-01-01T00:00:00.000Z"
+	require.NoError(t, createTemporaryFiles(map[string]string{filepath.Join(home, ".bashrc"): "x"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := compare(ctx, tagsWithNotes{tagWithNotesFor(DotFilesTag, bashrc)}, home, nil, nil, Options{}, true)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCreateLocalCancelled(t *testing.T) {
+	home := getTemporaryHome()
+	require.NoError(t, os.MkdirAll(home, 0o755))
+
+	defer os.RemoveAll(home)
+
+	itemDiffs := []ItemDiff{
+		{homeRelPath: ".vimrc", path: home + "/.vimrc", remote: createNote(".vimrc", "set nu")},
+		{homeRelPath: ".zshrc", path: home + "/.zshrc", remote: createNote(".zshrc", "setopt x")},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pending, err := createLocal(ctx, itemDiffs, home, Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, itemDiffs, pending)
+
+	// nothing is written once cancelled
+	_, err = os.Stat(home + "/.vimrc")
+	assert.True(t, os.IsNotExist(err))
+
+	res := []string{".bashrc | pushed"}
+	noPulled, msg := cancelledResults(res, itemDiffs[:1], nil, itemDiffs[1:], pending)
+	assert.Zero(t, noPulled)
+	assert.Contains(t, msg, "not pulled")
+	assert.Contains(t, msg, "not merged")
+}
+
+func TestPushChangesCancelled(t *testing.T) {
+	home := getTemporaryHome()
+	require.NoError(t, os.MkdirAll(home, 0o755))
+
+	defer os.RemoveAll(home)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	b := &batch{}
+	vimrc := createNote(".vimrc", "set nu")
+	b.add(&vimrc)
+
+	store := newMemoryStore()
+	_, err := store.Load(context.Background())
+	require.NoError(t, err)
+
+	_, err = pushChanges(ctx, store, b)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Contains(t, err.Error(), "changes not pushed")
+	assert.Zero(t, store.pushes)
+}
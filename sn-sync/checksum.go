@@ -0,0 +1,138 @@
+package snsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jonhadfield/gosn-v2/common"
+	"github.com/jonhadfield/gosn-v2/items"
+)
+
+const (
+	// appDataKey is the key sn-sync data is kept under in a note's component app data,
+	// the only part of the app data that's kept by other clients whatever it contains
+	appDataKey = "sn-sync"
+	// checksumDirName is the state directory holding the checksum of each file when last synced
+	checksumDirName = "checksums"
+)
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// setChecksum records the SHA-256 of the note's text, as it's stored, in its app data along with the time
+// it's updated, so a note edited by another client can be told apart from one that's corrupt. The text of
+// encrypted notes must already be encrypted, so the checksum never reveals anything about their content.
+func setChecksum(note *items.Note) {
+	appData := note.Content.GetAppData()
+	appData.OrgStandardNotesSN.ClientUpdatedAt = time.Now().UTC().Format(common.TimeLayout)
+
+	// copy the component data so other copies of the note are left unchanged
+	componentData := items.OrgStandardNotesSNComponentsDetail{}
+	for k, v := range appData.OrgStandardNotesSNComponents {
+		componentData[k] = v
+	}
+
+	componentData[appDataKey] = map[string]interface{}{
+		"sha256":    checksum([]byte(note.Content.GetText())),
+		"updatedAt": appData.OrgStandardNotesSN.ClientUpdatedAt,
+	}
+	appData.OrgStandardNotesSNComponents = componentData
+
+	note.Content.SetAppData(appData)
+}
+
+// getChecksum returns the SHA-256 recorded when the note was last pushed, and the time the note was updated then
+func getChecksum(note items.Note) (sum, updatedAt string, found bool) {
+	data, ok := note.Content.GetAppData().OrgStandardNotesSNComponents[appDataKey].(map[string]interface{})
+	if !ok {
+		return "", "", false
+	}
+
+	sum, found = data["sha256"].(string)
+	updatedAt, _ = data["updatedAt"].(string)
+
+	return sum, updatedAt, found && sum != ""
+}
+
+// localChecksum is the checksum and modification time of a file when it was last synced
+type localChecksum struct {
+	SHA256  string    `json:"sha256"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// saveLocalChecksum records the checksum of the file synced with the note with the given uuid
+func saveLocalChecksum(stateDir, uuid, path string) error {
+	if stateDir == "" || uuid == "" {
+		return nil
+	}
+
+	if err := validateNoteTitle(uuid); err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(localChecksum{SHA256: checksum(b), ModTime: stat.ModTime().UTC()})
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(stateDir, checksumDirName)
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, uuid), j, 0o600)
+}
+
+// loadLocalChecksum returns the checksum of the file synced with the note with the given uuid
+func loadLocalChecksum(stateDir, uuid string) (lc localChecksum, found bool, err error) {
+	if stateDir == "" || uuid == "" {
+		return
+	}
+
+	if err = validateNoteTitle(uuid); err != nil {
+		return
+	}
+
+	var b []byte
+
+	b, err = os.ReadFile(filepath.Join(stateDir, checksumDirName, uuid))
+	if os.IsNotExist(err) {
+		return lc, false, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(b, &lc); err != nil {
+		return
+	}
+
+	return lc, true, nil
+}
+
+// recordSynced keeps the content and local file checksum of an item once it's in sync
+func recordSynced(stateDir string, itemDiff ItemDiff, content string) error {
+	if err := saveBase(stateDir, itemDiff.remote.UUID, content); err != nil {
+		return err
+	}
+
+	return saveLocalChecksum(stateDir, itemDiff.remote.UUID, itemDiff.path)
+}
@@ -0,0 +1,112 @@
+package snsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetChecksum(t *testing.T) {
+	note := createNote(".bashrc", "alias ll='ls -l'")
+	copied := note
+
+	_, _, found := getChecksum(note)
+	assert.False(t, found)
+
+	setChecksum(&note)
+
+	sum, updatedAt, found := getChecksum(note)
+	require.True(t, found)
+	assert.Equal(t, checksum([]byte("alias ll='ls -l'")), sum)
+	assert.Equal(t, note.Content.GetAppData().OrgStandardNotesSN.ClientUpdatedAt, updatedAt)
+
+	_, _, found = getChecksum(copied)
+	assert.False(t, found)
+}
+
+func TestChecksumOfEncryptedNote(t *testing.T) {
+	home := getTemporaryHome()
+	path := filepath.Join(home, ".ssh", "config")
+	require.NoError(t, createTemporaryFiles(map[string]string{path: "Host *\n"}))
+
+	defer os.RemoveAll(home)
+
+	opts := Options{Encryption: EncryptionOptions{Paths: []string{".ssh/*"}, Passphrase: []byte("secret")}}
+
+	// the checksum published is of the ciphertext, never the plaintext
+	note, err := createItem(path, ".ssh/config", "config", opts)
+	require.NoError(t, err)
+
+	sum, _, found := getChecksum(note)
+	require.True(t, found)
+	assert.Equal(t, checksum([]byte(note.Content.GetText())), sum)
+	assert.NotEqual(t, checksum([]byte("Host *\n")), sum)
+
+	b := &batch{}
+	d := ItemDiff{homeRelPath: ".ssh/config", remote: createNote("config", "Host *\n")}
+	require.NoError(t, addToDB(b, []ItemDiff{d}, opts))
+	require.Len(t, b.items, 1)
+
+	pushed := b.items[0].(*items.Note)
+	sum, _, found = getChecksum(*pushed)
+	require.True(t, found)
+	assert.True(t, isEncrypted(pushed.Content.GetText()))
+	assert.Equal(t, checksum([]byte(pushed.Content.GetText())), sum)
+
+	status, reason := verifyNote(*pushed, path, "")
+	assert.Equal(t, verified, status, reason)
+}
+
+func TestVerifyNote(t *testing.T) {
+	home := getTemporaryHome()
+	stateDir := filepath.Join(home, "state")
+	path := filepath.Join(home, ".bashrc")
+
+	defer os.RemoveAll(home)
+
+	require.NoError(t, createTemporaryFiles(map[string]string{path: "alias ll='ls -l'"}))
+
+	note := createNote(".bashrc", "alias ll='ls -l'")
+	d := ItemDiff{path: path, homeRelPath: ".bashrc", diff: identical, remote: note}
+
+	status, _ := verifyNote(d.remote, path, stateDir)
+	assert.Equal(t, unverified, status)
+
+	setChecksum(&d.remote)
+	require.NoError(t, recordSynced(stateDir, d, d.remote.Content.GetText()))
+
+	status, reason := verifyNote(d.remote, path, stateDir)
+	assert.Equal(t, verified, status, reason)
+
+	// content changed without the update time changing
+	changed := d.remote
+	changed.Content.SetText("alias ll='ls -la'")
+	status, _ = verifyNote(changed, path, stateDir)
+	assert.Equal(t, corrupt, status)
+
+	// content edited by another client, which updates the time but not the checksum
+	changed.Content.SetUpdateTime(time.Now().UTC().Add(time.Minute))
+	status, _ = verifyNote(changed, path, stateDir)
+	assert.Equal(t, changedElsewhere, status)
+
+	// local edits are expected
+	require.NoError(t, os.WriteFile(path, []byte("alias ll='ls -lh'"), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	status, _ = verifyNote(d.remote, path, stateDir)
+	assert.Equal(t, modified, status)
+
+	// but not changes that leave the modification time as it was
+	lc, found, err := loadLocalChecksum(stateDir, d.remote.UUID)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NoError(t, os.Chtimes(path, time.Now(), lc.ModTime))
+
+	status, _ = verifyNote(d.remote, path, stateDir)
+	assert.Equal(t, corrupt, status)
+}
@@ -1,22 +1,23 @@
 package snsync
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"time"
 
 	"github.com/jonhadfield/gosn-v2/items"
 )
 
-func compare(remote tagsWithNotes, home string, paths, exclude []string, debug bool) (diffs []ItemDiff, err error) {
+func compare(ctx context.Context, remote tagsWithNotes, home string, paths, exclude []string, opts Options, debug bool) (diffs []ItemDiff, err error) {
 	debugPrint(debug, fmt.Sprintf("compare | Home: %s", home))
 	debugPrint(debug, fmt.Sprintf("compare | %d Paths to include supplied", len(paths)))
 	debugPrint(debug, fmt.Sprintf("compare | %d Paths to Exclude supplied", len(exclude)))
 	// fail immediately if remote or Paths are empty
 	if len(remote) == 0 {
-		return nil, fmt.Errorf("tags with notes not supplied")
+		return nil, ErrNoRemoteItems
 	}
 
 	paths, err = preflight(home, paths)
@@ -26,9 +27,20 @@ func compare(remote tagsWithNotes, home string, paths, exclude []string, debug b
 
 	var itemDiffs []ItemDiff
 
+	// the number of notes compared is only known when every path is
+	total := 0
+	if len(paths) == 0 {
+		for _, twn := range remote {
+			total += len(twn.notes)
+		}
+	}
+
+	r := opts.reporter()
+	r.PhaseStarted(PhaseComparing, total)
+
 	var remotePaths []string
 	// check remotes against local filesystem
-	itemDiffs, remotePaths, err = compareRemoteWithLocalFS(remote, paths, home, debug)
+	itemDiffs, remotePaths, err = compareRemoteWithLocalFS(ctx, remote, paths, home, opts, debug)
 	if err != nil {
 		return
 	}
@@ -36,17 +48,41 @@ func compare(remote tagsWithNotes, home string, paths, exclude []string, debug b
 	// if Paths specified, then discover those that are untracked
 	// by comparing with existing remote equivalent Paths
 	if len(paths) > 0 {
-		itemDiffs = append(itemDiffs, findUntracked(paths, remotePaths, home, debug)...)
+		var untrackedDiffs []ItemDiff
+
+		untrackedDiffs, err = findUntracked(ctx, paths, remotePaths, home, opts.KeepGoing, debug)
+		if err != nil {
+			return
+		}
+
+		for _, d := range untrackedDiffs {
+			r.ItemCompared(d.homeRelPath, d.diff)
+		}
+
+		itemDiffs = append(itemDiffs, untrackedDiffs...)
 	}
 
 	return itemDiffs, err
 }
 
-func compareRemoteWithLocalFS(remote tagsWithNotes, paths []string, home string, debug bool) (itemDiffs []ItemDiff, remotePaths []string, err error) {
+func compareRemoteWithLocalFS(ctx context.Context, remote tagsWithNotes, paths []string, home string, opts Options, debug bool) (itemDiffs []ItemDiff, remotePaths []string, err error) {
 	// loop through remotes to generate a list of diffs for:
 	// - existing local and remotes
 	// - missing local files
 	// also getTagsWithNotes a list of remotes that should have locals
+	r := opts.reporter()
+
+	// compared records the diff and reports it
+	compared := func(d ItemDiff) {
+		itemDiffs = append(itemDiffs, d)
+
+		r.ItemCompared(d.homeRelPath, d.diff)
+
+		if d.err != nil {
+			r.Error(d.homeRelPath, d.err)
+		}
+	}
+
 	for _, twn := range remote {
 		// only do a compare if path equals translated tag
 		tagTitle := twn.tag.Content.GetTitle()
@@ -54,6 +90,19 @@ func compareRemoteWithLocalFS(remote tagsWithNotes, paths []string, home string,
 		var dir string
 
 		dir, err = tagTitleToFSDir(twn.tag.Content.GetTitle(), home)
+		if errors.Is(err, errUnsafePath) {
+			// report the tag's notes rather than failing the whole comparison
+			debugPrint(debug, fmt.Sprintf("compare | tag title: %s is unsafe: %s", tagTitle, err))
+
+			for _, d := range twn.notes {
+				compared(invalidItemDiff(tagTitle, tagTitle+"/"+d.Content.GetTitle(), d, err))
+			}
+
+			err = nil
+
+			continue
+		}
+
 		if err != nil {
 			return
 		}
@@ -67,30 +116,86 @@ func compareRemoteWithLocalFS(remote tagsWithNotes, paths []string, home string,
 		// loop through notes for the tag and compareNoteWithFile content of any with matching file
 		// log each matching path so we can later walk them to discover untracked files
 		for _, d := range twn.notes {
-			fullPath := fmt.Sprintf("%s%s", dir, d.Content.GetTitle())
+			if err = ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+
+			fullPath, pErr := remoteNotePath(dir, d.Content.GetTitle(), home)
+			if pErr != nil {
+				debugPrint(debug, fmt.Sprintf("compare | note title: %s is unsafe: %s", d.Content.GetTitle(), pErr))
+				compared(invalidItemDiff(tagTitle, stripHome(dir, home)+d.Content.GetTitle(), d, pErr))
+
+				continue
+			}
+
 			// skip note if exact path is not specified and does not have prefix of total path
 			if len(paths) > 0 && !noteInPaths(dir+d.Content.GetTitle(), paths) {
 				continue
 			}
 
+			homeRelPath := stripHome(fullPath, home)
+			flags := twn.flags[d.UUID]
+
+			// compare the plaintext of encrypted notes; d is a copy so the decrypted text stays in memory
+			encrypted := isEncrypted(d.Content.GetText())
+			if encrypted {
+				plain, dErr := decryptText(d.Content.GetText(), opts.Encryption.Passphrase)
+				if dErr != nil {
+					debugPrint(debug, fmt.Sprintf("compare | failed to decrypt: <home>/%s: %s", homeRelPath, dErr))
+					iDiff := invalidItemDiff(tagTitle, homeRelPath, d, dErr)
+					iDiff.path = fullPath
+					iDiff.diff = undecryptable
+					iDiff.flags = flags
+					compared(iDiff)
+
+					continue
+				}
+
+				d.Content.SetText(plain)
+			}
+
 			if !localExists(fullPath) {
 				// local path matching tag+note doesn't exist so set as 'local missing'
 				debugPrint(debug, fmt.Sprintf("compare | local not found: <home>/%s", stripHome(fullPath, home)))
-				homeRelPath := stripHome(fullPath, home)
-
-				itemDiffs = append(itemDiffs, ItemDiff{
+				compared(checkEditor(ItemDiff{
 					tagTitle:    tagTitle,
 					homeRelPath: homeRelPath,
 					path:        fullPath,
 					diff:        localMissing,
 					noteTitle:   d.Content.GetTitle(),
 					remote:      d,
-				})
+					encrypted:   encrypted,
+					flags:       flags,
+				}, twn.editors[d.UUID], twn.editedWith[d.UUID]))
 			} else {
 				// local does exist, so compareNoteWithFile and store generated compare
 				debugPrint(debug, fmt.Sprintf("compare | local found: <home>/%s", stripHome(fullPath, home)))
 				remotePaths = append(remotePaths, fullPath)
-				itemDiffs = append(itemDiffs, compareNoteWithFile(tagTitle, fullPath, home, d, debug))
+				iDiff, cErr := compareNoteWithFile(tagTitle, fullPath, home, d, opts, debug)
+				if cErr != nil {
+					if !opts.KeepGoing {
+						return nil, nil, cErr
+					}
+
+					debugPrint(debug, fmt.Sprintf("compare | failed to compare: <home>/%s: %s", homeRelPath, cErr))
+					fDiff := failedItemDiff(fullPath, home, cErr)
+					fDiff.tagTitle = tagTitle
+					fDiff.noteTitle = d.Content.GetTitle()
+					fDiff.remote = d
+					compared(fDiff)
+
+					continue
+				}
+
+				iDiff.encrypted = encrypted
+				iDiff.flags = flags
+
+				// push paths that have since been configured for encryption
+				if iDiff.diff == identical && !encrypted && opts.Encryption.encrypts(homeRelPath) {
+					iDiff.diff = localNewer
+				}
+
+				compared(checkEditor(iDiff, twn.editors[d.UUID], twn.editedWith[d.UUID]))
 			}
 		}
 	}
@@ -98,24 +203,26 @@ func compareRemoteWithLocalFS(remote tagsWithNotes, paths []string, home string,
 	return itemDiffs, remotePaths, err
 }
 
-func compareNoteWithFile(tagTitle, path, home string, remote items.Note, debug bool) ItemDiff {
+func compareNoteWithFile(tagTitle, path, home string, remote items.Note, opts Options, debug bool) (itemDiff ItemDiff, err error) {
 	debugPrint(debug, fmt.Sprintf("compareNoteWithFile | title: %s path: <home>/%s",
 		tagTitle, stripHome(path, home)))
 
-	localStat, err := os.Stat(path)
+	var localStat os.FileInfo
+
+	localStat, err = os.Stat(path)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
 
 	var file *os.File
 
 	file, err = os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
 
 	defer func() {
-		if err = file.Close(); err != nil {
+		if cErr := file.Close(); cErr != nil {
 			fmt.Println("failed to close file:", path)
 		}
 	}()
@@ -124,18 +231,41 @@ func compareNoteWithFile(tagTitle, path, home string, remote items.Note, debug b
 
 	localBytes, err = io.ReadAll(file)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
 
 	homeRelPath := stripHome(path, home)
 
-	localStr := string(localBytes)
+	// if only a managed block is tracked but the local file doesn't have one, it needs to be pulled
+	if isManagedBlock(remote.Content.GetText()) {
+		if _, _, found := findManagedBlock(string(localBytes)); !found {
+			debugPrint(debug, fmt.Sprintf("compareNoteWithFile | managed block missing from: <home>/%s", homeRelPath))
+
+			return ItemDiff{
+				tagTitle:    tagTitle,
+				path:        path,
+				homeRelPath: homeRelPath,
+				noteTitle:   remote.Content.GetTitle(),
+				diff:        blockMissing,
+				remote:      remote,
+			}, nil
+		}
+	}
+
+	// compare with secrets replaced by their placeholders, as they would be pushed
+	var localStr string
+
+	localStr, err = redactSecrets(trackedContent(string(localBytes)), remote.Content.GetText(), opts.Secrets)
+	if err != nil {
+		return
+	}
+
 	if localStr != remote.Content.GetText() {
 		var remoteUpdated time.Time
 
 		remoteUpdated, err = time.Parse("2006-01-02T15:04:05.000Z", remote.UpdatedAt)
 		if err != nil {
-			log.Fatal(err)
+			return itemDiff, fmt.Errorf("invalid updated time for note %s: %w", remote.Content.GetTitle(), err)
 		}
 
 		debugPrint(debug, fmt.Sprintf("compareNoteWithFile | remote updated UTC): %v", remoteUpdated.UTC()))
@@ -149,9 +279,9 @@ func compareNoteWithFile(tagTitle, path, home string, remote items.Note, debug b
 				homeRelPath: homeRelPath,
 				noteTitle:   remote.Content.GetTitle(),
 				diff:        localNewer,
-				local:       string(localBytes),
+				local:       localStr,
 				remote:      remote,
-			}
+			}, nil
 		}
 		// content different remote content was updated more recently
 		return ItemDiff{
@@ -160,9 +290,9 @@ func compareNoteWithFile(tagTitle, path, home string, remote items.Note, debug b
 			homeRelPath: homeRelPath,
 			noteTitle:   remote.Content.GetTitle(),
 			diff:        remoteNewer,
-			local:       string(localBytes),
+			local:       localStr,
 			remote:      remote,
-		}
+		}, nil
 	}
 	// local and remote identical
 	return ItemDiff{
@@ -171,7 +301,31 @@ func compareNoteWithFile(tagTitle, path, home string, remote items.Note, debug b
 		homeRelPath: homeRelPath,
 		noteTitle:   remote.Content.GetTitle(),
 		diff:        identical,
-		local:       string(localBytes),
+		local:       localStr,
+		remote:      remote,
+	}, nil
+}
+
+// invalidItemDiff reports a remote note that can't be safely compared or written
+func invalidItemDiff(tagTitle, homeRelPath string, remote items.Note, err error) ItemDiff {
+	return ItemDiff{
+		tagTitle:    tagTitle,
+		homeRelPath: homeRelPath,
+		noteTitle:   remote.Content.GetTitle(),
+		diff:        invalidPath,
 		remote:      remote,
+		err:         err,
 	}
 }
+
+// checkEditor marks an item whose note isn't plain text, so its content is never written to the file
+func checkEditor(itemDiff ItemDiff, associatedEditor, editedWith string) ItemDiff {
+	if problem := editorProblem(itemDiff.remote, associatedEditor, editedWith); problem != "" {
+		itemDiff.compared = itemDiff.diff
+		itemDiff.diff = notPlainText
+		itemDiff.err = errors.New(problem)
+		itemDiff.markup = hasEditorMarkup(itemDiff.remote, editedWith)
+	}
+
+	return itemDiff
+}
@@ -0,0 +1,39 @@
+package snsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareNoteWithFileErrors(t *testing.T) {
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	bashrcPath := filepath.Join(home, ".bashrc")
+	require.NoError(t, createTemporaryFiles(map[string]string{bashrcPath: "local"}))
+
+	// the updated time is only needed when the content differs
+	note := createNote(".bashrc", "remote")
+	note.UpdatedAt = "yesterday"
+
+	_, err := compareNoteWithFile(DotFilesTag, bashrcPath, home, note, Options{}, true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid updated time")
+
+	note.UpdatedAt = "2024-01-01T00:00:00.000Z"
+
+	_, err = compareNoteWithFile(DotFilesTag, filepath.Join(home, ".missing"), home, note, Options{}, true)
+	require.Error(t, err)
+	assert.True(t, os.IsNotExist(err))
+
+	// a directory can be opened but not read
+	dirPath := filepath.Join(home, ".config")
+	require.NoError(t, os.MkdirAll(dirPath, 0o700))
+
+	_, err = compareNoteWithFile(DotFilesTag, dirPath, home, note, Options{}, true)
+	require.Error(t, err)
+}
@@ -0,0 +1,242 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+)
+
+// maxConflictRetries is the number of times paths refused by the server are compared and pushed again
+const maxConflictRetries = 1
+
+// stagedContent returns the encrypted content of each of the items staged in the cache db, without those deleted
+func stagedContent(db *storm.DB, its items.Items) (staged map[string]string, err error) {
+	staged = make(map[string]string)
+
+	for _, it := range its {
+		if it.IsDeleted() {
+			continue
+		}
+
+		var ci cache.Item
+		if err = db.One("UUID", it.GetUUID(), &ci); err != nil {
+			return nil, err
+		}
+
+		staged[it.GetUUID()] = ci.Content
+	}
+
+	return staged, nil
+}
+
+// refusedItems returns the uuids of the staged items the server refused. A saved item is kept in the cache db as
+// it was pushed, whereas the server's version of a refused one replaces it. Deletions aren't refused, as gosn
+// pushes them again with the server's timestamp.
+func refusedItems(db *storm.DB, staged map[string]string) (refused []string, err error) {
+	for uuid, content := range staged {
+		var ci cache.Item
+
+		switch e := db.One("UUID", uuid, &ci); {
+		case e == storm.ErrNotFound:
+			refused = append(refused, uuid)
+		case e != nil:
+			return nil, e
+		case ci.Deleted || ci.Content != content:
+			refused = append(refused, uuid)
+		}
+	}
+
+	sort.Strings(refused)
+
+	return refused, nil
+}
+
+// conflictedCopies returns the uuids of the copies made of the refused items, mapped to the uuid of the item copied.
+// When a pushed item has been changed on another device since the cache was synced, the server refuses it
+// with a sync conflict and gosn saves it as a new item. The copy should be marked as a duplicate of the original,
+// but gosn doesn't send it. It does keep the creation time of the item it copied though, so a new item created at
+// the same time as a refused one, with the same content, is taken to be its copy. A note with the same content
+// from another device, or duplicated by the user, was created later so is left alone. Items in known existed
+// before the push, so aren't copies made by it.
+func conflictedCopies(db *storm.DB, session *cache.Session, refused items.Items, known map[string]bool) (copies map[string]string, err error) {
+	copies = make(map[string]string)
+
+	if len(refused) == 0 {
+		return
+	}
+
+	byUUID := make(map[string]items.Item)
+	for _, it := range refused {
+		byUUID[it.GetUUID()] = it
+	}
+
+	var cached cache.Items
+
+	if e := db.Select(q.In("ContentType", []string{"Note", "Tag"})).Find(&cached); e != nil {
+		if e.Error() != "not found" {
+			return nil, e
+		}
+	}
+
+	for _, ci := range cached {
+		if ci.Deleted || known[ci.UUID] {
+			continue
+		}
+
+		if ci.DuplicateOf != nil {
+			if _, ok := byUUID[*ci.DuplicateOf]; ok {
+				copies[ci.UUID] = *ci.DuplicateOf
+			}
+
+			continue
+		}
+
+		var candidates []string
+
+		for uuid, r := range byUUID {
+			if ci.CreatedAtTimestamp != 0 && ci.CreatedAtTimestamp == r.GetCreatedAtTimestamp() {
+				candidates = append(candidates, uuid)
+			}
+		}
+
+		if len(candidates) == 0 {
+			continue
+		}
+
+		var its items.Items
+
+		its, err = cache.Items{ci}.ToItems(session)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, it := range its {
+			for _, uuid := range candidates {
+				if sameContent(it, byUUID[uuid]) {
+					copies[ci.UUID] = uuid
+
+					break
+				}
+			}
+		}
+	}
+
+	return copies, nil
+}
+
+// sameContent returns true if both items are notes with the same title and text, or tags with the same title
+func sameContent(a, b items.Item) bool {
+	switch x := a.(type) {
+	case *items.Note:
+		y, ok := b.(*items.Note)
+
+		return ok && x.Content.Title == y.Content.Title && x.Content.Text == y.Content.Text
+	case *items.Tag:
+		y, ok := b.(*items.Tag)
+
+		return ok && x.Content.Title == y.Content.Title
+	}
+
+	return false
+}
+
+// pushChanges pushes the batch to the store, returning the uuids of the items refused so they can be compared
+// again. It isn't started if ctx is cancelled, but once started, it's finished even if ctx is cancelled.
+func pushChanges(ctx context.Context, store RemoteStore, b *batch) (rejected []string, err error) {
+	if err = ctx.Err(); err != nil {
+		_ = store.Close()
+
+		return nil, fmt.Errorf("changes not pushed: %w", err)
+	}
+
+	var its items.Items
+	if b != nil {
+		its = b.items
+	}
+
+	return store.Push(context.WithoutCancel(ctx), its)
+}
+
+// deleteChanges deletes the items staged in the batch from the store, as pushChanges pushes them
+func deleteChanges(ctx context.Context, store RemoteStore, b *batch) (rejected []string, err error) {
+	if err = ctx.Err(); err != nil {
+		_ = store.Close()
+
+		return nil, fmt.Errorf("changes not deleted: %w", err)
+	}
+
+	var its items.Items
+	if b != nil {
+		its = b.items
+	}
+
+	return store.Delete(context.WithoutCancel(ctx), its)
+}
+
+// rejectedResults returns a status line for each of the pushed items refused by the server
+func rejectedResults(pushed []ItemDiff, rejected []string, command string) (results []string) {
+	for _, d := range pushed {
+		if StringInSlice(d.remote.UUID, rejected, true) {
+			results = append(results, fmt.Sprintf("%s | %s | changed on another device, run %s again",
+				bold(d.homeRelPath), red(conflicted), command))
+		}
+	}
+
+	return results
+}
+
+func rollbackCacheDB(path string, previous cache.Items, added []string) error {
+	db, err := storm.Open(path)
+	if err != nil {
+		return err
+	}
+
+	if err = rollback(db, previous, added); err != nil {
+		_ = db.Close()
+
+		return err
+	}
+
+	return db.Close()
+}
+
+// checkPushed returns an error if any of the items are still dirty in the cache db after syncing. gosn doesn't
+// return the error when a push fails if it closes the cache db, but only marks the items clean once they're saved.
+func checkPushed(path string, its items.Items) error {
+	db, err := storm.Open(path)
+	if err != nil {
+		return err
+	}
+
+	var unsaved int
+
+	for _, it := range its {
+		var ci cache.Item
+
+		switch e := db.One("UUID", it.GetUUID(), &ci); {
+		case e == storm.ErrNotFound:
+			continue
+		case e != nil:
+			_ = db.Close()
+
+			return e
+		case ci.Dirty:
+			unsaved++
+		}
+	}
+
+	if err = db.Close(); err != nil {
+		return err
+	}
+
+	if unsaved > 0 {
+		return fmt.Errorf("%d items weren't saved by SN", unsaved)
+	}
+
+	return nil
+}
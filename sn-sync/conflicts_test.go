@@ -0,0 +1,135 @@
+package snsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asdine/storm/v3"
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConflictedCopies(t *testing.T) {
+	home := getTemporaryHome()
+	require.NoError(t, os.MkdirAll(home, 0o700))
+
+	defer os.RemoveAll(home)
+
+	db, err := storm.Open(filepath.Join(home, "cache.db"))
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	original, other, deleted := "original", "other", "deleted"
+
+	require.NoError(t, cache.SaveCacheItems(db, cache.Items{
+		{UUID: "original", ContentType: "Note"},
+		{UUID: "copy", ContentType: "Note", DuplicateOf: &original},
+		{UUID: "unrelated", ContentType: "Note", DuplicateOf: &other},
+		{UUID: "deleted-copy", ContentType: "Note", DuplicateOf: &deleted, Deleted: true},
+		{UUID: "tag-copy", ContentType: "Tag", DuplicateOf: &deleted},
+		{UUID: "earlier-copy", ContentType: "Note", DuplicateOf: &original},
+	}, false))
+
+	note := createNote(".bashrc", "x")
+	note.UUID = original
+	tag := mustCreateTag("dotfiles")
+	tag.UUID = deleted
+	known := map[string]bool{"original": true, "earlier-copy": true}
+
+	copies, err := conflictedCopies(db, testCacheSession, items.Items{&note, &tag}, known)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"copy": "original", "tag-copy": "deleted"}, copies)
+
+	copies, err = conflictedCopies(db, testCacheSession, nil, known)
+	require.NoError(t, err)
+	assert.Empty(t, copies)
+}
+
+func TestConflictedCopiesCreatedWithOriginal(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	// sync to get the items key
+	store := remoteStore(Options{}, testCacheSession)
+	_, _, err := getTagsWithNotes(context.Background(), store)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	home := getTemporaryHome()
+	require.NoError(t, os.MkdirAll(home, 0o700))
+
+	defer os.RemoveAll(home)
+
+	db, err := storm.Open(filepath.Join(home, "cache.db"))
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	note := createNote(".bashrc", "x")
+
+	// gosn's copy keeps the creation time of the note copied, unlike one with the same content from elsewhere
+	gosnCopy := createNote(".bashrc", "x")
+	gosnCopy.CreatedAtTimestamp = note.CreatedAtTimestamp
+	duplicate := createNote(".bashrc", "x")
+	duplicate.CreatedAtTimestamp = note.CreatedAtTimestamp + 1
+
+	its := items.Items{&gosnCopy, &duplicate}
+	eis, err := its.Encrypt(testCacheSession.Session, testCacheSession.DefaultItemsKey)
+	require.NoError(t, err)
+	require.NoError(t, cache.SaveCacheItems(db, cache.ToCacheItems(eis, true), false))
+
+	copies, err := conflictedCopies(db, testCacheSession, items.Items{&note}, map[string]bool{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{gosnCopy.UUID: note.UUID}, copies)
+}
+
+func TestSameContent(t *testing.T) {
+	a := createNote(".bashrc", "x")
+	b := createNote(".bashrc", "x")
+	c := createNote(".bashrc", "y")
+	tag := mustCreateTag(".bashrc")
+
+	assert.True(t, sameContent(&a, &b))
+	assert.False(t, sameContent(&a, &c))
+	assert.False(t, sameContent(&a, &tag))
+}
+
+func TestRestoreBase(t *testing.T) {
+	stateDir := getTemporaryHome()
+
+	defer os.RemoveAll(stateDir)
+
+	d := ItemDiff{path: "/home/.bashrc", homeRelPath: ".bashrc", remote: createNote(".bashrc", "new")}
+
+	// pushed without a previous sync
+	p, err := newPushedItem(stateDir, d, false)
+	require.NoError(t, err)
+	assert.False(t, p.hadBase)
+
+	require.NoError(t, saveBase(stateDir, d.remote.UUID, "new"))
+	require.NoError(t, restoreBase(stateDir, d.remote.UUID, p))
+
+	_, found, err := loadBase(stateDir, d.remote.UUID)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// pushed after a previous sync
+	require.NoError(t, saveBase(stateDir, d.remote.UUID, "old"))
+
+	p, err = newPushedItem(stateDir, d, true)
+	require.NoError(t, err)
+	assert.True(t, p.hadBase)
+	assert.True(t, p.merged)
+
+	require.NoError(t, saveBase(stateDir, d.remote.UUID, "new"))
+	require.NoError(t, restoreBase(stateDir, d.remote.UUID, p))
+
+	base, found, err := loadBase(stateDir, d.remote.UUID)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "old", base)
+}
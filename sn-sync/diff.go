@@ -1,69 +1,62 @@
 package snsync
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 
-	"github.com/briandowns/spinner"
 	"github.com/jonhadfield/findexec"
 	"github.com/jonhadfield/gosn-v2/cache"
 	"github.com/jonhadfield/gosn-v2/items"
 )
 
 const (
-	localMissing = "local missing"
-	localNewer   = "local newer"
-	remoteNewer  = "remote newer"
-	untracked    = "untracked"
-	identical    = "identical"
+	localMissing  = "local missing"
+	blockMissing  = "block missing"
+	localNewer    = "local newer"
+	remoteNewer   = "remote newer"
+	untracked     = "untracked"
+	identical     = "identical"
+	merged        = "merged"
+	conflicted    = "conflict"
+	invalidPath   = "invalid path"
+	undecryptable = "decrypt failed"
+	notPlainText  = "not plain text"
+	failed        = "failed"
+	cancelled     = "cancelled"
 )
 
-func Diff(session *cache.Session, home string, paths []string, pageSize int, close, useStdErr bool) (diffs []ItemDiff, msg string, err error) {
-	debugPrint(session.Debug, fmt.Sprintf("Diff | %d paths", len(paths)))
-
-	if !session.Debug {
-		prefix := HiWhite("syncing ")
-		if _, err = os.Stat(session.CacheDBPath); os.IsNotExist(err) {
-			prefix = HiWhite("initializing ")
-		}
+func Diff(ctx context.Context, session *cache.Session, home string, paths []string, pageSize int, opts Options, close bool) (diffs []ItemDiff, msg string, err error) {
+	r := opts.reporter()
+	defer func() { r.Finished(err) }()
 
-		s := spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stdout))
-		if useStdErr {
-			s = spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stderr))
-		}
+	debugPrint(session.Debug, fmt.Sprintf("Diff | %d paths", len(paths)))
 
-		s.Prefix = prefix
-		s.Start()
-		defer s.Stop()
-	}
+	r.PhaseStarted(loadPhase(session, opts), 0)
 
-	// get populated db
-	si := cache.SyncInput{
-		Session: session,
-		Close:   false,
-	}
-	var cso cache.SyncOutput
-	cso, err = cache.Sync(si)
-	if err != nil {
-		return
-	}
+	// the cache db is written to when opened, so note when it was last synced first
+	warning := staleWarning(session, opts)
+	store := remoteStore(opts, session)
 
 	var remote tagsWithNotes
 
-	remote, err = getTagsWithNotes(cso.DB, session)
+	remote, _, err = getTagsWithNotes(ctx, store)
 	if err != nil {
 		return diffs, msg, err
 	}
-	if err = cso.DB.Close(); err != nil {
-		return
+
+	if err = store.Close(); err != nil {
+		return diffs, msg, err
 	}
 
-	return diff(remote, home, paths, session.Debug)
+	diffs, msg, err = diff(ctx, remote, home, paths, opts, session.Debug)
+	msg = warning + msg
+
+	return diffs, msg, err
 }
 
 // TODO: rename homeRelPath? relPath? rootRelPath?
@@ -75,9 +68,18 @@ type ItemDiff struct {
 	diff        string
 	remote      items.Note
 	local       string
+	conflicts   []string
+	err         error
+	// encrypted is true if the remote content was encrypted with the encryption passphrase
+	encrypted bool
+	flags     noteFlags
+	// markup is true if the remote content has been rewritten by a rich text editor
+	markup bool
+	// compared is the result of comparing the content, kept when diff is notPlainText
+	compared string
 }
 
-func diff(twn tagsWithNotes, home string, paths []string, debug bool) (diffs []ItemDiff, msg string, err error) {
+func diff(ctx context.Context, twn tagsWithNotes, home string, paths []string, opts Options, debug bool) (diffs []ItemDiff, msg string, err error) {
 	debugPrint(debug, fmt.Sprintf("diff | %d remote items", len(twn)))
 
 	err = checkNoteTagConflicts(twn)
@@ -96,7 +98,7 @@ func diff(twn tagsWithNotes, home string, paths []string, debug bool) (diffs []I
 		debugPrint(debug, fmt.Sprintf("diff | calling compare with Paths: %s", strings.Join(paths, ",")))
 	}
 
-	diffs, err = compare(twn, home, paths, []string{}, debug)
+	diffs, err = compare(ctx, twn, home, paths, []string{}, opts, debug)
 	if err != nil {
 		return diffs, msg, err
 	}
@@ -120,7 +122,7 @@ func diff(twn tagsWithNotes, home string, paths []string, debug bool) (diffs []I
 		tempDir += string(os.PathSeparator)
 	}
 
-	differencesFound, err = processContentDiffs(diffs, tempDir, diffBinary)
+	differencesFound, err = processContentDiffs(ctx, diffs, tempDir, diffBinary)
 	if err != nil {
 		return
 	}
@@ -129,11 +131,27 @@ func diff(twn tagsWithNotes, home string, paths []string, debug bool) (diffs []I
 		msg = "no differences found"
 	}
 
+	_, failures := withoutFailures(diffs)
+
+	summary, err := failureSummary(failures)
+	msg += summary
+
 	return diffs, msg, err
 }
 
-func processContentDiffs(diffs []ItemDiff, tempDir, diffBinary string) (differencesFound bool, err error) {
+func processContentDiffs(ctx context.Context, diffs []ItemDiff, tempDir, diffBinary string) (differencesFound bool, err error) {
 	for _, diff := range diffs {
+		if diff.diff == failed {
+			continue
+		}
+
+		if diff.diff == invalidPath || diff.diff == undecryptable || diff.diff == notPlainText {
+			fmt.Println(bold(diff.homeRelPath), red(diff.err.Error()))
+			fmt.Println()
+
+			continue
+		}
+
 		localContent := diff.local
 
 		remoteContent := diff.remote.Content.GetText()
@@ -164,7 +182,11 @@ func processContentDiffs(diffs []ItemDiff, tempDir, diffBinary string) (differen
 				return
 			}
 
-			cmd := exec.Command(diffBinary, f1path, f2path)
+			if err = errors.Join(f1.Close(), f2.Close()); err != nil {
+				return
+			}
+
+			cmd := exec.CommandContext(ctx, diffBinary, f1path, f2path)
 			out, oErr := cmd.CombinedOutput()
 
 			if err = os.Remove(f1path); err != nil {
@@ -175,16 +197,10 @@ func processContentDiffs(diffs []ItemDiff, tempDir, diffBinary string) (differen
 				return
 			}
 
-			var exitCode int
-
-			if oErr != nil {
-				if exitError, ok := oErr.(*exec.ExitError); ok {
-					exitCode = exitError.ExitCode()
-				}
-			}
-
-			if exitCode == 2 {
-				panic(fmt.Sprintf("failed to compare: '%s' with '%s'", f1path, f2path))
+			// diff exits 1 when the files differ, and 2 if it fails
+			var exitError *exec.ExitError
+			if oErr != nil && (!errors.As(oErr, &exitError) || exitError.ExitCode() != 1) {
+				return differencesFound, fmt.Errorf("failed to compare %s: %w: %s", diff.homeRelPath, oErr, strings.TrimSpace(string(out)))
 			}
 
 			fmt.Println(bold(diff.homeRelPath))
@@ -241,7 +257,7 @@ func noteInPaths(note string, paths []string) bool {
 func checkPathsExist(paths []string) error {
 	for _, p := range paths {
 		if _, err := os.Stat(p); err != nil || os.IsNotExist(err) {
-			return fmt.Errorf("failed to read path: %s", p)
+			return &ErrPathInvalid{Path: p, Reason: "failed to read path"}
 		}
 	}
 
@@ -258,7 +274,7 @@ func tagExists(title string, twn tagsWithNotes) bool {
 	return false
 }
 
-func findUntracked(paths, existingRemoteEquivalentPaths []string, home string, debug bool) (itemDiffs []ItemDiff) {
+func findUntracked(ctx context.Context, paths, existingRemoteEquivalentPaths []string, home string, keepGoing, debug bool) (itemDiffs []ItemDiff, err error) {
 	// if path is directory, then walk to generate list of additional Paths
 	for _, path := range paths {
 		debugPrint(debug, fmt.Sprintf("compare | diffing path: %s", stripHome(path, home)))
@@ -267,21 +283,30 @@ func findUntracked(paths, existingRemoteEquivalentPaths []string, home string, d
 			continue
 		}
 
-		if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		if stat, sErr := os.Stat(path); sErr == nil && stat.IsDir() {
 			debugPrint(debug, fmt.Sprintf("compare | walking path: %s", path))
 
 			err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+				if cErr := ctx.Err(); cErr != nil {
+					return cErr
+				}
 				// don't check tracked Paths
 				if StringInSlice(p, existingRemoteEquivalentPaths, true) {
 					return nil
 				}
-				if err != nil {
-					fmt.Printf("failed to read path %q: %v\n", p, err)
-					return err
+				if err == nil {
+					// ensure walked path is valid
+					_, err = pathValid(p)
 				}
-				// ensure walked path is valid
-				if v, err := pathValid(p); !v {
-					return err
+				if err != nil {
+					if !keepGoing {
+						return err
+					}
+
+					debugPrint(debug, fmt.Sprintf("compare | failed to read path: %s: %s", p, err))
+					itemDiffs = append(itemDiffs, failedItemDiff(p, home, err))
+
+					return nil
 				}
 				// add file as untracked
 				if stat, err := os.Stat(p); err == nil && !stat.IsDir() {
@@ -296,7 +321,7 @@ func findUntracked(paths, existingRemoteEquivalentPaths []string, home string, d
 				return nil
 			})
 			if err != nil {
-				return
+				return nil, err
 			}
 		} else {
 			homeRelPath := stripHome(path, home)
@@ -310,5 +335,5 @@ func findUntracked(paths, existingRemoteEquivalentPaths []string, home string, d
 		}
 	}
 
-	return itemDiffs
+	return itemDiffs, nil
 }
@@ -1,13 +1,15 @@
 package snsync
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/jonhadfield/gosn-v2"
+	"github.com/jonhadfield/gosn-v2/items"
 
 	"testing"
 
@@ -39,11 +41,11 @@ func TestNoteInPaths(t *testing.T) {
 }
 
 func testCompareSetup1and2(home string) (twn tagsWithNotes, fwc map[string]string) {
-	fruitTag := createTag("sync.sn-sync-test-fruit")
+	fruitTag := mustCreateTag("sync.sn-sync-test-fruit")
 	appleNote := createNote("apple", "apple content")
 	lemonNote := createNote("lemon", "lemon content")
 	grapeNote := createNote("grape", "grape content")
-	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: gosn.Notes{appleNote, lemonNote, grapeNote}}
+	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: items.Notes{appleNote, lemonNote, grapeNote}}
 	twn = tagsWithNotes{fruitTagWithNotes}
 
 	fwc = make(map[string]string)
@@ -53,10 +55,7 @@ func testCompareSetup1and2(home string) (twn tagsWithNotes, fwc map[string]strin
 }
 
 func TestTagExists(t *testing.T) {
-	tag1Content := gosn.NewTagContent()
-	tag1Content.Title = "rod"
-	tag1 := gosn.NewTag()
-	tag1.Content = *tag1Content
+	tag1 := mustCreateTag("rod")
 	twn := tagsWithNotes{
 		tagWithNotes{
 			tag:   tag1,
@@ -71,7 +70,7 @@ func TestDiff(t *testing.T) {
 	home := getTemporaryHome()
 	twn, fwc := testCompareSetup1and2(home)
 	// test when locals do not exist
-	diffs, _, err := diff(twn, home, []string{}, true)
+	diffs, _, err := diff(context.Background(), twn, home, []string{}, Options{}, true)
 	assert.NoError(t, err)
 	assert.Len(t, diffs, 3)
 	assert.Equal(t, diffs[0].diff, localMissing)
@@ -85,12 +84,12 @@ func TestDiff(t *testing.T) {
 			fmt.Printf("failed to clean-up: %s\ndetails: %v\n", home, err)
 		}
 	}()
-	diffs, _, err = diff(twn, home, []string{}, true)
+	diffs, _, err = diff(context.Background(), twn, home, []string{}, Options{}, true)
 	assert.Equal(t, diffs[0].diff, identical)
 	assert.Equal(t, diffs[1].diff, identical)
 	assert.Equal(t, diffs[2].diff, localMissing)
 	// test when no tags with notes supplied
-	diffs, _, err = diff(tagsWithNotes{}, home, []string{}, true)
+	diffs, _, err = diff(context.Background(), tagsWithNotes{}, home, []string{}, Options{}, true)
 	assert.NoError(t, err)
 	assert.Len(t, diffs, 0)
 }
@@ -108,12 +107,11 @@ func TestCompare1(t *testing.T) {
 	}()
 
 	// missing remote and missing local
-	_, err = compare(tagsWithNotes{}, home, []string{"missing-file"}, []string{}, true)
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "tags with notes not supplied")
+	_, err = compare(context.Background(), tagsWithNotes{}, home, []string{"missing-file"}, []string{}, Options{}, true)
+	assert.ErrorIs(t, err, ErrNoRemoteItems)
 
 	// existing remote and missing local
-	_, err = compare(twn, home, []string{"missing-file"}, []string{}, true)
+	_, err = compare(context.Background(), twn, home, []string{"missing-file"}, []string{}, Options{}, true)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "no such file")
 
@@ -121,7 +119,7 @@ func TestCompare1(t *testing.T) {
 	applePath := fmt.Sprintf("%s/.sn-sync-test-fruit/apple", home)
 	lemonPath := fmt.Sprintf("%s/.sn-sync-test-fruit/lemon", home)
 	allPaths := []string{applePath, lemonPath}
-	diffs, err = compare(twn, home, allPaths, []string{}, true)
+	diffs, err = compare(context.Background(), twn, home, allPaths, []string{}, Options{}, true)
 	assert.NoError(t, err)
 	assert.Len(t, diffs, 2)
 	assert.NotEmpty(t, diffs)
@@ -194,7 +192,7 @@ func TestCompare2(t *testing.T) {
 
 	// valid local, valid remote, grape not compare'd as not specified in path
 	paths := []string{fmt.Sprintf("%s/.sn-sync-test-fruit/", home)}
-	diffs, err = compare(twn, home, paths, []string{}, true)
+	diffs, err = compare(context.Background(), twn, home, paths, []string{}, Options{}, true)
 	assert.NoError(t, err)
 	assert.Len(t, diffs, 3)
 	assert.NotEmpty(t, diffs)
@@ -225,9 +223,9 @@ func TestCompare2(t *testing.T) {
 
 func TestCompare3(t *testing.T) {
 	home := getTemporaryHome()
-	fruitTag := createTag("sync")
+	fruitTag := mustCreateTag("sync")
 	appleNote := createNote(".apple", "apple content")
-	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: gosn.Notes{appleNote}}
+	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: items.Notes{appleNote}}
 	twn := tagsWithNotes{fruitTagWithNotes}
 
 	fwc := make(map[string]string)
@@ -244,7 +242,7 @@ func TestCompare3(t *testing.T) {
 
 	// valid local, valid remote, grape not compare'd as not specified in path
 	paths := []string{fmt.Sprintf("%s/.apple", home)}
-	diffs, err = compare(twn, home, paths, []string{}, true)
+	diffs, err = compare(context.Background(), twn, home, paths, []string{}, Options{}, true)
 	assert.NoError(t, err)
 	assert.Len(t, diffs, 1)
 	assert.Equal(t, identical, diffs[0].diff)
@@ -264,10 +262,10 @@ func TestCompare3(t *testing.T) {
 
 func TestCompare4(t *testing.T) {
 	home := getTemporaryHome()
-	fruitTag := createTag("sync")
+	fruitTag := mustCreateTag("sync")
 	appleNote := createNote(".apple", "apple content")
 
-	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: gosn.Notes{appleNote}}
+	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: items.Notes{appleNote}}
 	twn := tagsWithNotes{fruitTagWithNotes}
 
 	fwc := make(map[string]string)
@@ -285,7 +283,7 @@ func TestCompare4(t *testing.T) {
 	}()
 
 	paths := []string{fmt.Sprintf("%s/.apple", home), fmt.Sprintf("%s/.banana", home), fmt.Sprintf("%s/.cars", home)}
-	diffs, err = compare(twn, home, paths, []string{}, true)
+	diffs, err = compare(context.Background(), twn, home, paths, []string{}, Options{}, true)
 	assert.NoError(t, err)
 	assert.Len(t, diffs, 3)
 	assert.Equal(t, identical, diffs[0].diff)
@@ -305,13 +303,14 @@ func TestCompare4(t *testing.T) {
 }
 
 // helpers
-func createNote(title, content string) gosn.Note {
-	noteContent := gosn.NewNoteContent()
-	noteContent.Title = title
-	noteContent.Text = content
-	note := gosn.NewNote()
-	note.Content = *noteContent
-	note.ContentType = "Note"
+func createNote(title, content string) items.Note {
+	note, err := items.NewNote(title, content, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	note.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+
 	return note
 }
 
@@ -345,5 +344,11 @@ func createPathWithContent(path, content string) error {
 	if err != nil {
 		return err
 	}
-	return f.Close()
+	if err = f.Close(); err != nil {
+		return err
+	}
+	// the filesystem stamps files from a coarse clock that can lag the one the test server stamps
+	// notes with, so a file written just after a note is saved could look older than it
+	now := time.Now()
+	return os.Chtimes(path, now, now)
 }
@@ -0,0 +1,547 @@
+package snsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonhadfield/gosn-v2/auth"
+	"github.com/jonhadfield/gosn-v2/common"
+	"github.com/jonhadfield/gosn-v2/crypto"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/jonhadfield/gosn-v2/session"
+)
+
+const (
+	// DirStoreScheme prefixes the server of a directory store, e.g. file:///media/usb/dotfiles
+	DirStoreScheme = "file://"
+
+	dirStoreKeyParamsFile = "keyparams.json"
+	dirStoreLockFile      = "sn-sync.lock"
+	dirStoreItemsDir      = "items"
+	dirStoreItemExt       = ".json"
+)
+
+// ErrDirStorePassword is returned when the items in a directory store can't be decrypted with the password given
+var ErrDirStorePassword = errors.New("incorrect password for directory")
+
+// DirStorePath returns the directory of a file:// server, and false if the server isn't one
+func DirStorePath(server string) (dir string, ok bool) {
+	if !strings.HasPrefix(server, DirStoreScheme) {
+		return "", false
+	}
+
+	return filepath.Clean(strings.TrimPrefix(server, DirStoreScheme)), true
+}
+
+// DirStoreLocalPath returns the path standing in for the cache db of a directory store on this machine, so its
+// lock and sync state, which mustn't be shared with other machines using the directory, are kept in cacheDir.
+// If cacheDir is empty, ~/.sn-sync is used, as for the cache db.
+func DirStoreLocalPath(dir, cacheDir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if cacheDir == "" {
+		home, hErr := os.UserHomeDir()
+		if hErr != nil {
+			return "", hErr
+		}
+
+		cacheDir = filepath.Join(home, "."+SNAppName)
+	}
+
+	if err = os.MkdirAll(cacheDir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to make cache directory: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(abs))
+
+	return filepath.Join(cacheDir, fmt.Sprintf("%s-dir-%s.db", SNAppName, hex.EncodeToString(sum[:4]))), nil
+}
+
+// NewDirStore returns a RemoteStore that keeps items in a directory, such as on a USB stick or a shared mount,
+// rather than a Standard Notes account. Each item is a JSON file, encrypted as SN encrypts it, with keys derived
+// from the identifier and password. The directory is set up when first loaded. Loading and pushing hold a lock
+// on the directory, so machines sharing it take turns.
+func NewDirStore(dir, identifier, password string, debug bool) RemoteStore {
+	return &dirStore{
+		dir:        dir,
+		identifier: identifier,
+		password:   password,
+		debug:      debug,
+	}
+}
+
+// dirStore keeps the tracked items as encrypted item files in a directory
+type dirStore struct {
+	dir        string
+	identifier string
+	password   string
+	debug      bool
+	// session holds the keys once the directory is opened
+	session *session.Session
+	// loaded maps the uuid of each item loaded to its updated time, or is nil if the store isn't loaded
+	loaded map[string]int64
+	tags   items.Tags
+	// notes holds the encrypted notes loaded, so their flags can be read
+	notes map[string]items.EncryptedItem
+	// lastTime is the latest updated time given to an item, so each item saved is given a later one
+	lastTime int64
+}
+
+func (d *dirStore) Load(ctx context.Context) (its items.Items, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	d.loaded = nil
+	d.notes = nil
+
+	lock, err := d.lock(ctx)
+	if err != nil {
+		return
+	}
+
+	defer func() { _ = lock.Release() }()
+
+	if err = d.open(); err != nil {
+		return
+	}
+
+	eItems, err := d.readItems()
+	if err != nil {
+		return
+	}
+
+	loaded := make(map[string]int64)
+	notes := make(map[string]items.EncryptedItem)
+
+	var toDecrypt items.EncryptedItems
+
+	for _, ei := range eItems {
+		loaded[ei.UUID] = ei.UpdatedAtTimestamp
+
+		if ei.ContentType == common.SNItemTypeNote {
+			notes[ei.UUID] = ei
+		}
+
+		if ei.UpdatedAtTimestamp > d.lastTime {
+			d.lastTime = ei.UpdatedAtTimestamp
+		}
+
+		if ei.ContentType != common.SNItemTypeItemsKey {
+			toDecrypt = append(toDecrypt, ei)
+		}
+	}
+
+	if len(toDecrypt) > 0 {
+		its, err = toDecrypt.DecryptAndParse(d.session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt items in %s: %w", d.dir, err)
+		}
+	}
+
+	d.loaded = loaded
+	d.notes = notes
+	d.tags = its.Tags()
+
+	debugPrint(d.debug, fmt.Sprintf("dirStore.Load | loaded %d items from %s", len(its), d.dir))
+
+	return its, nil
+}
+
+// Push saves the items as files, removing those deleted. An item is refused if its file has changed since the
+// store was loaded, such as by another machine sharing the directory, and its file is left as it is. The lock on
+// the directory is held from checking the files to writing them, so another push can't change them in between.
+// Each file is replaced atomically, but the push as a whole isn't: if writing fails part way, the files already
+// written are kept, and are loaded as changed by the next sync.
+func (d *dirStore) Push(ctx context.Context, its items.Items) (rejected []string, err error) {
+	if d.loaded == nil {
+		return nil, errors.New("changes not pushed: store isn't loaded")
+	}
+
+	loaded := d.loaded
+	d.loaded = nil
+	d.notes = nil
+
+	existing := make(map[string]bool)
+	for uuid := range loaded {
+		existing[uuid] = true
+	}
+
+	b := &batch{items: its}
+	if err = b.check(existing, d.tags); err != nil {
+		return nil, fmt.Errorf("changes not pushed: %w", err)
+	}
+
+	lock, err := d.lock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("changes not pushed: %w", err)
+	}
+
+	defer func() { _ = lock.Release() }()
+
+	// encrypt everything first, so failing to encrypt an item leaves the directory unchanged
+	var toSave items.EncryptedItems
+
+	var toRemove []string
+
+	for _, it := range its {
+		uuid := it.GetUUID()
+
+		current, found, rErr := d.readItem(uuid)
+		if rErr != nil {
+			return nil, fmt.Errorf("changes not pushed: %w", rErr)
+		}
+
+		if it.IsDeleted() {
+			if found {
+				toRemove = append(toRemove, uuid)
+			}
+
+			continue
+		}
+
+		updated, wasLoaded := loaded[uuid]
+		if found != wasLoaded || (found && current.UpdatedAtTimestamp != updated) {
+			debugPrint(d.debug, fmt.Sprintf("dirStore.Push | %s changed since loaded, refusing it", uuid))
+
+			rejected = append(rejected, uuid)
+
+			continue
+		}
+
+		var ei items.EncryptedItem
+
+		ei, err = items.EncryptItem(it, d.session.DefaultItemsKey, d.session)
+		if err != nil {
+			return nil, fmt.Errorf("changes not pushed: failed to encrypt %s: %w", uuid, err)
+		}
+
+		if found {
+			ei.CreatedAt = current.CreatedAt
+			ei.CreatedAtTimestamp = current.CreatedAtTimestamp
+		}
+
+		d.stamp(&ei)
+		toSave = append(toSave, ei)
+	}
+
+	for _, ei := range toSave {
+		if err = d.writeItem(ei); err != nil {
+			return nil, fmt.Errorf("changes not pushed: %w", err)
+		}
+	}
+
+	for _, uuid := range toRemove {
+		if err = os.Remove(d.itemPath(uuid)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("changes not pushed: %w", err)
+		}
+	}
+
+	debugPrint(d.debug, fmt.Sprintf("dirStore.Push | saved %d items and removed %d in %s", len(toSave), len(toRemove), d.dir))
+
+	return rejected, nil
+}
+
+func (d *dirStore) Delete(ctx context.Context, its items.Items) (rejected []string, err error) {
+	for _, it := range its {
+		it.SetDeleted(true)
+	}
+
+	return d.Push(ctx, its)
+}
+
+func (d *dirStore) Close() error {
+	d.loaded = nil
+	d.notes = nil
+
+	return nil
+}
+
+func (d *dirStore) noteFlags(uuids map[string]bool) (map[string]noteFlags, error) {
+	var eItems items.EncryptedItems
+
+	for uuid := range uuids {
+		if ei, ok := d.notes[uuid]; ok {
+			eItems = append(eItems, ei)
+		}
+	}
+
+	return decryptNoteFlags(d.session, eItems)
+}
+
+// lock takes the lock on the directory, waiting while another machine or process loads from or pushes to it
+func (d *dirStore) lock(ctx context.Context) (*Lock, error) {
+	return acquireLockFile(ctx, filepath.Join(d.dir, dirStoreLockFile), true, d.debug)
+}
+
+// open derives the keys from the password, setting up the directory with new key params and an items key
+// if it doesn't have them
+func (d *dirStore) open() error {
+	if d.session != nil {
+		return nil
+	}
+
+	kpPath := filepath.Join(d.dir, dirStoreKeyParamsFile)
+
+	var kp auth.KeyParams
+
+	b, err := os.ReadFile(kpPath)
+
+	switch {
+	case os.IsNotExist(err):
+		return d.setup()
+	case err != nil:
+		return err
+	}
+
+	if err = json.Unmarshal(b, &kp); err != nil {
+		return fmt.Errorf("invalid %s: %w", kpPath, err)
+	}
+
+	mk, err := masterKey(kp, d.password)
+	if err != nil {
+		return err
+	}
+
+	eItems, err := d.readItems()
+	if err != nil {
+		return err
+	}
+
+	var keys items.EncryptedItems
+
+	for _, ei := range eItems {
+		if ei.ContentType == common.SNItemTypeItemsKey {
+			keys = append(keys, ei)
+		}
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no items key found in %s", d.dir)
+	}
+
+	iks, err := keys.DecryptAndParseItemsKeys(mk, d.debug)
+	if err != nil {
+		return fmt.Errorf("%w %s", ErrDirStorePassword, d.dir)
+	}
+
+	d.session = &session.Session{
+		Debug:     d.debug,
+		MasterKey: mk,
+		KeyParams: kp,
+		ItemsKeys: iks,
+	}
+
+	d.session.DefaultItemsKey = iks[0]
+
+	for _, ik := range iks {
+		if ik.Default {
+			d.session.DefaultItemsKey = ik
+		}
+	}
+
+	return nil
+}
+
+// setup creates the key params and items key of a new directory store
+func (d *dirStore) setup() error {
+	if d.identifier == "" || d.password == "" {
+		return errors.New("an email and password are required to set up a directory")
+	}
+
+	if err := os.MkdirAll(filepath.Join(d.dir, dirStoreItemsDir), 0o700); err != nil {
+		return err
+	}
+
+	kp := auth.KeyParams{
+		Created:     strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Identifier:  d.identifier,
+		Origination: "registration",
+		PwNonce:     hex.EncodeToString(crypto.GenerateNonce())[:32],
+		Version:     common.DefaultSNVersion,
+	}
+
+	mk, err := masterKey(kp, d.password)
+	if err != nil {
+		return err
+	}
+
+	ik := session.SessionItemsKey{
+		UUID:     items.GenUUID(),
+		ItemsKey: crypto.GenerateItemKey(64),
+		Default:  true,
+	}
+
+	ei, err := encryptItemsKey(ik, mk, kp)
+	if err != nil {
+		return err
+	}
+
+	d.stamp(&ei)
+
+	if err = d.writeItem(ei); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(kp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// written last, so a directory is only used once it has an items key
+	if err = writeFileAtomic(filepath.Join(d.dir, dirStoreKeyParamsFile), b); err != nil {
+		return err
+	}
+
+	debugPrint(d.debug, fmt.Sprintf("dirStore.setup | set up %s", d.dir))
+
+	d.session = &session.Session{
+		Debug:           d.debug,
+		MasterKey:       mk,
+		KeyParams:       kp,
+		ItemsKeys:       []session.SessionItemsKey{ik},
+		DefaultItemsKey: ik,
+	}
+
+	return nil
+}
+
+// stamp sets the item's updated time, and its created time if not set, to a time later than any given before
+func (d *dirStore) stamp(ei *items.EncryptedItem) {
+	now := time.Now().UTC().UnixMicro()
+	if now <= d.lastTime {
+		now = d.lastTime + 1
+	}
+
+	d.lastTime = now
+	t := time.UnixMicro(now).UTC().Format("2006-01-02T15:04:05.000Z")
+
+	ei.UpdatedAtTimestamp = now
+	ei.UpdatedAt = t
+
+	if ei.CreatedAtTimestamp == 0 {
+		ei.CreatedAtTimestamp = now
+		ei.CreatedAt = t
+	}
+}
+
+func (d *dirStore) itemPath(uuid string) string {
+	return filepath.Join(d.dir, dirStoreItemsDir, uuid+dirStoreItemExt)
+}
+
+// readItems returns the items in the directory, ignoring temporary files left by an interrupted write
+func (d *dirStore) readItems() (eItems items.EncryptedItems, err error) {
+	entries, err := os.ReadDir(filepath.Join(d.dir, dirStoreItemsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != dirStoreItemExt {
+			continue
+		}
+
+		ei, found, rErr := d.readItem(strings.TrimSuffix(name, dirStoreItemExt))
+		if rErr != nil {
+			return nil, rErr
+		}
+
+		if found {
+			eItems = append(eItems, ei)
+		}
+	}
+
+	return eItems, nil
+}
+
+func (d *dirStore) readItem(uuid string) (ei items.EncryptedItem, found bool, err error) {
+	if err = validateNoteTitle(uuid); err != nil {
+		return
+	}
+
+	b, err := os.ReadFile(d.itemPath(uuid))
+	if os.IsNotExist(err) {
+		return ei, false, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(b, &ei); err != nil {
+		return ei, false, fmt.Errorf("invalid item %s: %w", d.itemPath(uuid), err)
+	}
+
+	return ei, true, nil
+}
+
+func (d *dirStore) writeItem(ei items.EncryptedItem) error {
+	b, err := json.MarshalIndent(ei, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(d.itemPath(ei.UUID), b)
+}
+
+// masterKey derives the master key from the password, as SN does when signing in
+func masterKey(kp auth.KeyParams, password string) (string, error) {
+	mk, _, err := crypto.GenerateMasterKeyAndServerPassword004(crypto.GenerateEncryptedPasswordInput{
+		UserPassword:  password,
+		Identifier:    kp.Identifier,
+		PasswordNonce: kp.PwNonce,
+	})
+
+	return mk, err
+}
+
+// encryptItemsKey encrypts the items key with the master key, as SN clients do on registration
+func encryptItemsKey(ik session.SessionItemsKey, mk string, kp auth.KeyParams) (ei items.EncryptedItem, err error) {
+	content, err := json.Marshal(items.ItemsKeyContent{
+		ItemsKey: ik.ItemsKey,
+		Version:  common.DefaultSNVersion,
+		Default:  ik.Default,
+	})
+	if err != nil {
+		return
+	}
+
+	ei.UUID = ik.UUID
+	ei.ContentType = common.SNItemTypeItemsKey
+
+	authData := base64.StdEncoding.EncodeToString([]byte(auth.GenerateAuthData(ei.ContentType, ei.UUID, kp)))
+	itemKey := crypto.GenerateItemKey(64)
+
+	if ei.Content, err = encrypt004(string(content), itemKey, authData); err != nil {
+		return
+	}
+
+	ei.EncItemKey, err = encrypt004(itemKey, mk, authData)
+
+	return ei, err
+}
+
+func encrypt004(plainText, key, authData string) (string, error) {
+	nonce := hex.EncodeToString(crypto.GenerateNonce())
+
+	cipherText, err := crypto.EncryptString(plainText, key, nonce, authData, 32)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("004:%s:%s:%s", nonce, cipherText, authData), nil
+}
@@ -0,0 +1,187 @@
+package snsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/jonhadfield/gosn-v2/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirStorePath(t *testing.T) {
+	dir, ok := DirStorePath("file:///media/usb/dotfiles/")
+	assert.True(t, ok)
+	assert.Equal(t, "/media/usb/dotfiles", dir)
+
+	_, ok = DirStorePath("https://api.standardnotes.com")
+	assert.False(t, ok)
+}
+
+func TestCommandsWithDirStore(t *testing.T) {
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	storeDir := t.TempDir()
+
+	bashrc := filepath.Join(home, ".bashrc")
+	require.NoError(t, createTemporaryFiles(map[string]string{bashrc: "export SECRET_WORD=apple\n"}))
+
+	opts := Options{Store: NewDirStore(storeDir, "me@example.com", "dir-password", true)}
+	session := &cache.Session{Session: &session.Session{Debug: true}}
+	ctx := context.Background()
+
+	ao, err := Add(ctx, AddInput{Session: session, Home: home, Paths: []string{bashrc}, Options: opts})
+	require.NoError(t, err)
+	assert.Equal(t, 1, ao.NotesPushed)
+
+	// items are kept encrypted
+	files, err := filepath.Glob(filepath.Join(storeDir, dirStoreItemsDir, "*.json"))
+	require.NoError(t, err)
+	assert.Len(t, files, 3) // items key, dotfiles tag and note
+
+	for _, f := range files {
+		b, rErr := os.ReadFile(f)
+		require.NoError(t, rErr)
+		assert.NotContains(t, string(b), "apple")
+	}
+
+	// opened again, as on another machine
+	opts.Store = NewDirStore(storeDir, "me@example.com", "dir-password", true)
+
+	diffs, _, err := Status(ctx, session, home, nil, DefaultPageSize, opts, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, identical, diffs[0].diff)
+
+	ro, err := Remove(ctx, RemoveInput{Session: session, Home: home, Paths: []string{bashrc}, Options: opts, Debug: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, ro.NotesRemoved)
+
+	its, err := opts.Store.Load(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, its.Notes())
+	require.NoError(t, opts.Store.Close())
+
+	_, err = NewDirStore(storeDir, "me@example.com", "wrong-password", true).Load(ctx)
+	assert.ErrorIs(t, err, ErrDirStorePassword)
+}
+
+func TestDirStoreRefusesChanged(t *testing.T) {
+	storeDir := t.TempDir()
+	ctx := context.Background()
+
+	first := NewDirStore(storeDir, "me@example.com", "dir-password", true)
+	_, err := first.Load(ctx)
+	require.NoError(t, err)
+
+	tag := mustCreateTag(DotFilesTag)
+	note := createNote(".bashrc", "x")
+	tag.Content.UpsertReferences(items.ItemReferences{{UUID: note.UUID, ContentType: "Note"}})
+
+	rejected, err := first.Push(ctx, items.Items{&note, &tag})
+	require.NoError(t, err)
+	assert.Empty(t, rejected)
+
+	// both machines load the note, then the first changes it
+	second := NewDirStore(storeDir, "me@example.com", "dir-password", true)
+	_, err = second.Load(ctx)
+	require.NoError(t, err)
+
+	its, err := first.Load(ctx)
+	require.NoError(t, err)
+
+	firstNote := its.Notes()[0]
+	firstNote.Content.SetText("y")
+	rejected, err = first.Push(ctx, items.Items{&firstNote})
+	require.NoError(t, err)
+	assert.Empty(t, rejected)
+
+	note.Content.SetText("z")
+	rejected, err = second.Push(ctx, items.Items{&note})
+	require.NoError(t, err)
+	assert.Equal(t, []string{note.UUID}, rejected)
+
+	its, err = second.Load(ctx)
+	require.NoError(t, err)
+	require.Len(t, its.Notes(), 1)
+	assert.Equal(t, "y", its.Notes()[0].Content.GetText())
+}
+
+func TestDirStoreNoteFlags(t *testing.T) {
+	storeDir := t.TempDir()
+	ctx := context.Background()
+
+	store := NewDirStore(storeDir, "me@example.com", "dir-password", true)
+	_, err := store.Load(ctx)
+	require.NoError(t, err)
+
+	tag := mustCreateTag(DotFilesTag)
+	note := createNote(".bashrc", "x")
+	tag.Content.UpsertReferences(items.ItemReferences{{UUID: note.UUID, ContentType: "Note"}})
+
+	_, err = store.Push(ctx, items.Items{&note, &tag})
+	require.NoError(t, err)
+
+	// archived on another client, which keeps the flag in the note's content
+	d := store.(*dirStore)
+	ei, found, err := d.readItem(note.UUID)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	itemKey, err := items.DecryptEncryptedItemKey(ei, d.session.DefaultItemsKey.ItemsKey)
+	require.NoError(t, err)
+
+	authData := strings.Split(ei.Content, ":")[3]
+	ei.Content, err = encrypt004(`{"title": ".bashrc", "text": "x", "appData": {"org.standardnotes.sn": {"archived": true}}}`,
+		string(itemKey), authData)
+	require.NoError(t, err)
+	require.NoError(t, d.writeItem(ei))
+
+	_, err = store.Load(ctx)
+	require.NoError(t, err)
+
+	flags, err := d.noteFlags(map[string]bool{note.UUID: true})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]noteFlags{note.UUID: {archived: true}}, flags)
+}
+
+func TestDirStoreLocked(t *testing.T) {
+	storeDir := t.TempDir()
+
+	store := NewDirStore(storeDir, "me@example.com", "dir-password", true)
+	_, err := store.Load(context.Background())
+	require.NoError(t, err)
+
+	// another machine is loading from or pushing to the directory
+	lock, err := acquireLockFile(context.Background(), filepath.Join(storeDir, dirStoreLockFile), false, true)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*lockRetryInterval)
+	defer cancel()
+
+	tag := mustCreateTag(DotFilesTag)
+	note := createNote(".bashrc", "x")
+	tag.Content.UpsertReferences(items.ItemReferences{{UUID: note.UUID, ContentType: "Note"}})
+
+	_, err = store.Push(ctx, items.Items{&note, &tag})
+	assert.ErrorContains(t, err, "timed out waiting for lock")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*lockRetryInterval)
+	defer cancel()
+
+	_, err = store.Load(ctx)
+	assert.ErrorContains(t, err, "timed out waiting for lock")
+
+	// the note wasn't pushed while waiting
+	require.NoError(t, lock.Release())
+
+	its, err := store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, its.Notes())
+}
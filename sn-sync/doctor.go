@@ -0,0 +1,507 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/ryanuber/columnize"
+)
+
+const (
+	overlapping   = "overlap"
+	duplicate     = "duplicate"
+	emptyTag      = "empty tag"
+	orphanedTag   = "orphaned tag"
+	multipleTags  = "multiple tags"
+	invalidTitle  = "invalid title"
+	fixed         = "fixed"
+	notFixed      = "not fixed"
+	cannotBeFixed = "no fix"
+)
+
+type DoctorInput struct {
+	Session  *cache.Session
+	Home     string
+	PageSize int
+	// Fix applies the fix for every problem found
+	Fix bool
+	// Confirm, if set and Fix isn't, is asked whether to apply the fix for each problem
+	Confirm func(problem, fix string) bool
+	Options Options
+	Debug   bool
+}
+
+type DoctorOutput struct {
+	NoProblems, NoFixed int
+	Msg                 string
+}
+
+// problem is an inconsistency in the remote tree, with the change that fixes it
+type problem struct {
+	kind   string
+	path   string
+	reason string
+	// fix describes the change made by apply, or is empty if the problem can't be fixed automatically
+	fix   string
+	apply func(c *doctorChanges)
+}
+
+func (p problem) String() string {
+	return fmt.Sprintf("%s: %s (%s)", p.kind, p.path, p.reason)
+}
+
+// Doctor finds problems in the remote tree that stop paths being synced, fixing them if requested
+func Doctor(ctx context.Context, di DoctorInput) (do DoctorOutput, err error) {
+	r := di.Options.reporter()
+	defer func() { r.Finished(err) }()
+
+	r.PhaseStarted(loadPhase(di.Session, di.Options), 0)
+
+	store := remoteStore(di.Options, di.Session)
+
+	// overlaps are reported as problems rather than failing with checkNoteTagConflicts
+	var twn tagsWithNotes
+
+	var allItems items.Items
+
+	twn, allItems, err = getTagsWithNotes(ctx, store)
+	if err != nil {
+		return
+	}
+
+	components := getEditorComponents(allItems)
+
+	problems := diagnose(twn, di.Home)
+	do.NoProblems = len(problems)
+
+	debugPrint(di.Debug, fmt.Sprintf("Doctor | problems found: %d", len(problems)))
+
+	// clear the terminal before asking whether to fix each problem
+	r.PhaseStarted(PhaseConfirming, 0)
+
+	changes := newDoctorChanges()
+
+	var lines []string
+
+	for _, p := range problems {
+		result := yellow(notFixed)
+
+		switch {
+		case p.apply == nil:
+			result = yellow(cannotBeFixed)
+		case di.Fix, di.Confirm != nil && di.Confirm(p.String(), p.fix):
+			p.apply(changes)
+			do.NoFixed++
+
+			result = green(fixed) + " | " + p.fix
+		}
+
+		lines = append(lines, fmt.Sprintf("%s | %s | %s | %s", bold(p.path), red(p.kind), p.reason, result))
+	}
+
+	if changes.empty() {
+		if err = store.Close(); err != nil {
+			return
+		}
+
+		do.Msg = fmt.Sprint(bold("no problems found"))
+		if len(lines) > 0 {
+			do.Msg = fmt.Sprint(columnize.SimpleFormat(lines))
+		}
+
+		return
+	}
+
+	r.PhaseStarted(PhasePushing, 0)
+
+	changes.detachEditors(components)
+
+	var its items.Items
+
+	its, err = changes.items()
+	if err != nil {
+		_ = store.Close()
+
+		return
+	}
+
+	b := &batch{}
+	b.add(its...)
+
+	// sync changes back to SN
+	if _, err = pushChanges(ctx, store, b); err != nil {
+		return
+	}
+
+	do.Msg = fmt.Sprint(columnize.SimpleFormat(lines))
+
+	return do, err
+}
+
+// diagnose returns the problems found in the tree of sync tags and their notes
+func diagnose(twn tagsWithNotes, home string) (problems []problem) {
+	tags := make(map[string]items.Tag)
+
+	var valid tagsWithNotes
+
+	// tags with unusable titles are reported first and then left out of the other checks
+	for _, t := range twn {
+		title := t.tag.Content.GetTitle()
+		if title != DotFilesTag && !strings.HasPrefix(title, DotFilesTag+".") {
+			// matched by name, but not a sync tag
+			continue
+		}
+
+		if _, err := tagTitleToFSDir(title, home); err != nil {
+			tag := t.tag
+
+			problems = append(problems, problem{
+				kind:   invalidTitle,
+				path:   title,
+				reason: err.Error(),
+				fix:    "tag deleted, notes untracked",
+				apply: func(c *doctorChanges) {
+					c.deleteTag(tag)
+				},
+			})
+
+			continue
+		}
+
+		tags[title] = t.tag
+		valid = append(valid, t)
+	}
+
+	sort.Slice(valid, func(i, j int) bool {
+		return valid[i].tag.Content.GetTitle() < valid[j].tag.Content.GetTitle()
+	})
+
+	problems = append(problems, diagnoseNotes(valid, home)...)
+	problems = append(problems, diagnoseTags(valid, tags, home)...)
+
+	return problems
+}
+
+func diagnoseNotes(twn tagsWithNotes, home string) (problems []problem) {
+	// the tag each note is kept under, the first in title order
+	keptUnder := make(map[string]string)
+
+	for _, t := range twn {
+		tag := t.tag
+		tagTitle := tag.Content.GetTitle()
+
+		notes := make(items.Notes, len(t.notes))
+		copy(notes, t.notes)
+
+		// newest first, so the newest of any duplicates is kept
+		sort.SliceStable(notes, func(i, j int) bool {
+			return notes[i].UpdatedAt > notes[j].UpdatedAt
+		})
+
+		seen := make(map[string]bool)
+
+		for _, note := range notes {
+			note := note
+			path := doctorPath(tagTitle, note.Content.GetTitle(), home)
+
+			if kept, found := keptUnder[note.UUID]; found {
+				problems = append(problems, problem{
+					kind:   multipleTags,
+					path:   path,
+					reason: fmt.Sprintf("also tracked as %s", doctorPath(kept, note.Content.GetTitle(), home)),
+					fix:    "untracked from this path",
+					apply: func(c *doctorChanges) {
+						c.untrack(tag, note.UUID)
+					},
+				})
+
+				continue
+			}
+
+			keptUnder[note.UUID] = tagTitle
+
+			if err := validateNoteTitle(note.Content.GetTitle()); err != nil {
+				problems = append(problems, problem{
+					kind:   invalidTitle,
+					path:   path,
+					reason: err.Error(),
+					fix:    "untracked",
+					apply: func(c *doctorChanges) {
+						c.untrack(tag, note.UUID)
+					},
+				})
+
+				continue
+			}
+
+			if seen[note.Content.GetTitle()] {
+				problems = append(problems, problem{
+					kind:   duplicate,
+					path:   path,
+					reason: fmt.Sprintf("older copy of note %s", note.UUID),
+					fix:    "older copy untracked",
+					apply: func(c *doctorChanges) {
+						c.untrack(tag, note.UUID)
+					},
+				})
+
+				continue
+			}
+
+			seen[note.Content.GetTitle()] = true
+
+			if p := editorProblem(note, t.editors[note.UUID], t.editedWith[note.UUID]); p != "" {
+				pr := problem{
+					kind:   notPlainText,
+					path:   path,
+					reason: p,
+				}
+
+				switch {
+				case t.flags[note.UUID].String() != "":
+					pr.reason += fmt.Sprintf(", note is %s so can't be reset", t.flags[note.UUID])
+				case hasEditorMarkup(note, t.editedWith[note.UUID]):
+					pr.reason += ", use repair to restore the content from the local file"
+				default:
+					pr.fix = "reset to plain text"
+					pr.apply = func(c *doctorChanges) {
+						c.resetEditor(note)
+					}
+				}
+
+				problems = append(problems, pr)
+			}
+		}
+	}
+
+	// notes whose path is also a tag's path
+	for _, t := range twn {
+		tag := t.tag
+
+		for _, note := range t.notes {
+			noteUUID := note.UUID
+			if keptUnder[noteUUID] != tag.Content.GetTitle() {
+				continue
+			}
+
+			if _, found := getTagIfExists(noteTagPath(tag.Content.GetTitle(), note.Content.GetTitle()), twn); found {
+				problems = append(problems, problem{
+					kind:   overlapping,
+					path:   doctorPath(tag.Content.GetTitle(), note.Content.GetTitle(), home),
+					reason: "note has the same path as a tag",
+					fix:    "note untracked, tag kept",
+					apply: func(c *doctorChanges) {
+						c.untrack(tag, noteUUID)
+					},
+				})
+			}
+		}
+	}
+
+	return problems
+}
+
+func diagnoseTags(twn tagsWithNotes, tags map[string]items.Tag, home string) (problems []problem) {
+	// a tag is in use if it, or any tag below it, has notes
+	inUse := make(map[string]bool)
+
+	for _, t := range twn {
+		if len(t.notes) == 0 {
+			continue
+		}
+
+		for title := t.tag.Content.GetTitle(); ; title = parentTagTitle(title) {
+			inUse[title] = true
+
+			if title == DotFilesTag {
+				break
+			}
+		}
+	}
+
+	for _, t := range twn {
+		tag := t.tag
+		title := tag.Content.GetTitle()
+		path := doctorPath(title, "", home)
+
+		if title != DotFilesTag && !inUse[title] {
+			problems = append(problems, problem{
+				kind:   emptyTag,
+				path:   path,
+				reason: "no notes are tracked under the tag",
+				fix:    "tag deleted",
+				apply: func(c *doctorChanges) {
+					c.deleteTag(tag)
+				},
+			})
+
+			continue
+		}
+
+		var missing []string
+
+		for p := title; p != DotFilesTag; {
+			p = parentTagTitle(p)
+			if _, found := tags[p]; !found {
+				missing = append([]string{p}, missing...)
+			}
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		problems = append(problems, problem{
+			kind:   orphanedTag,
+			path:   path,
+			reason: fmt.Sprintf("missing parent tags: %s", strings.Join(missing, ", ")),
+			fix:    "parent tags created",
+			apply: func(c *doctorChanges) {
+				c.createTags(missing)
+			},
+		})
+	}
+
+	return problems
+}
+
+// parentTagTitle returns the title of the tag above, where hidden directories below the
+// first level are separated by two periods
+func parentTagTitle(title string) string {
+	if i := strings.LastIndex(title, "."); i > 0 {
+		if p := strings.TrimRight(title[:i], "."); p != "" {
+			return p
+		}
+	}
+
+	return DotFilesTag
+}
+
+// noteTagPath returns the title a tag for the note's path would have, as in checkNoteTagConflicts
+func noteTagPath(tagTitle, noteTitle string) string {
+	if tagTitle == DotFilesTag {
+		return tagTitle + noteTitle
+	}
+
+	return tagTitle + "." + noteTitle
+}
+
+// doctorPath returns the home relative path of a tag's directory or note, falling back to the
+// remote titles if they don't form a valid path
+func doctorPath(tagTitle, noteTitle, home string) string {
+	dir, err := tagTitleToFSDir(tagTitle, home)
+	if err != nil {
+		return strings.TrimSuffix(tagTitle+"/"+noteTitle, "/")
+	}
+
+	rel := stripHome(dir+noteTitle, home)
+	if rel == "" {
+		return "~"
+	}
+
+	return rel
+}
+
+// doctorChanges collects the items changed by fixes, so fixes touching the same item are combined
+type doctorChanges struct {
+	tags    map[string]*items.Tag
+	notes   map[string]*items.Note
+	reset   []string
+	created []string
+	// components changed to stop opening reset notes
+	components items.Components
+}
+
+func newDoctorChanges() *doctorChanges {
+	return &doctorChanges{
+		tags:  make(map[string]*items.Tag),
+		notes: make(map[string]*items.Note),
+	}
+}
+
+func (c *doctorChanges) empty() bool {
+	return len(c.tags) == 0 && len(c.notes) == 0 && len(c.created) == 0
+}
+
+func (c *doctorChanges) tag(t items.Tag) *items.Tag {
+	if ct, found := c.tags[t.UUID]; found {
+		return ct
+	}
+
+	ct := t
+	ct.Content = t.Content.Copy()
+	c.tags[t.UUID] = &ct
+
+	return &ct
+}
+
+// untrack removes the note from the tag, leaving the note in Standard Notes
+func (c *doctorChanges) untrack(t items.Tag, noteUUID string) {
+	ct := c.tag(t)
+
+	var refs items.ItemReferences
+
+	for _, ref := range ct.Content.References() {
+		if ref.UUID != noteUUID {
+			refs = append(refs, ref)
+		}
+	}
+
+	ct.Content.SetReferences(refs)
+}
+
+// deleteTag deletes the tag, leaving its notes in Standard Notes
+func (c *doctorChanges) deleteTag(t items.Tag) {
+	c.tag(t).SetDeleted(true)
+}
+
+func (c *doctorChanges) resetEditor(n items.Note) {
+	resetEditor(&n)
+	c.notes[n.UUID] = &n
+	c.reset = append(c.reset, n.UUID)
+}
+
+func (c *doctorChanges) createTags(titles []string) {
+	for _, t := range titles {
+		if !StringInSlice(t, c.created, true) {
+			c.created = append(c.created, t)
+		}
+	}
+}
+
+func (c *doctorChanges) detachEditors(components items.Components) {
+	c.components = detachEditors(components, c.reset)
+}
+
+func (c *doctorChanges) items() (its items.Items, err error) {
+	for _, t := range c.tags {
+		its = append(its, t)
+	}
+
+	for _, n := range c.notes {
+		its = append(its, n)
+	}
+
+	sort.Strings(c.created)
+
+	for _, title := range c.created {
+		var nt items.Tag
+
+		nt, err = createTag(title)
+		if err != nil {
+			return nil, err
+		}
+
+		its = append(its, &nt)
+	}
+
+	for i := range c.components {
+		its = append(its, &c.components[i])
+	}
+
+	return its, nil
+}
@@ -0,0 +1,124 @@
+package snsync
+
+import (
+	"testing"
+
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tagWithNotesFor(title string, notes ...items.Note) tagWithNotes {
+	var refs items.ItemReferences
+	for _, n := range notes {
+		refs = append(refs, items.ItemReference{UUID: n.UUID, ContentType: "Note"})
+	}
+
+	tag, _ := items.NewTag(title, refs)
+
+	return tagWithNotes{tag: tag, notes: notes}
+}
+
+func problemKinds(problems []problem) map[string]string {
+	kinds := make(map[string]string)
+	for _, p := range problems {
+		kinds[p.path] = p.kind
+	}
+
+	return kinds
+}
+
+func TestDiagnoseHealthyTree(t *testing.T) {
+	home := getTemporaryHome()
+
+	twn := tagsWithNotes{
+		tagWithNotesFor(DotFilesTag, createNote(".bashrc", "x")),
+		tagWithNotesFor("sync.config.nvim", createNote("init.vim", "y")),
+		tagWithNotesFor("sync.config"),
+	}
+
+	assert.Empty(t, diagnose(twn, home))
+}
+
+func TestDiagnose(t *testing.T) {
+	home := getTemporaryHome()
+
+	older := createNote(".bashrc", "old")
+	older.UpdatedAt = "2023-01-01T00:00:00.000Z"
+	newer := createNote(".bashrc", "new")
+	newer.UpdatedAt = "2024-01-01T00:00:00.000Z"
+
+	shared := createNote("shared", "s")
+	overlap := createNote(".vim", "o")
+	invalid := createNote("../escape", "e")
+	rich := createNote(".zshrc", "z")
+	rich.Content.NoteType = "rich-text"
+
+	twn := tagsWithNotes{
+		tagWithNotesFor(DotFilesTag, older, newer, overlap, invalid, rich),
+		tagWithNotesFor("sync.a", shared),
+		tagWithNotesFor("sync.b", shared),
+		tagWithNotesFor("sync.vim", createNote("vimrc", "v")),
+		tagWithNotesFor("sync.empty"),
+		tagWithNotesFor("sync.orphan.child", createNote("file", "f")),
+		tagWithNotesFor("syncthing", createNote("unrelated", "u")),
+	}
+
+	problems := diagnose(twn, home)
+
+	assert.Equal(t, map[string]string{
+		"../escape":      invalidTitle,
+		".bashrc":        duplicate,
+		".zshrc":         notPlainText,
+		".b/shared":      multipleTags,
+		".vim":           overlapping,
+		".empty/":        emptyTag,
+		".orphan/child/": orphanedTag,
+	}, problemKinds(problems))
+
+	changes := newDoctorChanges()
+	for _, p := range problems {
+		require.NotNil(t, p.apply, p.String())
+		p.apply(changes)
+	}
+
+	root := changes.tags[twn[0].tag.UUID]
+	require.NotNil(t, root)
+
+	var remaining []string
+	for _, ref := range root.Content.References() {
+		remaining = append(remaining, ref.UUID)
+	}
+
+	// the newest duplicate and the reset note stay tracked
+	assert.ElementsMatch(t, []string{newer.UUID, rich.UUID}, remaining)
+	assert.Equal(t, plainTextNoteType, changes.notes[rich.UUID].Content.NoteType)
+	assert.Empty(t, changes.tags[twn[2].tag.UUID].Content.References())
+	assert.True(t, changes.tags[twn[4].tag.UUID].IsDeleted())
+	assert.Equal(t, []string{"sync.orphan"}, changes.created)
+
+	// the original tree is left unchanged
+	assert.Len(t, twn[0].tag.Content.References(), 5)
+}
+
+func TestDiagnoseNotPlainTextWithFlags(t *testing.T) {
+	home := getTemporaryHome()
+
+	note := createNote(".bashrc", "x")
+	note.Content.NoteType = "rich-text"
+
+	twn := tagsWithNotes{tagWithNotesFor(DotFilesTag, note)}
+	twn[0].flags = map[string]noteFlags{note.UUID: {locked: true}}
+
+	problems := diagnose(twn, home)
+	require.Len(t, problems, 1)
+	assert.Nil(t, problems[0].apply)
+	assert.Contains(t, problems[0].reason, "note is locked")
+}
+
+func TestParentTagTitle(t *testing.T) {
+	assert.Equal(t, DotFilesTag, parentTagTitle(DotFilesTag))
+	assert.Equal(t, DotFilesTag, parentTagTitle("sync.config"))
+	assert.Equal(t, "sync.config", parentTagTitle("sync.config.nvim"))
+	assert.Equal(t, "sync.config", parentTagTitle("sync.config..hidden"))
+}
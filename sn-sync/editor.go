@@ -0,0 +1,245 @@
+package snsync
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/jonhadfield/gosn-v2/items"
+)
+
+const (
+	// editorArea is the area of components that replace the note editor
+	editorArea = "editor-editor"
+	// plainTextNoteType is the type of notes edited with the plain text editor
+	plainTextNoteType = "plain-text"
+	// plainTextEditorIdentifier is the identifier of the plain text editor, also used as a note type by some clients
+	plainTextEditorIdentifier = "com.standardnotes.plain-text"
+)
+
+const (
+	// codeNoteType is the type of notes edited with a code editor
+	codeNoteType = "code"
+	// CodeEditorIdentifier is the identifier of the Standard Notes code editor
+	CodeEditorIdentifier = "org.standardnotes.code-editor"
+)
+
+// byteIdenticalEditors are the editor components known to save text exactly as it's entered,
+// so tracked notes can safely be opened with them
+var byteIdenticalEditors = map[string]bool{
+	CodeEditorIdentifier: true,
+}
+
+func isPlainText(identifier string) bool {
+	return identifier == "" || identifier == plainTextNoteType || identifier == plainTextEditorIdentifier
+}
+
+// EditorSetting chooses the editor component, and its language mode, that a tracked note opens with in Standard Notes
+type EditorSetting struct {
+	// Editor is the identifier or name of an installed editor component
+	Editor string `mapstructure:"editor"`
+	// Mode is the editor's language mode, e.g. "shell" or "yaml"
+	Mode string `mapstructure:"mode"`
+}
+
+func componentIdentifier(c items.Component) string {
+	if c.Content.Identifier != "" {
+		return c.Content.Identifier
+	}
+
+	if info, ok := c.Content.PackageInfo.(map[string]interface{}); ok {
+		id, _ := info["identifier"].(string)
+
+		return id
+	}
+
+	return ""
+}
+
+// editorFor returns the editor setting for the first glob, in sorted order, matching the home relative path or file name
+func editorFor(homeRelPath string, editors map[string]EditorSetting) (setting EditorSetting, found bool) {
+	var globs []string
+	for g := range editors {
+		globs = append(globs, g)
+	}
+
+	sort.Strings(globs)
+
+	for _, g := range globs {
+		if matchesPathGlobs(homeRelPath, []string{g}) {
+			return editors[g], true
+		}
+	}
+
+	return
+}
+
+// applyEditor sets a note to open with an installed editor component in the given language mode.
+// Only editors that keep text byte identical are allowed, so content round trips unchanged.
+func applyEditor(note *items.Note, setting EditorSetting, components items.Components) error {
+	for _, c := range components {
+		if c.IsDeleted() || c.Content.Area != editorArea {
+			continue
+		}
+
+		id := componentIdentifier(c)
+		if id != setting.Editor && c.Content.Name != setting.Editor {
+			continue
+		}
+
+		if !byteIdenticalEditors[id] {
+			return fmt.Errorf("editor %s may change the text of notes so can't be used with tracked files", setting.Editor)
+		}
+
+		note.Content.SetPrefersPlainEditor(false)
+		note.Content.EditorIdentifier = id
+		note.Content.NoteType = codeNoteType
+
+		// copy the component data so the original note is left unchanged
+		appData := note.Content.GetAppData()
+		componentData := items.OrgStandardNotesSNComponentsDetail{}
+
+		for k, v := range appData.OrgStandardNotesSNComponents {
+			componentData[k] = v
+		}
+
+		if setting.Mode != "" {
+			componentData[c.UUID] = map[string]interface{}{"mode": setting.Mode}
+		}
+
+		appData.OrgStandardNotesSNComponents = componentData
+
+		note.Content.SetAppData(appData)
+
+		return nil
+	}
+
+	return fmt.Errorf("editor %s is not installed", setting.Editor)
+}
+
+// editorMarkupRegex matches content rewritten by the rich text, markdown or super editors
+var editorMarkupRegex = regexp.MustCompile(`^\s*(<(p|div|h[1-6]|ul|ol|pre|blockquote)[\s>]|\{"root":\{"children":)`)
+
+// hasEditorMarkup returns true if a note edited with a rich text editor has had its content rewritten as markup.
+// Content of other notes, such as html files, is never treated as markup.
+func hasEditorMarkup(note items.Note, editedWith string) bool {
+	return editedWith != "" && editorMarkupRegex.MatchString(note.Content.GetText())
+}
+
+func isDefaultEditor(c items.Component) bool {
+	data, ok := c.Content.ComponentData.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	d, _ := data["defaultEditor"].(bool)
+
+	return d
+}
+
+// associatedEditors returns the name of the editor component each note will be opened with,
+// either because it's been associated with the note, or it's the default editor and the note
+// doesn't prefer the plain text editor
+func associatedEditors(components items.Components, notes items.Notes) map[string]string {
+	editors := make(map[string]string)
+
+	for _, c := range components {
+		if c.Content.Area != editorArea || c.IsDeleted() || byteIdenticalEditors[componentIdentifier(c)] {
+			continue
+		}
+
+		for _, n := range notes {
+			switch {
+			case StringInSlice(n.UUID, c.Content.AssociatedItemIds, true):
+				editors[n.UUID] = c.Content.Name
+			case isDefaultEditor(c) && !n.Content.GetPrefersPlainEditor() && !StringInSlice(n.UUID, c.Content.DissociatedItemIds, true):
+				if _, found := editors[n.UUID]; !found {
+					editors[n.UUID] = c.Content.Name
+				}
+			}
+		}
+	}
+
+	return editors
+}
+
+// richTextEditors returns the name of an editor component, other than those keeping text byte identical,
+// named in the app data of each note, as editors save their data for a note there once it's been opened with them
+func richTextEditors(components items.Components, notes items.Notes) map[string]string {
+	editors := make(map[string]string)
+
+	for _, c := range components {
+		if c.Content.Area != editorArea || byteIdenticalEditors[componentIdentifier(c)] {
+			continue
+		}
+
+		for _, n := range notes {
+			if _, found := n.Content.GetAppData().OrgStandardNotesSNComponents[c.UUID]; found {
+				editors[n.UUID] = c.Content.Name
+			}
+		}
+	}
+
+	return editors
+}
+
+// editorProblem returns a description of why a note isn't safe to write to a file, as it's set to
+// open in an editor other than plain text or its content has been rewritten by the rich text editor
+// named in its app data
+func editorProblem(note items.Note, associatedEditor, editedWith string) string {
+	switch {
+	case associatedEditor != "":
+		return fmt.Sprintf("opens with the %s editor", associatedEditor)
+	case byteIdenticalEditors[note.Content.EditorIdentifier] && note.Content.NoteType == codeNoteType:
+		// safe, as long as the content hasn't been rewritten
+	case !isPlainText(note.Content.NoteType):
+		return fmt.Sprintf("note type is %s", note.Content.NoteType)
+	case !isPlainText(note.Content.EditorIdentifier):
+		return fmt.Sprintf("opens with the %s editor", note.Content.EditorIdentifier)
+	}
+
+	if hasEditorMarkup(note, editedWith) {
+		return fmt.Sprintf("content contains %s editor markup", editedWith)
+	}
+
+	return ""
+}
+
+// resetEditor sets a note to open with the plain text editor
+func resetEditor(note *items.Note) {
+	note.Content.SetPrefersPlainEditor(true)
+	note.Content.EditorIdentifier = ""
+	note.Content.NoteType = plainTextNoteType
+}
+
+// detachEditors stops the editor components from opening the notes with the given uuids,
+// returning the components changed
+func detachEditors(components items.Components, uuids []string) (changed items.Components) {
+	for _, c := range components {
+		if c.Content.Area != editorArea || c.IsDeleted() {
+			continue
+		}
+
+		var updated bool
+
+		for _, uuid := range uuids {
+			if StringInSlice(uuid, c.Content.AssociatedItemIds, true) {
+				c.Content.DisassociateItems([]string{uuid})
+
+				updated = true
+			}
+
+			if isDefaultEditor(c) && !StringInSlice(uuid, c.Content.DissociatedItemIds, true) {
+				c.Content.DissociatedItemIds = append(c.Content.DissociatedItemIds, uuid)
+
+				updated = true
+			}
+		}
+
+		if updated {
+			changed = append(changed, c)
+		}
+	}
+
+	return changed
+}
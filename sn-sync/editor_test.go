@@ -0,0 +1,206 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssociatedEditors(t *testing.T) {
+	plain := createNote(".bashrc", "x")
+	plain.Content.SetPrefersPlainEditor(true)
+
+	other := createNote(".vimrc", "y")
+	associated := createNote(".zshrc", "z")
+
+	components := items.Components{
+		{Content: items.ComponentContent{Area: editorArea, Name: "Markdown", AssociatedItemIds: []string{associated.UUID}}},
+		{Content: items.ComponentContent{Area: editorArea, Name: "Rich Text", ComponentData: map[string]interface{}{"defaultEditor": true}}},
+		{Content: items.ComponentContent{Area: "themes", Name: "Dark", AssociatedItemIds: []string{plain.UUID}}},
+	}
+
+	editors := associatedEditors(components, items.Notes{plain, other, associated})
+	assert.Equal(t, map[string]string{other.UUID: "Rich Text", associated.UUID: "Markdown"}, editors)
+}
+
+func TestRichTextEditors(t *testing.T) {
+	rich := items.Component{Content: items.ComponentContent{Area: editorArea, Name: "Rich Text"}}
+	rich.UUID = "rich-uuid"
+	code := items.Component{Content: items.ComponentContent{Area: editorArea, Name: "Code Editor", Identifier: CodeEditorIdentifier}}
+	code.UUID = "code-uuid"
+
+	opened := createNote(".bashrc", "x")
+	appData := opened.Content.GetAppData()
+	appData.OrgStandardNotesSNComponents = items.OrgStandardNotesSNComponentsDetail{rich.UUID: map[string]interface{}{}}
+	opened.Content.SetAppData(appData)
+
+	coded := createNote(".vimrc", "y")
+	require.NoError(t, applyEditor(&coded, EditorSetting{Editor: "Code Editor", Mode: "vim"}, items.Components{code}))
+
+	plain := createNote(".zshrc", "z")
+
+	editors := richTextEditors(items.Components{rich, code}, items.Notes{opened, coded, plain})
+	assert.Equal(t, map[string]string{opened.UUID: "Rich Text"}, editors)
+}
+
+func TestEditorProblem(t *testing.T) {
+	note := createNote(".bashrc", "alias ll='ls -l'")
+	assert.Empty(t, editorProblem(note, "", ""))
+	assert.Equal(t, "opens with the Markdown editor", editorProblem(note, "Markdown", ""))
+
+	note.Content.NoteType = "rich-text"
+	assert.Equal(t, "note type is rich-text", editorProblem(note, "", ""))
+
+	note = createNote(".bashrc", "<p>alias ll='ls -l'</p>")
+	assert.Equal(t, "content contains Rich Text editor markup", editorProblem(note, "", "Rich Text"))
+
+	// html that's never been opened with a rich text editor is just content
+	page := createNote("index.html", "<div>\n<p>hello</p>\n</div>\n")
+	assert.Empty(t, editorProblem(page, "", ""))
+
+	resetEditor(&note)
+	assert.True(t, note.Content.GetPrefersPlainEditor())
+	assert.Equal(t, plainTextNoteType, note.Content.NoteType)
+}
+
+func TestDetachEditors(t *testing.T) {
+	components := items.Components{
+		{Content: items.ComponentContent{Area: editorArea, Name: "Markdown", AssociatedItemIds: []string{"a", "b"}}},
+		{Content: items.ComponentContent{Area: editorArea, Name: "Rich Text", ComponentData: map[string]interface{}{"defaultEditor": true}}},
+		{Content: items.ComponentContent{Area: editorArea, Name: "Unrelated", AssociatedItemIds: []string{"c"}}},
+	}
+
+	changed := detachEditors(components, []string{"a"})
+	require.Len(t, changed, 2)
+	assert.Equal(t, []string{"b"}, changed[0].Content.AssociatedItemIds)
+	assert.Equal(t, []string{"a"}, changed[1].Content.DissociatedItemIds)
+}
+
+func TestRepairNotPlainText(t *testing.T) {
+	home := getTemporaryHome()
+	bashrcPath := fmt.Sprintf("%s/.bashrc", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{bashrcPath: "alias ll='ls -l'\n"}))
+
+	defer os.RemoveAll(home)
+
+	tag, err := items.NewTag(DotFilesTag, nil)
+	require.NoError(t, err)
+
+	damaged := createNote(".bashrc", "<p>alias ll='ls -l'</p>")
+	damaged.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	locked := createNote(".vimrc", "set nu")
+	remote := tagsWithNotes{{
+		tag:        tag,
+		notes:      items.Notes{damaged, locked},
+		flags:      map[string]noteFlags{locked.UUID: {locked: true}},
+		editors:    map[string]string{locked.UUID: "Markdown"},
+		editedWith: map[string]string{damaged.UUID: "Rich Text"},
+	}}
+
+	diffs, err := compare(context.Background(), remote, home, nil, nil, Options{}, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+	assert.Equal(t, notPlainText, diffs[0].diff)
+	assert.Equal(t, notPlainText, diffs[1].diff)
+
+	toRepair, results, noSkipped := repairItems(diffs)
+	require.Len(t, toRepair, 1)
+	assert.Len(t, results, 2)
+	assert.Equal(t, 1, noSkipped)
+	assert.Equal(t, "alias ll='ls -l'\n", toRepair[0].remote.Content.GetText())
+	assert.True(t, toRepair[0].remote.Content.GetPrefersPlainEditor())
+}
+
+func TestRepairKeepsNewerLocal(t *testing.T) {
+	home := getTemporaryHome()
+	bashrcPath := fmt.Sprintf("%s/.bashrc", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{bashrcPath: "alias ll='ls -la'\n"}))
+
+	defer os.RemoveAll(home)
+
+	tag, err := items.NewTag(DotFilesTag, nil)
+	require.NoError(t, err)
+
+	// opened in another editor before the local file was edited
+	note := createNote(".bashrc", "alias ll='ls -l'\n")
+	note.UpdatedAt = time.Now().UTC().Add(-time.Hour).Format("2006-01-02T15:04:05.000Z")
+	note.Content.NoteType = "markdown"
+
+	diffs, err := compare(context.Background(), tagsWithNotes{{tag: tag, notes: items.Notes{note}}}, home, nil, nil, Options{}, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, notPlainText, diffs[0].diff)
+
+	toRepair, results, _ := repairItems(diffs)
+	require.Len(t, toRepair, 1)
+	assert.Equal(t, "alias ll='ls -la'\n", toRepair[0].remote.Content.GetText())
+	assert.Contains(t, results[0], "newer local file")
+}
+
+func TestApplyEditor(t *testing.T) {
+	code := items.Component{Content: items.ComponentContent{Area: editorArea, Name: "Code Editor", Identifier: CodeEditorIdentifier}}
+	code.UUID = "code-uuid"
+	rich := items.Component{Content: items.ComponentContent{Area: editorArea, Name: "Rich Text", Identifier: "org.standardnotes.rich-text-editor"}}
+	components := items.Components{code, rich}
+
+	note := createNote(".bashrc", "alias ll='ls -l'")
+	require.NoError(t, applyEditor(&note, EditorSetting{Editor: "Code Editor", Mode: "shell"}, components))
+	assert.False(t, note.Content.GetPrefersPlainEditor())
+	assert.Equal(t, CodeEditorIdentifier, note.Content.EditorIdentifier)
+	assert.Equal(t, map[string]interface{}{"mode": "shell"}, note.Content.GetAppData().OrgStandardNotesSNComponents["code-uuid"])
+
+	// notes in byte identical editors are still synced
+	assert.Empty(t, editorProblem(note, "", ""))
+	assert.Empty(t, associatedEditors(items.Components{code}, items.Notes{note}))
+
+	assert.Error(t, applyEditor(&note, EditorSetting{Editor: "org.standardnotes.rich-text-editor"}, components))
+	assert.Error(t, applyEditor(&note, EditorSetting{Editor: "Missing"}, components))
+}
+
+func TestEditorFor(t *testing.T) {
+	editors := map[string]EditorSetting{
+		"*.yaml":  {Editor: CodeEditorIdentifier, Mode: "yaml"},
+		".bashrc": {Editor: CodeEditorIdentifier, Mode: "shell"},
+	}
+
+	setting, found := editorFor(".config/app/config.yaml", editors)
+	assert.True(t, found)
+	assert.Equal(t, "yaml", setting.Mode)
+
+	setting, found = editorFor(".bashrc", editors)
+	assert.True(t, found)
+	assert.Equal(t, "shell", setting.Mode)
+
+	_, found = editorFor(".vimrc", editors)
+	assert.False(t, found)
+}
+
+func TestSetEditorItems(t *testing.T) {
+	code := items.Component{Content: items.ComponentContent{Area: editorArea, Name: "Code Editor", Identifier: CodeEditorIdentifier}}
+	opts := Options{
+		Editors:          map[string]EditorSetting{"*": {Editor: CodeEditorIdentifier, Mode: "shell"}},
+		editorComponents: items.Components{code},
+	}
+
+	diffs := []ItemDiff{
+		{homeRelPath: ".bashrc", diff: identical, remote: createNote(".bashrc", "x")},
+		{homeRelPath: ".vimrc", diff: identical, remote: createNote(".vimrc", "y"), flags: noteFlags{locked: true}},
+		{homeRelPath: ".zshrc", diff: localNewer, remote: createNote(".zshrc", "z")},
+	}
+
+	toUpdate, results, noSkipped := setEditorItems(diffs, opts)
+	require.Len(t, toUpdate, 1)
+	assert.Len(t, results, 3)
+	assert.Equal(t, 2, noSkipped)
+	assert.Equal(t, CodeEditorIdentifier, toUpdate[0].remote.Content.EditorIdentifier)
+
+	// already set so nothing to update
+	toUpdate, _, _ = setEditorItems([]ItemDiff{toUpdate[0]}, opts)
+	assert.Empty(t, toUpdate)
+}
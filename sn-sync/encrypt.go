@@ -0,0 +1,128 @@
+package snsync
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// EncryptedPrefix marks note text encrypted with the encryption passphrase
+const EncryptedPrefix = "sn-sync:enc:v1:"
+
+const (
+	encryptionSaltLen = 16
+	encryptionKeyLen  = 32
+	scryptN           = 1 << 15
+	scryptR           = 8
+	scryptP           = 1
+)
+
+var errPassphraseRequired = errors.New("encryption passphrase required")
+
+// EncryptionOptions defines which paths have their content encrypted, in addition to the
+// account's encryption, before being pushed
+type EncryptionOptions struct {
+	// Paths lists globs of home relative paths, or file names, to encrypt
+	Paths []string
+	// Passphrase, or the content of a keyfile, used to derive the encryption key
+	Passphrase []byte
+}
+
+func (e EncryptionOptions) encrypts(homeRelPath string) bool {
+	return matchesPathGlobs(homeRelPath, e.Paths)
+}
+
+func isEncrypted(text string) bool {
+	return strings.HasPrefix(text, EncryptedPrefix)
+}
+
+func deriveKey(passphrase, salt []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errPassphraseRequired
+	}
+
+	return scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, encryptionKeyLen)
+}
+
+// encryptText encrypts text with AES-256-GCM using a key derived from the passphrase
+// and a random salt, both of which are stored with the ciphertext
+func encryptText(text string, passphrase []byte) (string, error) {
+	salt := make([]byte, encryptionSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	payload := append(append(salt, nonce...), gcm.Seal(nil, nonce, []byte(text), nil)...)
+
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// decryptText reverses encryptText. Text without the encrypted prefix is returned as is.
+func decryptText(text string, passphrase []byte) (string, error) {
+	if !isEncrypted(text) {
+		return text, nil
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(text, EncryptedPrefix)))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted content: %w", err)
+	}
+
+	if len(payload) < encryptionSaltLen {
+		return "", errors.New("invalid encrypted content: too short")
+	}
+
+	key, err := deriveKey(passphrase, payload[:encryptionSaltLen])
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	payload = payload[encryptionSaltLen:]
+	if len(payload) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted content: too short")
+	}
+
+	plain, err := gcm.Open(nil, payload[:gcm.NonceSize()], payload[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt: wrong passphrase or corrupted content")
+	}
+
+	return string(plain), nil
+}
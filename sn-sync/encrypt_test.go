@@ -0,0 +1,81 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptText(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+
+	enc, err := encryptText("Host *\n  IdentityFile ~/.ssh/id_ed25519\n", passphrase)
+	require.NoError(t, err)
+	assert.True(t, isEncrypted(enc))
+	assert.NotContains(t, enc, "IdentityFile")
+
+	dec, err := decryptText(enc, passphrase)
+	require.NoError(t, err)
+	assert.Equal(t, "Host *\n  IdentityFile ~/.ssh/id_ed25519\n", dec)
+
+	_, err = decryptText(enc, []byte("wrong"))
+	assert.Error(t, err)
+
+	_, err = decryptText(enc, nil)
+	assert.ErrorIs(t, err, errPassphraseRequired)
+
+	// plaintext is returned as is
+	dec, err = decryptText("plain", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", dec)
+}
+
+func TestCompareDecryptsInMemory(t *testing.T) {
+	home := getTemporaryHome()
+	sshConfigPath := fmt.Sprintf("%s/.ssh/config", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{sshConfigPath: "Host *\n"}))
+
+	defer os.RemoveAll(home)
+
+	opts := Options{Encryption: EncryptionOptions{Paths: []string{".ssh/*"}, Passphrase: []byte("secret")}}
+
+	// createItem encrypts configured paths
+	note, err := createItem(sshConfigPath, ".ssh/config", "config", opts)
+	require.NoError(t, err)
+	assert.True(t, isEncrypted(note.Content.GetText()))
+	assert.NoError(t, checkForSecrets(".ssh/config", note.Content.GetText(), opts))
+
+	tag, err := items.NewTag(DotFilesTag+".ssh", nil)
+	require.NoError(t, err)
+
+	remote := tagsWithNotes{{tag: tag, notes: items.Notes{note}}}
+
+	diffs, _, err := compareRemoteWithLocalFS(context.Background(), remote, nil, home, opts, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, identical, diffs[0].diff)
+	assert.True(t, diffs[0].encrypted)
+	assert.Equal(t, "Host *\n", diffs[0].remote.Content.GetText())
+	// the remote note itself is unchanged
+	assert.True(t, isEncrypted(remote[0].notes[0].Content.GetText()))
+
+	// without the passphrase the item can't be compared
+	diffs, _, err = compareRemoteWithLocalFS(context.Background(), remote, nil, home, Options{}, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, undecryptable, diffs[0].diff)
+
+	// pulling writes the plaintext
+	require.NoError(t, os.Remove(sshConfigPath))
+	_, err = createLocal(context.Background(), []ItemDiff{{path: sshConfigPath, remote: note}}, home, opts)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(sshConfigPath)
+	require.NoError(t, err)
+	assert.Equal(t, "Host *\n", string(content))
+}
@@ -0,0 +1,46 @@
+package snsync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNoRemoteItems is returned when no sync tags or notes are found in Standard Notes
+	ErrNoRemoteItems = errors.New("no remote sync found")
+	// ErrInvalidSession is returned when the session is missing or can't be used
+	ErrInvalidSession = errors.New("invalid session")
+	// ErrConflict is returned when items changed both locally and elsewhere couldn't be synced,
+	// and are left for the user to resolve
+	ErrConflict = errors.New("conflict")
+	// ErrOffline is returned when the Standard Notes server can't be reached, or the cache db needed to work
+	// offline is missing
+	ErrOffline = errors.New("offline")
+)
+
+// ErrPathConflict is returned when tracked notes and tags map to the same local path
+type ErrPathConflict struct {
+	// Overlaps are the titles of the overlapping notes and tags
+	Overlaps []string
+}
+
+func (e *ErrPathConflict) Error() string {
+	return fmt.Sprintf("the following notes and tags are overlapping, use doctor to fix:\n- %s", strings.Join(e.Overlaps, "\n- "))
+}
+
+// ErrPathInvalid is returned when a local path can't be tracked or synced
+type ErrPathInvalid struct {
+	Path   string
+	Reason string
+	// err is the cause, such as errUnsafePath for a remote title resolving outside home
+	err error
+}
+
+func (e *ErrPathInvalid) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Path)
+}
+
+func (e *ErrPathInvalid) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,54 @@
+package snsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrPathConflict(t *testing.T) {
+	twn := tagsWithNotes{
+		tagWithNotesFor("sync.vim"),
+		tagWithNotesFor("sync.nvim"),
+		tagWithNotesFor(DotFilesTag, createNote(".vim", "v"), createNote(".nvim", "n")),
+	}
+
+	err := checkNoteTagConflicts(twn)
+
+	var pathConflict *ErrPathConflict
+	require.True(t, errors.As(err, &pathConflict))
+	assert.Equal(t, []string{"sync.nvim", "sync.vim"}, pathConflict.Overlaps)
+	assert.Contains(t, err.Error(), "use doctor to fix")
+}
+
+func TestErrPathInvalid(t *testing.T) {
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	require.NoError(t, os.MkdirAll(home, 0o700))
+
+	link := filepath.Join(home, ".link")
+	require.NoError(t, os.Symlink(home, link))
+
+	_, err := pathValid(link)
+
+	var pathInvalid *ErrPathInvalid
+	require.True(t, errors.As(err, &pathInvalid))
+	assert.Equal(t, link, pathInvalid.Path)
+	assert.Equal(t, "symlink not supported", pathInvalid.Reason)
+	assert.Equal(t, "symlink not supported: "+link, err.Error())
+}
+
+func TestSentinelErrors(t *testing.T) {
+	_, err := compare(context.Background(), tagsWithNotes{}, getTemporaryHome(), nil, nil, Options{}, true)
+	assert.ErrorIs(t, err, ErrNoRemoteItems)
+
+	_, err = Add(context.Background(), AddInput{Session: &cache.Session{}, Home: getTemporaryHome(), Paths: []string{".bashrc"}})
+	assert.ErrorIs(t, err, ErrInvalidSession)
+}
@@ -0,0 +1,143 @@
+package snsync
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/jonhadfield/gosn-v2/session"
+)
+
+const (
+	paused  = "paused"
+	skipped = "skipped"
+	refused = "refused"
+)
+
+// noteFlags are the Standard Notes note options used to steer syncing from any client:
+// archived pauses syncing of the path, locked makes it pull only and protected notes are
+// only pulled when requested
+type noteFlags struct {
+	archived  bool
+	locked    bool
+	protected bool
+}
+
+func (f noteFlags) String() string {
+	var s []string
+
+	if f.archived {
+		s = append(s, "archived")
+	}
+
+	if f.locked {
+		s = append(s, "locked")
+	}
+
+	if f.protected {
+		s = append(s, "protected")
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// parseNoteFlags reads the flags from decrypted note content, as they aren't
+// kept when content is parsed into a note
+func parseNoteFlags(content string) (flags noteFlags, err error) {
+	var c struct {
+		Protected bool `json:"protected"`
+		AppData   struct {
+			SN struct {
+				Archived bool `json:"archived"`
+				Locked   bool `json:"locked"`
+			} `json:"org.standardnotes.sn"`
+		} `json:"appData"`
+	}
+
+	if err = json.Unmarshal([]byte(content), &c); err != nil {
+		return
+	}
+
+	return noteFlags{
+		archived:  c.AppData.SN.Archived,
+		locked:    c.AppData.SN.Locked,
+		protected: c.Protected,
+	}, nil
+}
+
+// getNoteFlags decrypts the cached notes with the given uuids to read their flags
+func getNoteFlags(session *cache.Session, cached cache.Items, uuids map[string]bool) (flags map[string]noteFlags, err error) {
+	var eItems items.EncryptedItems
+
+	for _, ci := range cached {
+		if ci.Deleted || ci.ContentType != "Note" || !uuids[ci.UUID] {
+			continue
+		}
+
+		eItems = append(eItems, items.EncryptedItem{
+			UUID:        ci.UUID,
+			Content:     ci.Content,
+			ContentType: ci.ContentType,
+			ItemsKeyID:  ci.ItemsKeyID,
+			EncItemKey:  ci.EncItemKey,
+			CreatedAt:   ci.CreatedAt,
+			UpdatedAt:   ci.UpdatedAt,
+		})
+	}
+
+	return decryptNoteFlags(session.Session, eItems)
+}
+
+// decryptNoteFlags decrypts the notes to read their flags
+func decryptNoteFlags(s *session.Session, eItems items.EncryptedItems) (flags map[string]noteFlags, err error) {
+	flags = make(map[string]noteFlags)
+
+	if len(eItems) == 0 {
+		return
+	}
+
+	var dItems items.DecryptedItems
+
+	dItems, err = items.DecryptItems(s, eItems, s.ItemsKeys)
+	if err != nil {
+		return
+	}
+
+	for _, di := range dItems {
+		var f noteFlags
+
+		f, err = parseNoteFlags(di.Content)
+		if err != nil {
+			return
+		}
+
+		flags[di.UUID] = f
+	}
+
+	return flags, err
+}
+
+// checkNoteFlags returns a status and reason if the note's flags stop the item being synced
+func checkNoteFlags(itemDiff ItemDiff, opts Options) (status, reason string) {
+	switch {
+	case itemDiff.flags.archived:
+		return paused, "note is archived"
+	case itemDiff.flags.protected && !opts.IncludeProtected:
+		return skipped, "note is protected, use --include-protected to pull"
+	}
+
+	if itemDiff.diff != localNewer && itemDiff.diff != merged {
+		return "", ""
+	}
+
+	// pushing would also drop the flags, as they aren't kept when content is parsed
+	switch {
+	case itemDiff.flags.locked:
+		return refused, "note is locked, local changes aren't pushed"
+	case itemDiff.flags.protected:
+		return refused, "note is protected, local changes aren't pushed"
+	}
+
+	return "", ""
+}
@@ -0,0 +1,72 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNoteFlags(t *testing.T) {
+	flags, err := parseNoteFlags(`{"title": ".bashrc", "text": "x", "protected": true, "appData": {"org.standardnotes.sn": {"archived": true, "locked": true}}}`)
+	require.NoError(t, err)
+	assert.Equal(t, noteFlags{archived: true, locked: true, protected: true}, flags)
+	assert.Equal(t, "archived, locked, protected", flags.String())
+
+	flags, err = parseNoteFlags(`{"title": ".bashrc", "text": "x", "appData": {}}`)
+	require.NoError(t, err)
+	assert.Equal(t, noteFlags{}, flags)
+	assert.Empty(t, flags.String())
+}
+
+func TestCheckNoteFlags(t *testing.T) {
+	status, _ := checkNoteFlags(ItemDiff{diff: remoteNewer, flags: noteFlags{archived: true}}, Options{})
+	assert.Equal(t, paused, status)
+
+	status, _ = checkNoteFlags(ItemDiff{diff: remoteNewer, flags: noteFlags{protected: true}}, Options{})
+	assert.Equal(t, skipped, status)
+
+	status, _ = checkNoteFlags(ItemDiff{diff: remoteNewer, flags: noteFlags{protected: true}}, Options{IncludeProtected: true})
+	assert.Empty(t, status)
+
+	status, _ = checkNoteFlags(ItemDiff{diff: localNewer, flags: noteFlags{protected: true}}, Options{IncludeProtected: true})
+	assert.Equal(t, refused, status)
+
+	// locked notes are pull only
+	status, _ = checkNoteFlags(ItemDiff{diff: remoteNewer, flags: noteFlags{locked: true}}, Options{})
+	assert.Empty(t, status)
+
+	status, _ = checkNoteFlags(ItemDiff{diff: localNewer, flags: noteFlags{locked: true}}, Options{})
+	assert.Equal(t, refused, status)
+
+	status, _ = checkNoteFlags(ItemDiff{diff: merged, flags: noteFlags{locked: true}}, Options{})
+	assert.Equal(t, refused, status)
+}
+
+func TestStatusShowsNoteFlags(t *testing.T) {
+	home := getTemporaryHome()
+	bashrcPath := fmt.Sprintf("%s/.bashrc", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{bashrcPath: "alias ll='ls -l'"}))
+
+	defer os.RemoveAll(home)
+
+	tag, err := items.NewTag(DotFilesTag, nil)
+	require.NoError(t, err)
+
+	note := createNote(".bashrc", "alias ll='ls -l'")
+	remote := tagsWithNotes{{
+		tag:   tag,
+		notes: items.Notes{note},
+		flags: map[string]noteFlags{note.UUID: {locked: true}},
+	}}
+
+	diffs, msg, err := status(context.Background(), remote, home, nil, Options{}, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, noteFlags{locked: true}, diffs[0].flags)
+	assert.Contains(t, msg, "locked")
+}
@@ -9,8 +9,6 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/asdine/storm/v3"
-	"github.com/jonhadfield/gosn-v2/cache"
 	"github.com/jonhadfield/gosn-v2/items"
 	"github.com/pkg/errors"
 )
@@ -53,9 +51,30 @@ func stripHome(in, home string) string {
 	return in
 }
 
-func addToDB(db *storm.DB, session *cache.Session, itemDiffs []ItemDiff, close bool) (err error) {
+// addToDB stages the remote side of the item diffs in the batch
+func addToDB(b *batch, itemDiffs []ItemDiff, opts Options) (err error) {
 	var dItems items.Items
+
 	for i := range itemDiffs {
+		// encrypt a copy so the plaintext is still available to write locally
+		if itemDiffs[i].encrypted || opts.Encryption.encrypts(itemDiffs[i].homeRelPath) {
+			note := itemDiffs[i].remote
+
+			var text string
+
+			text, err = encryptText(note.Content.GetText(), opts.Encryption.Passphrase)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %s: %w", itemDiffs[i].homeRelPath, err)
+			}
+
+			note.Content.SetText(text)
+			setChecksum(&note)
+			dItems = append(dItems, &note)
+
+			continue
+		}
+
+		setChecksum(&itemDiffs[i].remote)
 		dItems = append(dItems, &itemDiffs[i].remote)
 	}
 
@@ -64,7 +83,9 @@ func addToDB(db *storm.DB, session *cache.Session, itemDiffs []ItemDiff, close b
 		return
 	}
 
-	return cache.SaveItems(session, db, dItems, close)
+	b.add(dItems...)
+
+	return nil
 }
 
 func getTagIfExists(name string, twn tagsWithNotes) (tag items.Tag, found bool) {
@@ -77,7 +98,7 @@ func getTagIfExists(name string, twn tagsWithNotes) (tag items.Tag, found bool)
 	return tag, false
 }
 
-func createMissingTags(db *storm.DB, session *cache.Session, pt string, twn tagsWithNotes) (newTags items.Tags, err error) {
+func createMissingTags(pt string, twn tagsWithNotes) (newTags items.Tags, err error) {
 	var fts []string
 
 	ts := strings.Split(pt, ".")
@@ -94,25 +115,25 @@ func createMissingTags(db *storm.DB, session *cache.Session, pt string, twn tags
 		}
 	}
 
-	itemsToPush := items.Items{}
-
 	for _, f := range fts {
 		_, found := getTagIfExists(f, twn)
 		if !found {
-			nt := createTag(f)
-			itemsToPush = append(itemsToPush, &nt)
-		}
-	}
+			var nt items.Tag
 
-	err = cache.SaveItems(session, db, itemsToPush, false)
-	if err != nil {
-		return
+			nt, err = createTag(f)
+			if err != nil {
+				return nil, err
+			}
+
+			newTags = append(newTags, nt)
+		}
 	}
 
-	return itemsToPush.Tags(), err
+	return newTags, nil
 }
 
-func pushAndTag(db *storm.DB, session *cache.Session, tim map[string]items.Items, twn tagsWithNotes) (tagsPushed, notesPushed int, err error) {
+// pushAndTag stages the notes and the tags referencing them in the batch, creating any missing tags
+func pushAndTag(b *batch, tim map[string]items.Items, twn tagsWithNotes) (tagsPushed, notesPushed int, err error) {
 	// create missing tags first to create a new tim
 	itemsToPush := items.Items{}
 	for potentialTag, notes := range tim {
@@ -134,10 +155,15 @@ func pushAndTag(db *storm.DB, session *cache.Session, tim map[string]items.Items
 		} else {
 			// need to create tag
 			var newTags items.Tags
-			newTags, err = createMissingTags(db, session, potentialTag, twn)
+
+			newTags, err = createMissingTags(potentialTag, twn)
 			if err != nil {
 				return
 			}
+
+			for x := range newTags {
+				itemsToPush = append(itemsToPush, &newTags[x])
+			}
 			// create a new item reference for each note to be tagged
 			var newReferences items.ItemReferences
 			for _, note := range notes {
@@ -147,13 +173,12 @@ func pushAndTag(db *storm.DB, session *cache.Session, tim map[string]items.Items
 					ContentType: "Note",
 				})
 			}
-			newTag := newTags[len(newTags)-1]
+			newTag := &newTags[len(newTags)-1]
 			newTag.Content.UpsertReferences(newReferences)
-			itemsToPush = append(itemsToPush, &newTag)
 
 			// add to twn so we don't getTagsWithNotes duplicates
 			twn = append(twn, tagWithNotes{
-				tag:   newTag,
+				tag:   *newTag,
 				notes: notes.Notes(),
 			})
 			for x := 0; x < len(newTags)-1; x++ {
@@ -164,47 +189,28 @@ func pushAndTag(db *storm.DB, session *cache.Session, tim map[string]items.Items
 			}
 		}
 	}
-	err = cache.SaveItems(session, db, itemsToPush, true)
+
+	b.add(itemsToPush...)
+
 	tagsPushed, notesPushed = getItemCounts(itemsToPush)
 
-	return tagsPushed, notesPushed, err
+	return tagsPushed, notesPushed, nil
 }
 
 func getItemCounts(items items.Items) (tags, notes int) {
 	return len(items.Tags()), len(items.Notes())
 }
 
-func createTag(name string) (tag items.Tag) {
+func createTag(name string) (tag items.Tag, err error) {
 	//TODO populate references
 	var references items.ItemReferences
-	tag, err := items.NewTag(name, references)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return tag
-}
-
-func createLocal(itemDiffs []ItemDiff) error {
-	for _, item := range itemDiffs {
-		dir, _ := filepath.Split(item.path)
-		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-			return err
-		}
-
-		f, err := os.Create(item.path)
-		if err != nil {
-			return err
-		}
 
-		_, err = f.WriteString(item.remote.Content.GetText())
-		if err != nil {
-			f.Close()
-			return err
-		}
+	tag, err = items.NewTag(name, references)
+	if err != nil {
+		return tag, fmt.Errorf("failed to create tag %q: %w", name, err)
 	}
 
-	return nil
+	return tag, nil
 }
 
 func getPathType(path string) (res string, err error) {
@@ -512,7 +518,12 @@ func tagTitleToFSDir(title, home string) (path string, err error) {
 	b := strings.ReplaceAll(a, ".", string(os.PathSeparator))
 	c := addDot(b)
 
-	return home + string(os.PathSeparator) + c + string(os.PathSeparator), err
+	path = home + string(os.PathSeparator) + c + string(os.PathSeparator)
+	if err = checkWithinHome(path, home); err != nil {
+		return "", err
+	}
+
+	return path, err
 }
 
 func pathToTag(homeRelPath string) string {
@@ -578,7 +589,7 @@ func colourDiff(diff string) string {
 	switch diff {
 	case identical:
 		return green(diff)
-	case localMissing:
+	case localMissing, blockMissing:
 		return red(diff)
 	case localNewer:
 		return yellow(diff)
@@ -586,6 +597,16 @@ func colourDiff(diff string) string {
 		return yellow(diff)
 	case remoteNewer:
 		return yellow(diff)
+	case merged:
+		return green(diff)
+	case conflicted:
+		return red(diff)
+	case invalidPath, undecryptable, notPlainText, refused, corrupt, failed:
+		return red(diff)
+	case verified:
+		return green(diff)
+	case paused, skipped, unverified, modified, changedElsewhere, cancelled:
+		return yellow(diff)
 	default:
 		return diff
 	}
@@ -6,41 +6,25 @@ import (
 	"testing"
 	"time"
 
-	"github.com/jonhadfield/gosn-v2"
 	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
 	"github.com/stretchr/testify/require"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestGetAllTagsWithoutNotes(t *testing.T) {
-	fiestaNote := gosn.NewNote()
-	fiestaNoteContent := gosn.NewNoteContent()
-	fiestaNoteContent.SetTitle("fiesta")
-	fiestaNote.Content = *fiestaNoteContent
-
-	focusNote := gosn.NewNote()
-	focusNoteContent := gosn.NewNoteContent()
-	focusNoteContent.SetTitle("focus")
-	focusNote.Content = *focusNoteContent
-
-	carsTagContent := gosn.NewTagContent()
-	carsTag := gosn.NewTag()
-	carsTagContent.SetTitle("cars")
-	carsTag.Content = *carsTagContent
-
-	carsFordTagContent := gosn.NewTagContent()
-	carsFordTag := gosn.NewTag()
-	carsFordTagContent.SetTitle("cars.ford")
-	carsFordTag.Content = *carsTagContent
+	fiestaNote := createNote("fiesta", "")
+	focusNote := createNote("focus", "")
+	carsFordTag := mustCreateTag("cars.ford")
 
 	twn := tagsWithNotes{
-		tagWithNotes{tag: carsFordTag, notes: gosn.Notes{fiestaNote, focusNote}},
+		tagWithNotes{tag: carsFordTag, notes: items.Notes{fiestaNote, focusNote}},
 	}
-	tagsWithoutNotes := getAllTagsWithoutNotes(twn, gosn.Notes{focusNote}, true)
+	tagsWithoutNotes := getAllTagsWithoutNotes(twn, items.Notes{focusNote}, true)
 	// should be zero as cars.ford tag still has fiesta note remaining
 	assert.Len(t, tagsWithoutNotes, 0)
-	tagsWithoutNotes = getAllTagsWithoutNotes(twn, gosn.Notes{focusNote, fiestaNote}, true)
+	tagsWithoutNotes = getAllTagsWithoutNotes(twn, items.Notes{focusNote, fiestaNote}, true)
 	// should be one as cars.ford tag no longer has notes (function doesn't check if cars tag is empty)
 	assert.Len(t, tagsWithoutNotes, 1)
 }
@@ -83,11 +67,24 @@ func TestDeDupe(t *testing.T) {
 	assert.Len(t, emptyList, 0)
 }
 
+func mustCreateTag(name string) items.Tag {
+	tag, err := createTag(name)
+	if err != nil {
+		panic(err)
+	}
+
+	return tag
+}
+
 func TestCreateTag(t *testing.T) {
-	newTag := createTag("my.test.tag")
+	newTag, err := createTag("my.test.tag")
+	require.NoError(t, err)
 	assert.Equal(t, "my.test.tag", newTag.Content.GetTitle())
 	assert.Equal(t, "Tag", newTag.ContentType)
 	assert.NotEmpty(t, newTag.UUID)
+
+	_, err = createTag(" ")
+	require.Error(t, err)
 }
 
 func TestStripHome(t *testing.T) {
@@ -121,7 +118,8 @@ func TestCompareIdentical(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, f.Close())
 	// verify local and remote identical produces correct ItemDiff
-	iDiff := compareNoteWithFile("apple", applePath, home, appleNote, true)
+	iDiff, err := compareNoteWithFile("apple", applePath, home, appleNote, Options{}, true)
+	require.NoError(t, err)
 	assert.Equal(t, identical, iDiff.diff)
 	assert.Equal(t, "apple", iDiff.tagTitle)
 	assert.Equal(t, "apple", iDiff.noteTitle)
@@ -144,7 +142,8 @@ func TestCompareRemoteNewer(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, f.Close())
 	// verify local and remote differ and remote newer produces correct ItemDiff
-	iDiff := compareNoteWithFile("lemon", lemonPath, home, lemonNote, true)
+	iDiff, err := compareNoteWithFile("lemon", lemonPath, home, lemonNote, Options{}, true)
+	require.NoError(t, err)
 	assert.Equal(t, remoteNewer, iDiff.diff)
 	assert.Equal(t, "lemon", iDiff.tagTitle)
 	assert.Equal(t, "lemon", iDiff.noteTitle)
@@ -166,7 +165,8 @@ func TestCompareLocalNewer(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, f.Close())
 	// verify local and remote differ and local newer produces correct ItemDiff
-	iDiff := compareNoteWithFile("lemon", lemonPath, home, lemonNote, true)
+	iDiff, err := compareNoteWithFile("lemon", lemonPath, home, lemonNote, Options{}, true)
+	require.NoError(t, err)
 	assert.Equal(t, localNewer, iDiff.diff)
 	assert.Equal(t, "lemon", iDiff.tagTitle)
 	assert.Equal(t, "lemon", iDiff.noteTitle)
@@ -205,16 +205,10 @@ func TestIsUnEncryptedSession(t *testing.T) {
 // }
 
 func TestNoteWithTagExists(t *testing.T) {
-	note := gosn.NewNote()
-	nContent := gosn.NewNoteContent()
-	nContent.SetTitle("apple")
-	note.Content = *nContent
-	tContent := gosn.NewTagContent()
-	tag := gosn.NewTag()
-	tContent.SetTitle("fruit")
-	tag.Content = *tContent
+	note := createNote("apple", "")
+	tag := mustCreateTag("fruit")
 	twn := tagsWithNotes{
-		tagWithNotes{tag: tag, notes: gosn.Notes{note}},
+		tagWithNotes{tag: tag, notes: items.Notes{note}},
 	}
 	assert.Equal(t, 1, noteWithTagExists("fruit", "apple", twn))
 }
@@ -234,7 +228,9 @@ func TestPushNoItems(t *testing.T) {
 	cso, err := cache.Sync(si)
 	require.NoError(t, err)
 
-	err = addToDB(cso.DB, testCacheSession, []ItemDiff{}, true)
+	defer cso.DB.Close()
+
+	err = addToDB(&batch{}, []ItemDiff{}, Options{})
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "no items")
 }
@@ -0,0 +1,58 @@
+package snsync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ryanuber/columnize"
+)
+
+// ErrPathsFailed is returned, along with the results of the other paths, when Options.KeepGoing
+// is set and any paths couldn't be read or compared
+var ErrPathsFailed = errors.New("paths failed")
+
+// failedItemDiff records a path that couldn't be read or compared, so the remaining paths can still be processed
+func failedItemDiff(path, home string, err error) ItemDiff {
+	return ItemDiff{
+		path:        path,
+		homeRelPath: stripHome(path, home),
+		diff:        failed,
+		err:         err,
+	}
+}
+
+// withoutFailures splits the paths that failed from the rest
+func withoutFailures(diffs []ItemDiff) (rest, failures []ItemDiff) {
+	for _, d := range diffs {
+		if d.diff == failed {
+			failures = append(failures, d)
+
+			continue
+		}
+
+		rest = append(rest, d)
+	}
+
+	return rest, failures
+}
+
+// failureSummary returns a table of the paths that failed, ending a command's output,
+// and an error wrapping ErrPathsFailed so the command exits non-zero
+func failureSummary(failures []ItemDiff) (summary string, err error) {
+	if len(failures) == 0 {
+		return "", nil
+	}
+
+	lines := make([]string, len(failures))
+	for i, d := range failures {
+		lines[i] = fmt.Sprintf("%s | %s | %s", bold(d.homeRelPath), colourDiff(failed), d.err)
+	}
+
+	summary = fmt.Sprintf("\n%s\n%s", bold("failed paths"), columnize.SimpleFormat(lines))
+
+	return summary, pathsFailedError(len(failures))
+}
+
+func pathsFailedError(n int) error {
+	return fmt.Errorf("%w: %d", ErrPathsFailed, n)
+}
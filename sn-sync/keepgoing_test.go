@@ -0,0 +1,102 @@
+package snsync
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createSocket creates a unix socket, a file type that can't be synced
+func createSocket(t *testing.T, path string) {
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = l.Close() })
+}
+
+func TestFindUntrackedKeepGoing(t *testing.T) {
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	dir := filepath.Join(home, ".app")
+	require.NoError(t, createTemporaryFiles(map[string]string{filepath.Join(dir, "config"): "x"}))
+	createSocket(t, filepath.Join(dir, "sock"))
+
+	_, err := findUntracked(context.Background(), []string{dir}, nil, home, false, true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sockets not supported")
+
+	diffs, err := findUntracked(context.Background(), []string{dir}, nil, home, true, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+
+	rest, failures := withoutFailures(diffs)
+	require.Len(t, rest, 1)
+	assert.Equal(t, ".app/config", rest[0].homeRelPath)
+	assert.Equal(t, untracked, rest[0].diff)
+	require.Len(t, failures, 1)
+	assert.Equal(t, ".app/sock", failures[0].homeRelPath)
+}
+
+func TestCompareKeepGoing(t *testing.T) {
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	bashrc := createNote(".bashrc", "x")
+	bashrc.UpdatedAt = "2024-01-01T00:00:00.000Z"
+	vimrc := createNote(".vimrc", "y")
+	vimrc.UpdatedAt = "2024-01-01T00:00:00.000Z"
+
+	require.NoError(t, createTemporaryFiles(map[string]string{filepath.Join(home, ".bashrc"): "x"}))
+	// a directory where the file should be can't be read
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".vimrc"), 0o700))
+
+	twn := tagsWithNotes{tagWithNotesFor(DotFilesTag, bashrc, vimrc)}
+
+	_, err := compare(context.Background(), twn, home, nil, nil, Options{}, true)
+	require.Error(t, err)
+
+	diffs, err := compare(context.Background(), twn, home, nil, nil, Options{KeepGoing: true}, true)
+	require.NoError(t, err)
+
+	rest, failures := withoutFailures(diffs)
+	require.Len(t, rest, 1)
+	assert.Equal(t, identical, rest[0].diff)
+	require.Len(t, failures, 1)
+	assert.Equal(t, ".vimrc", failures[0].homeRelPath)
+	assert.Equal(t, vimrc.UUID, failures[0].remote.UUID)
+
+	summary, err := failureSummary(failures)
+	assert.True(t, errors.Is(err, ErrPathsFailed))
+	assert.Contains(t, summary, ".vimrc")
+	assert.Contains(t, summary, "is a directory")
+}
+
+func TestGetLocalFSPathsKeepGoing(t *testing.T) {
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	dir := filepath.Join(home, ".app")
+	config := filepath.Join(dir, "config")
+	require.NoError(t, createTemporaryFiles(map[string]string{config: "x"}))
+	createSocket(t, filepath.Join(dir, "sock"))
+
+	_, _, err := getLocalFSPaths(context.Background(), []string{dir}, home, false, false)
+	require.Error(t, err)
+
+	paths, failures, err := getLocalFSPaths(context.Background(), []string{dir}, home, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{config}, paths)
+	require.Len(t, failures, 1)
+	assert.Equal(t, ".app/sock", failures[0].homeRelPath)
+
+	summary, err := failureSummary(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, summary)
+}
@@ -0,0 +1,100 @@
+package snsync
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// minItemsForPercentLimit defines the number of tracked items below which
+// the percentage limit isn't applied, as any change to a small tree is a large percentage
+const minItemsForPercentLimit = 10
+
+// Limits defines thresholds that abort a command, before anything is written, unless forced.
+// A zero value disables the limit.
+type Limits struct {
+	// MaxAddFiles is the maximum number of files a single add may push
+	MaxAddFiles int
+	// MaxAddBytes is the maximum total size of files a single add may push
+	MaxAddBytes int64
+	// MaxSyncItems is the maximum number of items a single sync may push or pull, or remove may delete
+	MaxSyncItems int
+	// MaxSyncPercent is the maximum percentage of tracked items a single sync may push or pull, or remove may delete
+	MaxSyncPercent int
+}
+
+func (l Limits) checkAdd(paths []string) error {
+	var violations []string
+
+	if l.MaxAddFiles > 0 && len(paths) > l.MaxAddFiles {
+		violations = append(violations, fmt.Sprintf("%d files to add (limit %d)", len(paths), l.MaxAddFiles))
+	}
+
+	if l.MaxAddBytes > 0 {
+		var total int64
+
+		for _, p := range paths {
+			if stat, err := os.Stat(p); err == nil {
+				total += stat.Size()
+			}
+		}
+
+		if total > l.MaxAddBytes {
+			violations = append(violations, fmt.Sprintf("%d bytes to add (limit %d)", total, l.MaxAddBytes))
+		}
+	}
+
+	return limitsError(violations)
+}
+
+// checkChanges checks the number of items to be changed by each action against the tracked total
+func (l Limits) checkChanges(tracked int, changes map[string]int) error {
+	var violations []string
+
+	for _, action := range []string{"push", "pull", "delete"} {
+		count := changes[action]
+		if count == 0 {
+			continue
+		}
+
+		if l.MaxSyncItems > 0 && count > l.MaxSyncItems {
+			violations = append(violations, fmt.Sprintf("%d items to %s (limit %d)", count, action, l.MaxSyncItems))
+
+			continue
+		}
+
+		if l.MaxSyncPercent > 0 && tracked >= minItemsForPercentLimit && count*100 > l.MaxSyncPercent*tracked {
+			violations = append(violations, fmt.Sprintf("%d of %d tracked items to %s (limit %d%%)", count, tracked, action, l.MaxSyncPercent))
+		}
+	}
+
+	return limitsError(violations)
+}
+
+func limitsError(violations []string) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("safety limits exceeded, nothing was changed:\n- %s\nuse --force to proceed", strings.Join(violations, "\n- "))
+}
+
+// countOverwrites returns the number of items pulled over existing content, leaving out files and managed blocks
+// that are missing, so the first sync on a new machine isn't refused
+func countOverwrites(itemDiffs []ItemDiff) (count int) {
+	for _, d := range itemDiffs {
+		if d.diff != localMissing && d.diff != blockMissing {
+			count++
+		}
+	}
+
+	return
+}
+
+func countTrackedNotes(twn tagsWithNotes) (count int) {
+	for _, t := range twn {
+		count += len(t.notes)
+	}
+
+	return
+}
@@ -0,0 +1,86 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsCheckAdd(t *testing.T) {
+	home := getTemporaryHome()
+	fileA := fmt.Sprintf("%s/.fileA", home)
+	fileB := fmt.Sprintf("%s/.fileB", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{
+		fileA: "0123456789",
+		fileB: "0123456789",
+	}))
+
+	defer os.RemoveAll(home)
+
+	paths := []string{fileA, fileB}
+
+	assert.NoError(t, Limits{}.checkAdd(paths))
+	assert.NoError(t, Limits{MaxAddFiles: 2, MaxAddBytes: 20}.checkAdd(paths))
+
+	err := Limits{MaxAddFiles: 1}.checkAdd(paths)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 files to add (limit 1)")
+
+	err = Limits{MaxAddBytes: 15}.checkAdd(paths)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "20 bytes to add (limit 15)")
+}
+
+func TestLimitsCheckChanges(t *testing.T) {
+	l := Limits{MaxSyncItems: 5, MaxSyncPercent: 50}
+
+	assert.NoError(t, l.checkChanges(20, map[string]int{"push": 5, "pull": 5}))
+
+	err := l.checkChanges(100, map[string]int{"pull": 6})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "6 items to pull (limit 5)")
+
+	err = l.checkChanges(10, map[string]int{"delete": 5, "push": 1})
+	assert.NoError(t, err)
+
+	err = Limits{MaxSyncPercent: 50}.checkChanges(10, map[string]int{"delete": 6})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "6 of 10 tracked items to delete (limit 50%)")
+
+	// percentage isn't applied to small numbers of tracked items
+	assert.NoError(t, Limits{MaxSyncPercent: 50}.checkChanges(minItemsForPercentLimit-1, map[string]int{"push": 8}))
+}
+
+func TestLimitsAllowFirstSync(t *testing.T) {
+	defer func() {
+		if err := CleanUp(*testCacheSession); err != nil {
+			fmt.Println("failed to wipe")
+		}
+	}()
+
+	home := getTemporaryHome()
+
+	fwc := make(map[string]string)
+	for i := 0; i < 12; i++ {
+		fwc[fmt.Sprintf("%s/.file%d", home, i)] = fmt.Sprintf("content %d", i)
+	}
+
+	require.NoError(t, createTemporaryFiles(fwc))
+
+	_, err := Add(context.Background(), AddInput{Session: testCacheSession, Home: home, Paths: []string{home}})
+	require.NoError(t, err)
+
+	// a new machine has none of the files, so pulling them all overwrites nothing
+	require.NoError(t, os.RemoveAll(home))
+
+	so, err := Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home,
+		Options: Options{Limits: Limits{MaxSyncItems: 5, MaxSyncPercent: 50}}})
+	require.NoError(t, err)
+	assert.Equal(t, 12, so.NoPulled)
+
+	assert.Equal(t, 1, countOverwrites([]ItemDiff{{diff: localMissing}, {diff: blockMissing}, {diff: remoteNewer}}))
+}
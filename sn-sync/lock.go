@@ -0,0 +1,168 @@
+package snsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockRetryInterval is how often a held lock is checked again when waiting for it
+const lockRetryInterval = 250 * time.Millisecond
+
+// lockPIDMaxLength is the most of the lock file read for the pid of the process holding it
+const lockPIDMaxLength = 32
+
+// errLockHeld is returned by lockFile when another open file holds the lock
+var errLockHeld = errors.New("lock held")
+
+// ErrLocked is returned when another sn-sync process holds the lock for the account
+type ErrLocked struct {
+	// PID is the process holding the lock, or 0 if it couldn't be read
+	PID  int
+	Path string
+}
+
+func (e *ErrLocked) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("locked by another sn-sync process, use --wait to wait for it (%s)", e.Path)
+	}
+
+	return fmt.Sprintf("locked by another sn-sync process (pid %d), use --wait to wait for it (%s)", e.PID, e.Path)
+}
+
+// Lock is an advisory lock held while a command uses the cache db and tracked files,
+// so commands for the same account don't run at the same time
+type Lock struct {
+	file *os.File
+}
+
+// LockPath returns the path of the lock file for the account using the cache db
+func LockPath(cacheDBPath string) string {
+	return strings.TrimSuffix(cacheDBPath, filepath.Ext(cacheDBPath)) + ".lock"
+}
+
+// AcquireLock takes the lock for the account using the cache db. If it's held by another process, ErrLocked
+// is returned, unless wait is set, in which case it's retried until taken or ctx is done. The lock is held
+// by the OS on the open lock file, so it's released when the process holding it exits, however it exits.
+func AcquireLock(ctx context.Context, cacheDBPath string, wait, debug bool) (*Lock, error) {
+	return acquireLockFile(ctx, LockPath(cacheDBPath), wait, debug)
+}
+
+// acquireLockFile takes the lock on the file at path, as AcquireLock does
+func acquireLockFile(ctx context.Context, path string, wait, debug bool) (*Lock, error) {
+	for {
+		f, pid, err := tryLock(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if f != nil {
+			debugPrint(debug, fmt.Sprintf("AcquireLock | locked %s", path))
+
+			return &Lock{file: f}, nil
+		}
+
+		locked := &ErrLocked{PID: pid, Path: path}
+		if !wait {
+			return nil, locked
+		}
+
+		debugPrint(debug, fmt.Sprintf("AcquireLock | waiting for pid %d", pid))
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("timed out waiting for lock: %w", locked)
+			}
+
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// Release releases the lock. The lock file is left in place, as removing it would let another
+// process lock a new file while one waiting on the old one also takes it.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	f := l.file
+	l.file = nil
+
+	// the pid is cleared first, so it's never left naming a process that no longer holds the lock
+	err := f.Truncate(0)
+
+	if uErr := unlockFile(f); err == nil {
+		err = uErr
+	}
+
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+
+	return err
+}
+
+// tryLock opens the lock file and locks it, writing this process's pid to it. If another process
+// holds the lock, the file isn't returned, but the pid it wrote is.
+func tryLock(path string) (f *os.File, holder int, err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+
+	f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err = lockFile(f); err != nil {
+		holder, _ = readLockPID(f)
+		_ = f.Close()
+
+		if errors.Is(err, errLockHeld) {
+			return nil, holder, nil
+		}
+
+		return nil, 0, err
+	}
+
+	if err = writeLockPID(f); err != nil {
+		_ = unlockFile(f)
+		_ = f.Close()
+
+		return nil, 0, err
+	}
+
+	return f, 0, nil
+}
+
+// readLockPID reads the pid written to the lock file by the process holding it
+func readLockPID(f *os.File) (int, error) {
+	b, err := io.ReadAll(io.NewSectionReader(f, 0, lockPIDMaxLength))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// writeLockPID replaces the content of the lock file with this process's pid
+func writeLockPID(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
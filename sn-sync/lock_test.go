@@ -0,0 +1,102 @@
+package snsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lockFilePID(t *testing.T, path string) string {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return string(b)
+}
+
+func TestAcquireLock(t *testing.T) {
+	dir := getTemporaryHome()
+	defer os.RemoveAll(dir)
+
+	cacheDBPath := filepath.Join(dir, "cache", "account.db")
+
+	lock, err := AcquireLock(context.Background(), cacheDBPath, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid()), lockFilePID(t, LockPath(cacheDBPath)))
+
+	// only the lock file is left behind
+	entries, err := os.ReadDir(filepath.Dir(cacheDBPath))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, lock.Release())
+	require.NoError(t, lock.Release())
+
+	// the file is kept, but no longer names a holder
+	assert.Empty(t, lockFilePID(t, LockPath(cacheDBPath)))
+
+	lock, err = AcquireLock(context.Background(), cacheDBPath, false, true)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+}
+
+func TestAcquireLockHeld(t *testing.T) {
+	dir := getTemporaryHome()
+	defer os.RemoveAll(dir)
+
+	cacheDBPath := filepath.Join(dir, "account.db")
+
+	// the lock is held on the open file, so is held against this process too
+	held, err := AcquireLock(context.Background(), cacheDBPath, false, true)
+	require.NoError(t, err)
+
+	_, err = AcquireLock(context.Background(), cacheDBPath, false, true)
+
+	var locked *ErrLocked
+	require.True(t, errors.As(err, &locked))
+	assert.Equal(t, os.Getpid(), locked.PID)
+	assert.Contains(t, err.Error(), strconv.Itoa(os.Getpid()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*lockRetryInterval)
+	defer cancel()
+
+	_, err = AcquireLock(ctx, cacheDBPath, true, true)
+	require.True(t, errors.As(err, &locked))
+	assert.Contains(t, err.Error(), "timed out")
+
+	// the wait is given up when cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(lockRetryInterval, cancel)
+
+	_, err = AcquireLock(ctx, cacheDBPath, true, true)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// and ends when the lock is released
+	time.AfterFunc(lockRetryInterval, func() { _ = held.Release() })
+
+	lock, err := AcquireLock(context.Background(), cacheDBPath, true, true)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+}
+
+func TestAcquireLockLeftByExitedProcess(t *testing.T) {
+	dir := getTemporaryHome()
+	defer os.RemoveAll(dir)
+
+	cacheDBPath := filepath.Join(dir, "account.db")
+	require.NoError(t, os.MkdirAll(dir, 0o700))
+
+	// a lock file naming a pid, but not locked, is free whether or not that pid is now in use
+	require.NoError(t, os.WriteFile(LockPath(cacheDBPath), []byte("1"), 0o600))
+
+	lock, err := AcquireLock(context.Background(), cacheDBPath, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid()), lockFilePID(t, LockPath(cacheDBPath)))
+	require.NoError(t, lock.Release())
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package snsync
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on the file without waiting, returning errLockHeld if it's held by another
+func lockFile(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return errLockHeld
+		}
+
+		return err
+	}
+}
+
+// unlockFile releases the flock on the file
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
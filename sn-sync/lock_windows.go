@@ -0,0 +1,33 @@
+//go:build windows
+
+package snsync
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRange is the single byte of the file that's locked. It's past the pid, as a LockFileEx
+// lock stops other processes reading the range it covers.
+var lockRange = windows.Overlapped{Offset: lockPIDMaxLength}
+
+// lockFile takes an exclusive lock on the file without waiting, returning errLockHeld if it's held by another
+func lockFile(f *os.File) error {
+	ol := lockRange
+
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) || errors.Is(err, windows.ERROR_IO_PENDING) {
+		return errLockHeld
+	}
+
+	return err
+}
+
+// unlockFile releases the lock on the file
+func unlockFile(f *os.File) error {
+	ol := lockRange
+
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol)
+}
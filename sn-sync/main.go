@@ -1,12 +1,10 @@
 package snsync
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"regexp"
 
-	"github.com/asdine/storm/v3"
-	"github.com/asdine/storm/v3/q"
 	"github.com/fatih/color"
 	"github.com/jonhadfield/gosn-v2/cache"
 	"github.com/jonhadfield/gosn-v2/items"
@@ -34,23 +32,10 @@ var (
 	yellow = color.New(color.FgYellow).SprintFunc()
 )
 
-func getTagsWithNotes(db *storm.DB, session *cache.Session) (t tagsWithNotes, err error) {
-	// validate session
-	if !session.Valid() {
-		err = errors.New("invalid session")
-		return
-	}
-
-	var notesAndTags cache.Items
-
-	if e := db.Select(q.In("ContentType", []string{"Note", "Tag", "SN|Component", "Extension"})).Find(&notesAndTags); e != nil {
-		if e.Error() != "not found" {
-			return
-		}
-	}
-
-	var allItems items.Items
-	allItems, err = notesAndTags.ToItems(session)
+// getTagsWithNotes loads the items in the store and returns the tracked tags with their notes, along with
+// all the items loaded
+func getTagsWithNotes(ctx context.Context, store RemoteStore) (t tagsWithNotes, allItems items.Items, err error) {
+	allItems, err = store.Load(ctx)
 	if err != nil {
 		return
 	}
@@ -59,6 +44,8 @@ func getTagsWithNotes(db *storm.DB, session *cache.Session) (t tagsWithNotes, er
 
 	var notes items.Notes
 
+	var components items.Components
+
 	r := regexp.MustCompile(fmt.Sprintf("%s.?.*", DotFilesTag))
 
 	for _, item := range allItems {
@@ -71,8 +58,15 @@ func getTagsWithNotes(db *storm.DB, session *cache.Session) (t tagsWithNotes, er
 			n := item.(*items.Note)
 			notes = append(notes, *n)
 		}
+
+		if item.GetContentType() == "SN|Component" && item.GetContent() != nil {
+			c := item.(*items.Component)
+			components = append(components, *c)
+		}
 	}
 
+	tracked := make(map[string]bool)
+
 	for _, dotfileTag := range dotfileTags {
 		twn := tagWithNotes{
 			tag: dotfileTag,
@@ -81,13 +75,34 @@ func getTagsWithNotes(db *storm.DB, session *cache.Session) (t tagsWithNotes, er
 		for _, note := range notes {
 			if StringInSlice(note.GetUUID(), getItemNoteRefIds(dotfileTag.GetContent().References()), false) {
 				twn.notes = append(twn.notes, note)
+				tracked[note.GetUUID()] = true
 			}
 		}
 
 		t = append(t, twn)
 	}
 
-	return t, err
+	var flags map[string]noteFlags
+
+	if fr, ok := store.(noteFlagReader); ok {
+		flags, err = fr.noteFlags(tracked)
+		if err != nil {
+			_ = store.Close()
+
+			return
+		}
+	}
+
+	editors := associatedEditors(components, notes)
+	editedWith := richTextEditors(components, notes)
+
+	for i := range t {
+		t[i].flags = flags
+		t[i].editors = editors
+		t[i].editedWith = editedWith
+	}
+
+	return t, allItems, err
 }
 
 func getItemNoteRefIds(itemRefs items.ItemReferences) (refIds []string) {
@@ -103,6 +118,12 @@ func getItemNoteRefIds(itemRefs items.ItemReferences) (refIds []string) {
 type tagWithNotes struct {
 	tag   items.Tag
 	notes items.Notes
+	// flags of the notes, by uuid
+	flags map[string]noteFlags
+	// names of editor components the notes open with, by uuid
+	editors map[string]string
+	// names of rich text editor components the notes have been opened with, by uuid
+	editedWith map[string]string
 }
 
 type tagsWithNotes []tagWithNotes
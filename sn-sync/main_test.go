@@ -1,42 +1,54 @@
 package snsync
 
 import (
+	"fmt"
 	"os"
 	"testing"
 
-	"github.com/jonhadfield/gosn-v2"
+	"github.com/clayrosenthal/sn-sync/internal/snserver"
 	"github.com/jonhadfield/gosn-v2/cache"
 )
 
-var testCacheSession *cache.Session
+var (
+	testServer       *snserver.Server
+	testCacheSession *cache.Session
+)
 
+// TestMain runs the tests against a fake Standard Notes server, with the cache db in a temporary directory
 func TestMain(m *testing.M) {
-	gs, err := gosn.CliSignIn(os.Getenv("SN_EMAIL"), os.Getenv("SN_PASSWORD"), os.Getenv("SN_SERVER"), true)
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	var err error
+
+	testServer, err = snserver.New("sn-sync@example.com", "sn-sync-test-password")
 	if err != nil {
 		panic(err)
 	}
 
-	testCacheSession = &cache.Session{
-		Session: &gosn.Session{
-			Debug:             true,
-			Server:            gs.Server,
-			Token:             gs.Token,
-			MasterKey:         gs.MasterKey,
-			RefreshExpiration: gs.RefreshExpiration,
-			RefreshToken:      gs.RefreshToken,
-			AccessToken:       gs.AccessToken,
-			AccessExpiration:  gs.AccessExpiration,
-		},
-		CacheDBPath: "",
+	defer testServer.Close()
+
+	cacheDir, err := os.MkdirTemp("", "sn-sync-cache")
+	if err != nil {
+		panic(err)
 	}
 
-	var path string
+	defer os.RemoveAll(cacheDir)
+
+	gs, err := testServer.Session(true)
+	if err != nil {
+		panic(fmt.Sprintf("failed to sign in to test server: %v", err))
+	}
+
+	testCacheSession = &cache.Session{
+		Session: gs,
+	}
 
-	path, err = cache.GenCacheDBPath(*testCacheSession, "", SNAppName)
+	testCacheSession.CacheDBPath, err = cache.GenCacheDBPath(*testCacheSession, cacheDir, SNAppName)
 	if err != nil {
 		panic(err)
 	}
 
-	testCacheSession.CacheDBPath = path
-	os.Exit(m.Run())
+	return m.Run()
 }
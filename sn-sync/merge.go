@@ -0,0 +1,450 @@
+package snsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// MergeFormatJSON merges JSON objects key by key
+	MergeFormatJSON = "json"
+	// MergeFormatYAML merges YAML mappings key by key
+	MergeFormatYAML = "yaml"
+	// MergeFormatTOML merges TOML tables key by key
+	MergeFormatTOML = "toml"
+	// MergeFormatINI merges INI and gitconfig style sections key by key
+	MergeFormatINI = "ini"
+	// MergeFormatNone disables structured merging for a path
+	MergeFormatNone = "none"
+)
+
+var iniLoadOptions = ini.LoadOptions{AllowShadows: true}
+
+// errMergeLosesFormatting is returned when the keys merge, but the changes can't be applied to the text
+// and rewriting the file would drop comments or formatting the user didn't change
+var errMergeLosesFormatting = errors.New("merging would lose comments or formatting")
+
+var mergeFormatsByExt = map[string]string{
+	".json":         MergeFormatJSON,
+	".yaml":         MergeFormatYAML,
+	".yml":          MergeFormatYAML,
+	".toml":         MergeFormatTOML,
+	".ini":          MergeFormatINI,
+	".gitconfig":    MergeFormatINI,
+	".editorconfig": MergeFormatINI,
+}
+
+// mergeFormat returns the structured format used to merge the path, with any per-path
+// setting (a glob matched against the home relative path or file name) taking precedence
+func mergeFormat(homeRelPath string, formats map[string]string) string {
+	base := filepath.Base(homeRelPath)
+
+	for pattern, format := range formats {
+		if m, _ := filepath.Match(pattern, homeRelPath); m {
+			return format
+		}
+
+		if m, _ := filepath.Match(pattern, base); m {
+			return format
+		}
+	}
+
+	if f, ok := mergeFormatsByExt[strings.ToLower(base)]; ok {
+		return f
+	}
+
+	if f, ok := mergeFormatsByExt[strings.ToLower(filepath.Ext(base))]; ok {
+		return f
+	}
+
+	return MergeFormatNone
+}
+
+func decodeStructured(format, content string) (res map[string]interface{}, err error) {
+	res = make(map[string]interface{})
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	switch format {
+	case MergeFormatJSON:
+		err = json.Unmarshal([]byte(content), &res)
+	case MergeFormatYAML:
+		err = yaml.Unmarshal([]byte(content), &res)
+	case MergeFormatTOML:
+		err = toml.Unmarshal([]byte(content), &res)
+	case MergeFormatINI:
+		var f *ini.File
+
+		// keep every value of repeated keys, such as gitconfig's fetch and insteadOf
+		f, err = ini.LoadSources(iniLoadOptions, []byte(content))
+		if err != nil {
+			return
+		}
+
+		for _, s := range f.Sections() {
+			if s.Name() == ini.DefaultSection && len(s.Keys()) == 0 {
+				continue
+			}
+
+			keys := make(map[string]interface{})
+			for _, k := range s.Keys() {
+				if values := k.ValueWithShadows(); len(values) > 1 {
+					keys[k.Name()] = values
+				} else {
+					keys[k.Name()] = k.Value()
+				}
+			}
+
+			res[s.Name()] = keys
+		}
+	default:
+		err = fmt.Errorf("unsupported merge format: %s", format)
+	}
+
+	return
+}
+
+func encodeStructured(format string, in map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+
+	switch format {
+	case MergeFormatJSON:
+		b, err := json.MarshalIndent(in, "", "  ")
+		if err != nil {
+			return "", err
+		}
+
+		buf.Write(b)
+		buf.WriteString("\n")
+	case MergeFormatYAML:
+		b, err := yaml.Marshal(in)
+		if err != nil {
+			return "", err
+		}
+
+		buf.Write(b)
+	case MergeFormatTOML:
+		b, err := toml.Marshal(in)
+		if err != nil {
+			return "", err
+		}
+
+		buf.Write(b)
+	case MergeFormatINI:
+		f := ini.Empty(iniLoadOptions)
+
+		for _, name := range sortedKeys(in) {
+			s, err := f.NewSection(name)
+			if err != nil {
+				return "", err
+			}
+
+			keys, _ := in[name].(map[string]interface{})
+			for _, k := range sortedKeys(keys) {
+				if err = newINIKey(s, k, keys[k]); err != nil {
+					return "", err
+				}
+			}
+		}
+
+		if _, err := f.WriteTo(&buf); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported merge format: %s", format)
+	}
+
+	return buf.String(), nil
+}
+
+// newINIKey adds the key to the section, repeating it for each value if it has more than one
+func newINIKey(s *ini.Section, name string, value interface{}) error {
+	values, ok := value.([]string)
+	if !ok {
+		_, err := s.NewKey(name, fmt.Sprint(value))
+
+		return err
+	}
+
+	key, err := s.NewKey(name, values[0])
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values[1:] {
+		if err = key.AddShadow(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys(in map[string]interface{}) (keys []string) {
+	for k := range in {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return
+}
+
+// merge3 merges the keys changed on each side since base. A key changed differently
+// on both sides is a conflict and keeps the local value.
+func merge3(base, local, remote map[string]interface{}, prefix string) (res map[string]interface{}, conflicts []string) {
+	res = make(map[string]interface{})
+
+	all := make(map[string]bool)
+	for _, m := range []map[string]interface{}{base, local, remote} {
+		for k := range m {
+			all[k] = true
+		}
+	}
+
+	for k := range all {
+		b, inBase := base[k]
+		l, inLocal := local[k]
+		r, inRemote := remote[k]
+
+		switch {
+		case inLocal == inRemote && reflect.DeepEqual(l, r):
+			// unchanged, or changed identically on both sides
+		case inLocal == inBase && reflect.DeepEqual(l, b):
+			// only remote changed
+			l, inLocal = r, inRemote
+		case inRemote == inBase && reflect.DeepEqual(r, b):
+			// only local changed
+		default:
+			bm, bOK := b.(map[string]interface{})
+			lm, lOK := l.(map[string]interface{})
+			rm, rOK := r.(map[string]interface{})
+
+			if lOK && rOK {
+				if !bOK {
+					bm = map[string]interface{}{}
+				}
+
+				var sub []string
+
+				l, sub = merge3(bm, lm, rm, prefix+k+".")
+				conflicts = append(conflicts, sub...)
+
+				break
+			}
+
+			conflicts = append(conflicts, prefix+k)
+		}
+
+		if inLocal {
+			res[k] = l
+		}
+	}
+
+	sort.Strings(conflicts)
+
+	return res, conflicts
+}
+
+// mergeStructured performs a key level three-way merge of local and remote content that have both changed
+// since base. The changes are applied line by line, so comments and formatting are kept, and the result is
+// only used if it has the keys merged. If they can't be, the merged keys are written out instead, but only if
+// both sides are already written as they would be, so nothing is lost. Content that can't be parsed, such as
+// JSON with comments, is merged line by line if the changes don't touch the same lines.
+func mergeStructured(format, base, local, remote string) (res string, conflicts []string, err error) {
+	text, clean := mergeLines(base, local, remote, false)
+
+	var b, l, r map[string]interface{}
+
+	b, err = decodeStructured(format, base)
+	if err == nil {
+		l, err = decodeStructured(format, local)
+	}
+
+	if err == nil {
+		r, err = decodeStructured(format, remote)
+	}
+
+	if err != nil {
+		if clean {
+			return text, nil, nil
+		}
+
+		return
+	}
+
+	var m map[string]interface{}
+
+	m, conflicts = merge3(b, l, r, "")
+	if len(conflicts) > 0 {
+		return
+	}
+
+	// lines both sides inserted in the same place may only have the merged keys in one order
+	for _, remoteFirst := range []bool{false, true} {
+		if text, clean = mergeLines(base, local, remote, remoteFirst); !clean {
+			break
+		}
+
+		if t, tErr := decodeStructured(format, text); tErr == nil && reflect.DeepEqual(t, m) {
+			return text, nil, nil
+		}
+	}
+
+	if !canonical(format, local, l) || !canonical(format, remote, r) {
+		return "", nil, errMergeLosesFormatting
+	}
+
+	res, err = encodeStructured(format, m)
+
+	return res, conflicts, err
+}
+
+// canonical returns true if the content is written exactly as its decoded keys would be encoded
+func canonical(format, content string, decoded map[string]interface{}) bool {
+	encoded, err := encodeStructured(format, decoded)
+
+	return err == nil && encoded == content
+}
+
+// lineHunk replaces the base lines from start to end with lines
+type lineHunk struct {
+	start, end int
+	lines      []string
+}
+
+// mergeLines applies the lines changed on each side since base to base, returning false if both sides
+// changed the same lines differently. Lines inserted at the same place by both are kept, local first
+// unless remoteFirst is set.
+func mergeLines(base, local, remote string, remoteFirst bool) (string, bool) {
+	baseLines := splitLines(base)
+	first, second := lineHunks(baseLines, splitLines(local)), lineHunks(baseLines, splitLines(remote))
+
+	if remoteFirst {
+		first, second = second, first
+	}
+
+	hunks := append(first, second...)
+
+	sort.SliceStable(hunks, func(i, j int) bool {
+		if hunks[i].start != hunks[j].start {
+			return hunks[i].start < hunks[j].start
+		}
+
+		return hunks[i].end < hunks[j].end
+	})
+
+	var res []string
+
+	pos := 0
+
+	var prev *lineHunk
+
+	for i := range hunks {
+		h := &hunks[i]
+
+		if prev != nil && h.start == prev.start && h.end == prev.end && reflect.DeepEqual(h.lines, prev.lines) {
+			// made identically on both sides
+			continue
+		}
+
+		if h.start < pos {
+			return "", false
+		}
+
+		res = append(res, baseLines[pos:h.start]...)
+		res = append(res, h.lines...)
+		pos = h.end
+		prev = h
+	}
+
+	res = append(res, baseLines[pos:]...)
+
+	return strings.Join(res, ""), true
+}
+
+// lineHunks returns the changes made to the base lines
+func lineHunks(base, changed []string) (hunks []lineHunk) {
+	for _, op := range difflib.NewMatcher(base, changed).GetOpCodes() {
+		if op.Tag != 'e' {
+			hunks = append(hunks, lineHunk{start: op.I1, end: op.I2, lines: changed[op.J1:op.J2]})
+		}
+	}
+
+	return hunks
+}
+
+// splitLines splits the content into lines, each keeping its line ending
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// resolveWithBase uses the content last synced to decide which side of a structured file
+// changed, merging them if both did, rather than relying on modification times
+func resolveWithBase(itemDiff ItemDiff, opts Options, debug bool) (ItemDiff, error) {
+	format := mergeFormat(itemDiff.homeRelPath, opts.MergeFormats)
+	if format == MergeFormatNone {
+		return itemDiff, nil
+	}
+
+	base, found, err := loadBase(opts.StateDir, itemDiff.remote.UUID)
+	if err != nil || !found {
+		return itemDiff, err
+	}
+
+	remoteText := itemDiff.remote.Content.GetText()
+
+	switch {
+	case itemDiff.local == remoteText:
+		// nothing to resolve
+	case itemDiff.local == base:
+		itemDiff.diff = remoteNewer
+	case remoteText == base:
+		itemDiff.diff = localNewer
+	default:
+		var res string
+
+		var conflicts []string
+
+		res, conflicts, err = mergeStructured(format, base, itemDiff.local, remoteText)
+		if err != nil {
+			// both sides changed, so neither can be chosen if the content can't be merged
+			debugPrint(debug, fmt.Sprintf("resolveWithBase | failed to merge %s: %s", itemDiff.homeRelPath, err))
+
+			itemDiff.diff = conflicted
+			itemDiff.conflicts = []string{fmt.Sprintf("can't merge as %s: %s", format, err)}
+
+			return itemDiff, nil
+		}
+
+		if len(conflicts) > 0 {
+			itemDiff.diff = conflicted
+			itemDiff.conflicts = conflicts
+
+			return itemDiff, nil
+		}
+
+		itemDiff.diff = merged
+		itemDiff.local = res
+	}
+
+	return itemDiff, nil
+}
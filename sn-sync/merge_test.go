@@ -0,0 +1,167 @@
+package snsync
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeFormat(t *testing.T) {
+	assert.Equal(t, MergeFormatJSON, mergeFormat(".config/Code/User/settings.json", nil))
+	assert.Equal(t, MergeFormatINI, mergeFormat(".gitconfig", nil))
+	assert.Equal(t, MergeFormatTOML, mergeFormat(".config/alacritty/alacritty.toml", nil))
+	assert.Equal(t, MergeFormatNone, mergeFormat(".bashrc", nil))
+	assert.Equal(t, MergeFormatINI, mergeFormat(".config/git/config", map[string]string{".config/git/config": MergeFormatINI}))
+	assert.Equal(t, MergeFormatNone, mergeFormat("settings.json", map[string]string{"*.json": MergeFormatNone}))
+}
+
+func TestMergeStructuredJSON(t *testing.T) {
+	base := "{\n  \"editor.fontSize\": 12,\n  \"files\": {\n    \"autoSave\": \"off\"\n  }\n}\n"
+	local := "{\n  \"editor.fontSize\": 14,\n  \"files\": {\n    \"autoSave\": \"off\"\n  }\n}\n"
+	remote := "{\n  \"editor.fontSize\": 12,\n  \"files\": {\n    \"autoSave\": \"off\",\n    \"trimTrailingWhitespace\": true\n  }\n}\n"
+
+	res, conflicts, err := mergeStructured(MergeFormatJSON, base, local, remote)
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, "{\n  \"editor.fontSize\": 14,\n  \"files\": {\n    \"autoSave\": \"off\",\n    \"trimTrailingWhitespace\": true\n  }\n}\n", res)
+
+	// same key changed differently on both sides
+	remote = "{\n  \"editor.fontSize\": 16,\n  \"files\": {\n    \"autoSave\": \"off\"\n  }\n}\n"
+	_, conflicts, err = mergeStructured(MergeFormatJSON, base, local, remote)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"editor.fontSize"}, conflicts)
+
+	// the keys merge, but the changes are on the same line, so can only be merged by rewriting it
+	_, _, err = mergeStructured(MergeFormatJSON, `{"a": 1, "b": 2}`, `{"a": 2, "b": 2}`, `{"a": 1, "b": 3}`)
+	assert.ErrorIs(t, err, errMergeLosesFormatting)
+
+	// unless both sides are already written as they would be
+	res, _, err = mergeStructured(MergeFormatJSON, "{\n  \"a\": 1,\n  \"b\": 1\n}\n",
+		"{\n  \"a\": 1,\n  \"b\": 1,\n  \"c\": 1\n}\n", "{\n  \"a\": 1,\n  \"b\": 2\n}\n")
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"a\": 1,\n  \"b\": 2,\n  \"c\": 1\n}\n", res)
+}
+
+func TestMergeStructuredKeepsComments(t *testing.T) {
+	base := "# identity\n[user]\n\tname = Jane ; shown in commits\n\n[core]\n\teditor = vim\n"
+	local := "# identity\n[user]\n\tname = Jane ; shown in commits\n\temail = jane@example.com\n\n[core]\n\teditor = vim\n"
+	remote := "# identity\n[user]\n\tname = Jane ; shown in commits\n\n[core]\n\teditor = nvim\n"
+
+	res, conflicts, err := mergeStructured(MergeFormatINI, base, local, remote)
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, "# identity\n[user]\n\tname = Jane ; shown in commits\n\temail = jane@example.com\n\n[core]\n\teditor = nvim\n", res)
+
+	base = "# colours\ntheme: dark # or light\nfont: mono\n"
+	local = "# colours\ntheme: light # or light\nfont: mono\n"
+	remote = "# colours\ntheme: dark # or light\nfont: mono\nsize: 12\n"
+
+	res, _, err = mergeStructured(MergeFormatYAML, base, local, remote)
+	require.NoError(t, err)
+	assert.Equal(t, "# colours\ntheme: light # or light\nfont: mono\nsize: 12\n", res)
+
+	// the keys merge, but the lines can't, and rewriting the file would drop the comments
+	base = "# colours\ntheme: {fg: white, bg: black}\n"
+	local = "# colours\ntheme: {fg: grey, bg: black}\n"
+	remote = "# colours\ntheme: {fg: white, bg: navy}\n"
+
+	_, _, err = mergeStructured(MergeFormatYAML, base, local, remote)
+	assert.ErrorIs(t, err, errMergeLosesFormatting)
+
+	// content that can't be parsed, such as JSON with comments, is merged if the changes are on different lines
+	base = "{\n  // font\n  \"a\": 1,\n  \"b\": 2\n}\n"
+	res, _, err = mergeStructured(MergeFormatJSON, base, "{\n  // font\n  \"a\": 3,\n  \"b\": 2\n}\n",
+		"{\n  // font\n  \"a\": 1,\n  \"b\": 4\n}\n")
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  // font\n  \"a\": 3,\n  \"b\": 4\n}\n", res)
+}
+
+func TestMergeStructuredINI(t *testing.T) {
+	base := "[user]\nname = Jane\n"
+	local := "[user]\nname = Jane\nemail = jane@example.com\n"
+	remote := "[user]\nname = Jane\n[core]\neditor = vim\n"
+
+	res, conflicts, err := mergeStructured(MergeFormatINI, base, local, remote)
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	m, err := decodeStructured(MergeFormatINI, res)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"user": map[string]interface{}{"name": "Jane", "email": "jane@example.com"},
+		"core": map[string]interface{}{"editor": "vim"},
+	}, m)
+}
+
+func TestMergeStructuredINIRepeatedKeys(t *testing.T) {
+	base := "[remote \"origin\"]\nfetch = +refs/heads/*:refs/remotes/origin/*\nfetch = +refs/tags/*:refs/tags/*\n"
+	local := base + "[user]\nname = Jane\n"
+	remote := "[remote \"origin\"]\nfetch = +refs/heads/*:refs/remotes/origin/*\nfetch = +refs/tags/*:refs/tags/*\n" +
+		"fetch = +refs/notes/*:refs/notes/*\n"
+
+	res, conflicts, err := mergeStructured(MergeFormatINI, base, local, remote)
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	m, err := decodeStructured(MergeFormatINI, res)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"remote \"origin\"": map[string]interface{}{"fetch": []string{
+			"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*", "+refs/notes/*:refs/notes/*",
+		}},
+		"user": map[string]interface{}{"name": "Jane"},
+	}, m)
+}
+
+func TestMergeStructuredYAMLDeletion(t *testing.T) {
+	base := "a: 1\nb: 2\n"
+	local := "a: 1\n"
+	remote := "a: 1\nb: 2\nc: 3\n"
+
+	res, conflicts, err := mergeStructured(MergeFormatYAML, base, local, remote)
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, "a: 1\nc: 3\n", res)
+}
+
+func TestResolveWithBase(t *testing.T) {
+	stateDir := getTemporaryHome()
+	opts := Options{StateDir: stateDir}
+
+	settings := func(a, b int) string { return fmt.Sprintf("{\n  \"a\": %d,\n  \"b\": %d\n}\n", a, b) }
+
+	note := createNote("settings.json", settings(1, 3))
+	require.NoError(t, saveBase(stateDir, note.UUID, settings(1, 2)))
+
+	// both changed different keys so content is merged
+	iDiff, err := resolveWithBase(ItemDiff{homeRelPath: ".vscode/settings.json", diff: localNewer, local: settings(2, 2), remote: note}, opts, true)
+	require.NoError(t, err)
+	assert.Equal(t, merged, iDiff.diff)
+	assert.Equal(t, settings(2, 3), iDiff.local)
+
+	// local unchanged since last sync so remote wins regardless of modification times
+	iDiff, err = resolveWithBase(ItemDiff{homeRelPath: ".vscode/settings.json", diff: localNewer, local: settings(1, 2), remote: note}, opts, true)
+	require.NoError(t, err)
+	assert.Equal(t, remoteNewer, iDiff.diff)
+
+	// both changed the same key
+	iDiff, err = resolveWithBase(ItemDiff{homeRelPath: ".vscode/settings.json", diff: remoteNewer, local: settings(1, 4), remote: note}, opts, true)
+	require.NoError(t, err)
+	assert.Equal(t, conflicted, iDiff.diff)
+	assert.Equal(t, []string{"b"}, iDiff.conflicts)
+
+	// content that can't be parsed is a conflict rather than either side winning
+	iDiff, err = resolveWithBase(ItemDiff{homeRelPath: ".vscode/settings.json", diff: localNewer, local: "{\n  \"a\": 1, // x\n  \"b\": 5\n}\n",
+		remote: note}, opts, true)
+	require.NoError(t, err)
+	assert.Equal(t, conflicted, iDiff.diff)
+	require.Len(t, iDiff.conflicts, 1)
+	assert.Contains(t, iDiff.conflicts[0], "can't merge as json")
+
+	// unstructured files are left alone
+	iDiff, err = resolveWithBase(ItemDiff{homeRelPath: ".bashrc", diff: remoteNewer, local: "x", remote: note}, opts, true)
+	require.NoError(t, err)
+	assert.Equal(t, remoteNewer, iDiff.diff)
+}
@@ -0,0 +1,50 @@
+package snsync
+
+import "github.com/jonhadfield/gosn-v2/items"
+
+// Options defines optional behaviour applied when comparing, pushing and pulling tracked content
+type Options struct {
+	// Secrets defines where {{secret "name"}} placeholders are resolved from; nil disables resolution
+	Secrets *SecretSources
+	// StateDir is where local sync state, such as the content last synced for each note, is kept
+	StateDir string
+	// MergeFormats maps path globs to the structured format used to merge them, overriding
+	// the format chosen by extension. Use MergeFormatNone to disable merging for a path.
+	MergeFormats map[string]string
+	// Limits aborts commands that would change more than expected
+	Limits Limits
+	// Force ignores Limits
+	Force bool
+	// SecretScan controls checking content for private keys and credentials before it's pushed
+	SecretScan SecretScanOptions
+	// Encryption defines paths whose content is encrypted with a separate passphrase before it's pushed
+	Encryption EncryptionOptions
+	// IncludeProtected pulls notes that are protected in Standard Notes
+	IncludeProtected bool
+	// KeepGoing records paths that can't be read, and carries on with the rest, rather than stopping.
+	// Commands then end with a summary of the failed paths and return ErrPathsFailed.
+	KeepGoing bool
+	// Editors maps path globs to the editor notes open with in Standard Notes, instead of plain text
+	Editors map[string]EditorSetting
+	// Store is where tracked notes and tags are kept. If nil, the Standard Notes account of the session is used.
+	Store RemoteStore
+	// Offline uses the items last synced to the cache db, without connecting to the server, and queues changes
+	// to be pushed by the next command run online. It only applies to the Standard Notes account of the session.
+	Offline bool
+	// Reporter receives the progress of the command. If nil, nothing is reported.
+	Reporter Reporter
+
+	// editorComponents are the installed editor components used to apply Editors
+	editorComponents items.Components
+}
+
+// SecretScanOptions defines how content that looks like it contains secrets is handled
+type SecretScanOptions struct {
+	// Disabled turns off scanning
+	Disabled bool
+	// Allow lists globs of home relative paths, or file names, that are pushed without scanning
+	Allow []string
+	// Confirm is called with the findings for a path and returns true if it should still be pushed.
+	// If nil, paths with findings are refused.
+	Confirm func(homeRelPath string, findings []SecretFinding) bool
+}
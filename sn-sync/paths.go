@@ -0,0 +1,131 @@
+package snsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errUnsafePath is the cause of errors for remote tag and note titles that would resolve to a path outside home
+var errUnsafePath = errors.New("unsafe path")
+
+// unsafePath returns an *ErrPathInvalid for a path that would be outside home, or reached through a link
+func unsafePath(path, reason string) error {
+	return &ErrPathInvalid{Path: path, Reason: fmt.Sprintf("%s, %s", errUnsafePath, reason), err: errUnsafePath}
+}
+
+// validateNoteTitle checks a note title is a single file name, as created by add
+func validateNoteTitle(title string) error {
+	switch {
+	case title == "", title == ".", title == "..":
+		return unsafePath(fmt.Sprintf("%q", title), "invalid note title")
+	case strings.ContainsAny(title, "/\\\x00"):
+		return unsafePath(fmt.Sprintf("%q", title), "note title contains a path separator")
+	}
+
+	return nil
+}
+
+// checkWithinHome returns an error if path resolves to a location outside home
+func checkWithinHome(path, home string) error {
+	rel, err := filepath.Rel(filepath.Clean(home), filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) || filepath.IsAbs(rel) {
+		return unsafePath(path, "outside "+home)
+	}
+
+	return nil
+}
+
+// remoteNotePath returns the local path of a note under the tag's directory, or an error if
+// the note's title would place it outside home
+func remoteNotePath(dir, noteTitle, home string) (string, error) {
+	if err := validateNoteTitle(noteTitle); err != nil {
+		return "", err
+	}
+
+	path := dir + noteTitle
+	if err := checkWithinHome(path, home); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// resolveFileLink returns the file path links to if it's a symlink, or an error if that's outside home, so a link
+// left in a tracked directory can't redirect a write. Otherwise path is returned as it is.
+func resolveFileLink(path, home string) (string, error) {
+	stat, err := os.Lstat(path)
+	if err != nil || stat.Mode()&os.ModeSymlink == 0 {
+		return path, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", unsafePath(path, "broken symlink")
+	}
+
+	// home may itself be reached through a link, such as /home on some systems
+	realHome, err := filepath.EvalSymlinks(home)
+	if err != nil {
+		realHome = home
+	}
+
+	if err = checkWithinHome(resolved, realHome); err != nil {
+		return "", unsafePath(path, fmt.Sprintf("links to %s, outside %s", resolved, home))
+	}
+
+	return resolved, nil
+}
+
+// checkNoSymlinkDirs returns an error if any existing directory between home and path is a symlink,
+// so a write to path can't be redirected elsewhere
+func checkNoSymlinkDirs(path, home string) error {
+	if err := checkWithinHome(path, home); err != nil {
+		return err
+	}
+
+	rel, _ := filepath.Rel(filepath.Clean(home), filepath.Clean(path))
+
+	dir := filepath.Clean(home)
+
+	parts := strings.Split(filepath.Dir(rel), string(os.PathSeparator))
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+
+		dir = filepath.Join(dir, part)
+
+		stat, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if stat.Mode()&os.ModeSymlink != 0 {
+			return unsafePath(dir, "symlinked directory")
+		}
+	}
+
+	return nil
+}
+
+// matchesPathGlobs returns true if any of the globs match the home relative path or its file name
+func matchesPathGlobs(homeRelPath string, globs []string) bool {
+	for _, pattern := range globs {
+		if m, _ := filepath.Match(pattern, homeRelPath); m {
+			return true
+		}
+
+		if m, _ := filepath.Match(pattern, filepath.Base(homeRelPath)); m {
+			return true
+		}
+	}
+
+	return false
+}
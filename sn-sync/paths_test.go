@@ -0,0 +1,75 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteNotePath(t *testing.T) {
+	home := "/home/me"
+
+	path, err := remoteNotePath(home+"/.config/", "app.conf", home)
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/me/.config/app.conf", path)
+
+	for _, title := range []string{"../../.profile", "x/../../etc", "..", "", "a\\b"} {
+		_, err = remoteNotePath(home+"/.config/", title, home)
+		assert.ErrorIs(t, err, errUnsafePath, title)
+
+		var pathInvalid *ErrPathInvalid
+		assert.ErrorAs(t, err, &pathInvalid, title)
+	}
+
+	assert.ErrorIs(t, checkWithinHome("/home/me/../other/.bashrc", home), errUnsafePath)
+	assert.ErrorIs(t, checkWithinHome("/home/meother/.bashrc", home), errUnsafePath)
+	assert.NoError(t, checkWithinHome("/home/me/.bashrc", home))
+}
+
+func TestCheckNoSymlinkDirs(t *testing.T) {
+	home := getTemporaryHome()
+	outside := getTemporaryHome()
+	require.NoError(t, os.MkdirAll(fmt.Sprintf("%s/.config", home), 0o755))
+	require.NoError(t, os.MkdirAll(outside, 0o755))
+	require.NoError(t, os.Symlink(outside, fmt.Sprintf("%s/.linked", home)))
+
+	defer os.RemoveAll(home)
+	defer os.RemoveAll(outside)
+
+	assert.NoError(t, checkNoSymlinkDirs(fmt.Sprintf("%s/.config/app/app.conf", home), home))
+	assert.NoError(t, checkNoSymlinkDirs(fmt.Sprintf("%s/.bashrc", home), home))
+	assert.ErrorIs(t, checkNoSymlinkDirs(fmt.Sprintf("%s/.linked/app.conf", home), home), errUnsafePath)
+}
+
+func TestCompareReportsUnsafeNoteTitles(t *testing.T) {
+	home := getTemporaryHome()
+	require.NoError(t, os.MkdirAll(home, 0o755))
+
+	defer os.RemoveAll(home)
+
+	tag, err := items.NewTag(DotFilesTag, nil)
+	require.NoError(t, err)
+
+	remote := tagsWithNotes{{
+		tag:   tag,
+		notes: items.Notes{createNote("../../.profile", "evil"), createNote(".bashrc", "alias ll='ls -l'")},
+	}}
+
+	diffs, _, err := compareRemoteWithLocalFS(context.Background(), remote, nil, home, Options{}, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+	assert.Equal(t, invalidPath, diffs[0].diff)
+	assert.ErrorIs(t, diffs[0].err, errUnsafePath)
+	assert.Equal(t, localMissing, diffs[1].diff)
+
+	// the unsafe note is never written
+	_, err = createLocal(context.Background(), []ItemDiff{diffs[1]}, home, Options{})
+	assert.NoError(t, err)
+	_, err = createLocal(context.Background(), []ItemDiff{{path: home + "/../.profile", remote: diffs[0].remote}}, home, Options{})
+	assert.Error(t, err)
+}
@@ -2,8 +2,8 @@ package snsync
 
 import (
 	"errors"
-	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/fatih/set"
@@ -73,15 +73,12 @@ func checkNoteTagConflicts(twn tagsWithNotes) error {
 	}
 
 	inter := set.Intersection(tagPaths, notePaths)
-	overlaps := make([]string, len(inter.List()))
-
-	for c, i := range inter.List() {
-		overlaps[c] = "- " + i.(string)
-	}
-
 	if inter.IsEmpty() {
 		return nil
 	}
 
-	return fmt.Errorf("the following notes and tags are overlapping:\n%s", strings.Join(overlaps, "\n"))
+	overlaps := set.StringSlice(inter)
+	sort.Strings(overlaps)
+
+	return &ErrPathConflict{Overlaps: overlaps}
 }
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"testing"
 
-	"github.com/jonhadfield/gosn-v2"
+	"github.com/jonhadfield/gosn-v2/items"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -20,10 +20,10 @@ func TestPreflightOverlaps(t *testing.T) {
 	// with overlap
 	noteOne := createNote("noteOne", "hello world")
 	twn := tagsWithNotes{tagWithNotes{
-		tag: createTag("something.else.noteOne"),
+		tag: mustCreateTag("something.else.noteOne"),
 	},
-		tagWithNotes{createTag("something.else"),
-			gosn.Notes{noteOne}},
+		tagWithNotes{mustCreateTag("something.else"),
+			items.Notes{noteOne}, nil, nil, nil},
 	}
 	err := checkNoteTagConflicts(twn)
 	assert.Error(t, err)
@@ -33,10 +33,10 @@ func TestPreflightOverlaps1(t *testing.T) {
 	// without overlap
 	noteOne := createNote("noteTwo", "hello world")
 	twn := tagsWithNotes{tagWithNotes{
-		tag: createTag("something.else.noteOne"),
+		tag: mustCreateTag("something.else.noteOne"),
 	},
-		tagWithNotes{createTag("something.else"),
-			gosn.Notes{noteOne}},
+		tagWithNotes{mustCreateTag("something.else"),
+			items.Notes{noteOne}, nil, nil, nil},
 	}
 	err := checkNoteTagConflicts(twn)
 	assert.NoError(t, err)
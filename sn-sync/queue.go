@@ -0,0 +1,353 @@
+package snsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/ryanuber/columnize"
+)
+
+const (
+	// queueBackoff is how long after a failed replay the queue is next pushed, doubled with each failure
+	queueBackoff = 30 * time.Second
+	// queueMaxBackoff is the longest time between attempts to push the queue
+	queueMaxBackoff = time.Hour
+)
+
+// pushQueue holds changes made while the server couldn't be reached, to be pushed when it can
+type pushQueue struct {
+	Items       []queuedItem `json:"items"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt time.Time    `json:"next_attempt"`
+	LastError   string       `json:"last_error,omitempty"`
+}
+
+// queuedItem is an item as it'll be pushed, encrypted as it would be in the cache db
+type queuedItem struct {
+	Title    string              `json:"title"`
+	QueuedAt time.Time           `json:"queued_at"`
+	Item     items.EncryptedItem `json:"item"`
+}
+
+// QueuePath returns the path of the queue of changes waiting to be pushed for the account using the cache db
+func QueuePath(cacheDBPath string) string {
+	return strings.TrimSuffix(cacheDBPath, filepath.Ext(cacheDBPath)) + "-queue.json"
+}
+
+func loadQueue(path string) (q pushQueue, err error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(b, &q); err != nil {
+		return q, fmt.Errorf("invalid queue %s: %w", path, err)
+	}
+
+	return q, nil
+}
+
+// save writes the queue, or removes it if empty
+func (q pushQueue) save(path string) error {
+	if len(q.Items) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	b, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b)
+}
+
+// add queues the items, replacing any already queued with the same uuid so only the latest change is pushed
+func (q *pushQueue) add(its items.Items, session *cache.Session) error {
+	now := time.Now().UTC()
+
+	for _, it := range its {
+		ei, err := items.EncryptItem(it, session.DefaultItemsKey, session.Session)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", it.GetUUID(), err)
+		}
+
+		qi := queuedItem{Title: itemTitle(it), QueuedAt: now, Item: ei}
+
+		var replaced bool
+
+		for i := range q.Items {
+			if q.Items[i].Item.UUID == ei.UUID {
+				q.Items[i] = qi
+				replaced = true
+
+				break
+			}
+		}
+
+		if !replaced {
+			q.Items = append(q.Items, qi)
+		}
+	}
+
+	return nil
+}
+
+// due returns true if the queue has items and its next attempt isn't delayed by a failed one
+func (q pushQueue) due(now time.Time) bool {
+	return len(q.Items) > 0 && !now.Before(q.NextAttempt)
+}
+
+// failed records a failed attempt to push the queue, delaying the next
+func (q *pushQueue) failed(err error, now time.Time) {
+	q.Attempts++
+	q.NextAttempt = now.Add(queueDelay(q.Attempts))
+	q.LastError = err.Error()
+}
+
+// queueDelay returns how long to wait before pushing the queue again, after the given number of failed attempts
+func queueDelay(attempts int) time.Duration {
+	delay := queueBackoff
+
+	for i := 1; i < attempts && delay < queueMaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > queueMaxBackoff {
+		delay = queueMaxBackoff
+	}
+
+	return delay
+}
+
+// decrypt returns the queued items, using the session's items keys
+func (q pushQueue) decrypt(session *cache.Session) (its items.Items, err error) {
+	if len(q.Items) == 0 {
+		return nil, nil
+	}
+
+	var eItems items.EncryptedItems
+
+	for _, qi := range q.Items {
+		if qi.Item.Deleted {
+			continue
+		}
+
+		eItems = append(eItems, qi.Item)
+	}
+
+	if len(eItems) > 0 {
+		if its, err = eItems.DecryptAndParse(session.Session); err != nil {
+			return nil, fmt.Errorf("failed to decrypt queue: %w", err)
+		}
+	}
+
+	for _, qi := range q.Items {
+		if !qi.Item.Deleted {
+			continue
+		}
+
+		it, dErr := deletedItem(qi.Item)
+		if dErr != nil {
+			return nil, dErr
+		}
+
+		its = append(its, it)
+	}
+
+	return its, nil
+}
+
+// deletedItem returns a note or tag marked deleted, with the uuid and timestamps of the encrypted item, as
+// deleted items have no content to decrypt
+func deletedItem(ei items.EncryptedItem) (items.Item, error) {
+	ic := items.ItemCommon{
+		UUID:               ei.UUID,
+		ContentType:        ei.ContentType,
+		Deleted:            true,
+		CreatedAt:          ei.CreatedAt,
+		UpdatedAt:          ei.UpdatedAt,
+		CreatedAtTimestamp: ei.CreatedAtTimestamp,
+		UpdatedAtTimestamp: ei.UpdatedAtTimestamp,
+	}
+
+	switch ei.ContentType {
+	case "Note":
+		return &items.Note{ItemCommon: ic}, nil
+	case "Tag":
+		return &items.Tag{ItemCommon: ic}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported item queued: %s", ei.ContentType)
+}
+
+// overlay returns the items with the queued ones applied, as they'll be once the queue is pushed
+func overlay(its, queued items.Items) items.Items {
+	byUUID := make(map[string]bool)
+	for _, it := range queued {
+		byUUID[it.GetUUID()] = true
+	}
+
+	var res items.Items
+
+	for _, it := range its {
+		if !byUUID[it.GetUUID()] {
+			res = append(res, it)
+		}
+	}
+
+	for _, it := range queued {
+		if !it.IsDeleted() {
+			res = append(res, it)
+		}
+	}
+
+	return res
+}
+
+func itemTitle(it items.Item) string {
+	switch v := it.(type) {
+	case *items.Note:
+		return v.Content.GetTitle()
+	case *items.Tag:
+		return v.Content.GetTitle()
+	}
+
+	return it.GetUUID()
+}
+
+// unreachable returns true if the error is from failing to connect to the server, or losing the connection,
+// rather than a response from it. Any response, even an error, means it can be reached.
+func unreachable(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && !errors.Is(err, context.Canceled)
+}
+
+// staleWarning returns a line to show before the output of commands comparing local files with the items last
+// synced, if they're used offline
+func staleWarning(session *cache.Session, opts Options) string {
+	if !opts.Offline || opts.Store != nil {
+		return ""
+	}
+
+	synced := "unknown"
+	if fi, err := os.Stat(session.CacheDBPath); err == nil {
+		synced = fi.ModTime().Format(time.RFC3339)
+	}
+
+	return yellow(fmt.Sprintf("offline: comparing with items last synced at %s, which may be stale", synced)) + "\n"
+}
+
+// QueueOutput describes the changes waiting to be pushed
+type QueueOutput struct {
+	// Queued is the number of items waiting to be pushed
+	Queued int
+	// Attempts is the number of failed attempts to push them
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Msg         string
+}
+
+// Queue returns the changes waiting to be pushed for the account using the session's cache db. They're pushed
+// by the next command run online, unless a previous attempt failed, in which case they wait until NextAttempt.
+func Queue(session *cache.Session) (qo QueueOutput, err error) {
+	if session == nil || session.CacheDBPath == "" {
+		return qo, ErrInvalidSession
+	}
+
+	q, err := loadQueue(QueuePath(session.CacheDBPath))
+	if err != nil {
+		return
+	}
+
+	qo.Queued = len(q.Items)
+	qo.Attempts = q.Attempts
+	qo.NextAttempt = q.NextAttempt
+	qo.LastError = q.LastError
+
+	if len(q.Items) == 0 {
+		qo.Msg = "nothing queued"
+
+		return qo, nil
+	}
+
+	var lines []string
+
+	for _, qi := range q.Items {
+		status := yellow("queued")
+		if qi.Item.Deleted {
+			status = yellow("queued delete")
+		}
+
+		lines = append(lines, fmt.Sprintf("%s | %s | %s at %s", bold(qi.Title), status, strings.ToLower(qi.Item.ContentType),
+			qi.QueuedAt.Local().Format(time.RFC3339)))
+	}
+
+	qo.Msg = columnize.SimpleFormat(lines)
+
+	if q.Attempts > 0 {
+		qo.Msg += fmt.Sprintf("\n%d failed attempts, next at %s: %s", q.Attempts,
+			q.NextAttempt.Local().Format(time.RFC3339), q.LastError)
+	}
+
+	return qo, nil
+}
+
+// ClearQueue drops the changes waiting to be pushed for the account using the session's cache db, returning how
+// many were dropped. The local files are left as they are, so the changes are pushed by the next sync.
+func ClearQueue(session *cache.Session) (cleared int, err error) {
+	if session == nil || session.CacheDBPath == "" {
+		return 0, ErrInvalidSession
+	}
+
+	path := QueuePath(session.CacheDBPath)
+
+	q, err := loadQueue(path)
+	if err != nil {
+		return
+	}
+
+	return len(q.Items), pushQueue{}.save(path)
+}
+
+// queueReporter is implemented by stores that queue changes when the server can't be reached
+type queueReporter interface {
+	queueResults() string
+}
+
+// appendQueueResults appends lines saying what the store did with queued changes, if anything, to a command's output
+func appendQueueResults(msg string, store RemoteStore) string {
+	qr, ok := store.(queueReporter)
+	if !ok {
+		return msg
+	}
+
+	results := qr.queueResults()
+
+	switch {
+	case results == "":
+		return msg
+	case msg == "":
+		return results
+	}
+
+	return msg + "\n" + results
+}
@@ -0,0 +1,202 @@
+package snsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/common"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/jonhadfield/gosn-v2/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// offlineSession returns a copy of the test session without items keys, as loaded from the keyring
+func offlineSession() *cache.Session {
+	gs := *testCacheSession.Session
+	gs.ItemsKeys = nil
+	gs.DefaultItemsKey = session.SessionItemsKey{}
+
+	return &cache.Session{Session: &gs, CacheDBPath: testCacheSession.CacheDBPath}
+}
+
+func TestQueueDelay(t *testing.T) {
+	assert.Equal(t, 30*time.Second, queueDelay(1))
+	assert.Equal(t, time.Minute, queueDelay(2))
+	assert.Equal(t, 4*time.Minute, queueDelay(4))
+	assert.Equal(t, time.Hour, queueDelay(20))
+}
+
+func TestUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+
+	resp, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	ts.Close()
+
+	_, err = http.Get(ts.URL)
+	assert.True(t, unreachable(fmt.Errorf("POST %s giving up after 1 attempt(s): %w", ts.URL, err)))
+
+	// a response, even an error, means it was reached
+	assert.False(t, unreachable(errors.New("session token is invalid or has expired")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+
+	_, err = http.DefaultClient.Do(req)
+	assert.False(t, unreachable(err))
+}
+
+func TestServerDown(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+
+	gs := *testCacheSession.Session
+	gs.Server = ts.URL
+	gs.HTTPClient = common.NewHTTPClient()
+	gs.HTTPClient.RetryMax = 0
+
+	down := &cache.Session{Session: &gs, CacheDBPath: testCacheSession.CacheDBPath}
+
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	// the failed sync, rather than a separate check, shows the server can't be reached
+	_, _, err := Status(context.Background(), down, home, nil, DefaultPageSize, Options{}, true)
+	assert.ErrorIs(t, err, ErrOffline)
+	assert.ErrorContains(t, err, "--offline")
+
+	// a push that fails as the server went away after loading is queued
+	gs.Server = testCacheSession.Server
+	store := remoteStore(Options{}, down)
+
+	_, err = store.Load(context.Background())
+	require.NoError(t, err)
+
+	gs.Server = ts.URL
+	tag := mustCreateTag(DotFilesTag)
+	note := createNote(".bashrc", "x")
+	tag.Content.UpsertReferences(items.ItemReferences{{UUID: note.UUID, ContentType: "Note"}})
+
+	rejected, err := store.Push(context.Background(), items.Items{&note, &tag})
+	require.NoError(t, err)
+	assert.Empty(t, rejected)
+	assert.Contains(t, store.(queueReporter).queueResults(), "2 changes queued")
+	assert.Empty(t, testServer.Items("Note"))
+}
+
+func TestOfflineWithoutCache(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	_, _, err := Status(context.Background(), offlineSession(), home, nil, DefaultPageSize, Options{Offline: true}, true)
+	assert.ErrorIs(t, err, ErrOffline)
+}
+
+func TestOfflineQueueWithServer(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home, _ := addToServer(t, ".apple", "apple content")
+	defer os.RemoveAll(home)
+
+	pear := filepath.Join(home, ".pear")
+	require.NoError(t, createTemporaryFiles(map[string]string{pear: "pear content"}))
+
+	ctx := context.Background()
+	offline := Options{Offline: true}
+
+	ao, err := Add(ctx, AddInput{Session: offlineSession(), Home: home, Paths: []string{pear}, Options: offline})
+	require.NoError(t, err)
+	assert.Equal(t, 1, ao.NotesPushed)
+	assert.Contains(t, ao.Msg, "2 changes queued") // the note and the tag referencing it
+
+	// nothing reached the server
+	assert.Len(t, testServer.Items("Note"), 1)
+
+	qo, err := Queue(testCacheSession)
+	require.NoError(t, err)
+	assert.Equal(t, 2, qo.Queued)
+	assert.Contains(t, qo.Msg, ".pear")
+
+	// the queued note is compared as if pushed
+	diffs, msg, err := Status(ctx, offlineSession(), home, nil, DefaultPageSize, offline, true)
+	require.NoError(t, err)
+	assert.Contains(t, msg, "offline")
+	require.Len(t, diffs, 2)
+
+	for _, d := range diffs {
+		assert.Equal(t, identical, d.diff)
+	}
+
+	// the queue is pushed by the next command run online
+	_, _, err = Status(ctx, testCacheSession, home, nil, DefaultPageSize, Options{}, true)
+	require.NoError(t, err)
+	assert.Len(t, testServer.Items("Note"), 2)
+
+	qo, err = Queue(testCacheSession)
+	require.NoError(t, err)
+	assert.Zero(t, qo.Queued)
+}
+
+func TestOfflineQueueRetriedWithServer(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home, _ := addToServer(t, ".apple", "apple content")
+	defer os.RemoveAll(home)
+
+	pear := filepath.Join(home, ".pear")
+	plum := filepath.Join(home, ".plum")
+	require.NoError(t, createTemporaryFiles(map[string]string{pear: "pear content", plum: "plum content"}))
+
+	ctx := context.Background()
+
+	_, err := Add(ctx, AddInput{Session: offlineSession(), Home: home, Paths: []string{pear}, Options: Options{Offline: true}})
+	require.NoError(t, err)
+
+	testServer.FailPushes(1, http.StatusUnauthorized, false)
+
+	// the failed replay is delayed
+	_, _, err = Status(ctx, testCacheSession, home, nil, DefaultPageSize, Options{}, true)
+	require.NoError(t, err)
+	assert.Len(t, testServer.Items("Note"), 1)
+
+	qo, err := Queue(testCacheSession)
+	require.NoError(t, err)
+	assert.Equal(t, 1, qo.Attempts)
+	assert.True(t, qo.NextAttempt.After(time.Now()))
+	assert.NotEmpty(t, qo.LastError)
+
+	// so it isn't retried by commands that only load
+	pushes := testServer.Pushes()
+	_, _, err = Status(ctx, testCacheSession, home, nil, DefaultPageSize, Options{}, true)
+	require.NoError(t, err)
+	assert.Equal(t, pushes, testServer.Pushes())
+
+	// but a change pushed online takes the queue with it
+	ao, err := Add(ctx, AddInput{Session: testCacheSession, Home: home, Paths: []string{plum}})
+	require.NoError(t, err)
+	assert.Contains(t, ao.Msg, "pushed 1 changes queued while offline")
+	assert.NotContains(t, ao.Msg, "changes queued until")
+	assert.Len(t, testServer.Items("Note"), 3)
+
+	cleared, err := ClearQueue(testCacheSession)
+	require.NoError(t, err)
+	assert.Zero(t, cleared)
+}
@@ -1,12 +1,10 @@
 package snsync
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os"
-	"time"
 
-	"github.com/briandowns/spinner"
 	"github.com/jonhadfield/gosn-v2/cache"
 	"github.com/jonhadfield/gosn-v2/items"
 	"github.com/ryanuber/columnize"
@@ -17,6 +15,7 @@ type RemoveInput struct {
 	Home     string
 	Paths    []string
 	PageSize int
+	Options  Options
 	Debug    bool
 }
 
@@ -26,7 +25,10 @@ type RemoveOutput struct {
 }
 
 // Remove stops tracking local Paths by removing the related notes from SN
-func Remove(ri RemoveInput, useStdErr bool) (ro RemoveOutput, err error) {
+func Remove(ctx context.Context, ri RemoveInput) (ro RemoveOutput, err error) {
+	r := ri.Options.reporter()
+	defer func() { r.Finished(err) }()
+
 	if StringInSlice(ri.Home, []string{"/", "/home"}, true) {
 		err = fmt.Errorf("not a good idea to use '%s' as home dir", ri.Home)
 		return
@@ -48,43 +50,20 @@ func Remove(ri RemoveInput, useStdErr bool) (ro RemoveOutput, err error) {
 
 	ri.Paths, err = preflight(ri.Home, ri.Paths)
 
-	if !ri.Debug {
-		prefix := HiWhite("syncing ")
-		if _, err = os.Stat(ri.Session.CacheDBPath); os.IsNotExist(err) {
-			prefix = HiWhite("initializing ")
-		}
-
-		s := spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stdout))
-		if useStdErr {
-			s = spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stderr))
-		}
-
-		s.Prefix = prefix
-		s.Start()
-		defer s.Stop()
-	}
-
-	// get populated db
-	si := cache.SyncInput{
-		Session: ri.Session,
-		Close:   false,
-	}
-
-	var cso cache.SyncOutput
+	r.PhaseStarted(loadPhase(ri.Session, ri.Options), 0)
 
-	cso, err = cache.Sync(si)
-	if err != nil {
-		return
-	}
+	store := remoteStore(ri.Options, ri.Session)
 
 	var twn tagsWithNotes
-	twn, err = getTagsWithNotes(cso.DB, ri.Session)
+	twn, _, err = getTagsWithNotes(ctx, store)
 	if err != nil {
 		return
 	}
 
 	err = checkNoteTagConflicts(twn)
 	if err != nil {
+		_ = store.Close()
+
 		return
 	}
 
@@ -136,6 +115,15 @@ func Remove(ri RemoveInput, useStdErr bool) (ro RemoveOutput, err error) {
 		debugPrint(ri.Debug, fmt.Sprintf("Remove | notes to removeFromDB: [%d] %s", x, n.Content.GetTitle()))
 	}
 
+	if !ri.Options.Force {
+		err = ri.Options.Limits.checkChanges(countTrackedNotes(twn), map[string]int{"delete": len(notesToRemove)})
+		if err != nil {
+			_ = store.Close()
+
+			return
+		}
+	}
+
 	var a items.Items
 
 	for i := range notesToRemove {
@@ -145,20 +133,23 @@ func Remove(ri RemoveInput, useStdErr bool) (ro RemoveOutput, err error) {
 	for i := range emptyTags {
 		a = append(a, &emptyTags[i])
 	}
-	ri.Session.CacheDB = cso.DB
-	x := removeInput{items: a, session: ri.Session}
+	b := &batch{}
+
+	x := removeInput{items: a, session: ri.Session, options: ri.Options, batch: b}
 	if err = removeFromDB(x); err != nil {
+		_ = store.Close()
+
 		return
 	}
 
-	// sync changes back to SN
-	si.Close = true
-	cso, err = cache.Sync(si)
-	if err != nil {
+	// delete the notes and empty tags from the store
+	r.PhaseStarted(PhasePushing, 0)
+
+	if _, err = deleteChanges(ctx, store, b); err != nil {
 		return
 	}
 
-	ro.Msg = fmt.Sprint(columnize.SimpleFormat(results))
+	ro.Msg = appendQueueResults(fmt.Sprint(columnize.SimpleFormat(results)), store)
 	ro.NotesRemoved = len(notesToRemove)
 	ro.TagsRemoved = len(emptyTags)
 
@@ -167,12 +158,14 @@ func Remove(ri RemoveInput, useStdErr bool) (ro RemoveOutput, err error) {
 
 type removeInput struct {
 	session *cache.Session
+	options Options
 	items   items.Items
+	batch   *batch
 }
 
 func removeFromDB(input removeInput) error {
-	if !input.session.Valid() {
-		return errors.New("session is invalid")
+	if err := checkSession(input.session, input.options); err != nil {
+		return err
 	}
 	var itemsToRemove items.Items
 
@@ -185,10 +178,7 @@ func removeFromDB(input removeInput) error {
 		return fmt.Errorf("no items to removeFromDB")
 	}
 
-	var err error
-	if err = cache.SaveItems(input.session, input.session.CacheDB, itemsToRemove, true); err != nil {
-		return err
-	}
+	input.batch.add(itemsToRemove...)
 
-	return err
+	return nil
 }
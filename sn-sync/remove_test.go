@@ -1,30 +1,29 @@
 package snsync
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"testing"
 
-	"github.com/jonhadfield/gosn-v2"
 	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
 	"github.com/stretchr/testify/require"
 )
 
 func TestRemoveNoItems(t *testing.T) {
-	err := removeFromDB(removeInput{session: testCacheSession, items: gosn.Items{}})
+	err := removeFromDB(removeInput{session: testCacheSession, items: items.Items{}})
 	require.Error(t, err)
 }
 
 func TestRemoveItemsInvalidSession(t *testing.T) {
-	tag := gosn.NewTag()
-	tagContent := gosn.NewTagContent()
-	tagContent.SetTitle("newTag")
+	tag := mustCreateTag("newTag")
 
 	err := removeFromDB(removeInput{session: &cache.Session{
 		Session:     nil,
 		CacheDB:     nil,
 		CacheDBPath: "",
-	}, items: gosn.Items{&tag}})
+	}, items: items.Items{&tag}})
 
 	require.Error(t, err)
 }
@@ -45,7 +44,7 @@ func TestRemoveInvalidSession(t *testing.T) {
 		Debug:   true,
 	}
 
-	_, err := Remove(ri, true)
+	_, err := Remove(context.Background(), ri)
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "invalid")
 }
@@ -57,7 +56,7 @@ func TestRemoveInvalidPath(t *testing.T) {
 		Paths:   []string{"/invalid"},
 		Debug:   true,
 	}
-	_, err := Remove(ri, true)
+	_, err := Remove(context.Background(), ri)
 	require.Error(t, err)
 }
 
@@ -68,7 +67,7 @@ func TestRemoveNoPaths(t *testing.T) {
 		Paths:   nil,
 		Debug:   true,
 	}
-	_, err := Remove(ri, true)
+	_, err := Remove(context.Background(), ri)
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "paths")
 }
@@ -94,7 +93,7 @@ func TestRemoveTags(t *testing.T) {
 	testCacheSession.CacheDB.Close()
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{gitConfigPath, applePath}}
 	var ao AddOutput
-	ao, err = Add(ai, true)
+	ao, err = Add(context.Background(), ai)
 	require.NoError(t, err)
 	require.Len(t, ao.PathsAdded, 2)
 	require.Len(t, ao.PathsExisting, 0)
@@ -109,7 +108,7 @@ func TestRemoveTags(t *testing.T) {
 	}
 
 	var ro RemoveOutput
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 	require.NoError(t, err)
 	require.Equal(t, 1, ro.NotesRemoved)
 	require.Equal(t, 0, ro.TagsRemoved)
@@ -145,7 +144,7 @@ func TestRemoveItems(t *testing.T) {
 
 	debugPrint(true, "Adding four paths")
 
-	ao, err := Add(ai, true)
+	ao, err := Add(context.Background(), ai)
 	require.NoError(t, err)
 	require.Len(t, ao.PathsAdded, 4)
 	require.Len(t, ao.PathsExisting, 0)
@@ -162,7 +161,7 @@ func TestRemoveItems(t *testing.T) {
 	}
 
 	var ro RemoveOutput
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 	require.NoError(t, err)
 	require.Equal(t, 1, ro.NotesRemoved)
 	require.Equal(t, 0, ro.TagsRemoved)
@@ -180,7 +179,7 @@ func TestRemoveItems(t *testing.T) {
 	}
 
 	debugPrint(true, "Removing \".cars/\"")
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 	require.NoError(t, err)
 	require.Equal(t, 1, ro.NotesRemoved)
 	require.Equal(t, 3, ro.TagsRemoved)
@@ -189,23 +188,15 @@ func TestRemoveItems(t *testing.T) {
 	re = regexp.MustCompile("\\.cars/mercedes/a250/premium\\s+removed")
 	require.True(t, re.MatchString(ro.Msg))
 
-	// get populated db
-	si := cache.SyncInput{
-		Session: testCacheSession,
-		Close:   false,
-	}
-
-	var cso cache.SyncOutput
-	cso, err = cache.Sync(si)
-	require.NoError(t, err)
+	store := NewSessionStore(testCacheSession)
 
 	var all tagsWithNotes
-	all, err = getTagsWithNotes(cso.DB, testCacheSession)
+	all, _, err = getTagsWithNotes(context.Background(), store)
 	debugPrint(true, "after removing all .cars we have")
 	for k, v := range all {
 		debugPrint(true, fmt.Sprint(k, v))
 	}
-	require.NoError(t, cso.DB.Close())
+	require.NoError(t, store.Close())
 
 	// removeFromDB nested path with single item (without trailing slash)
 	ri = RemoveInput{
@@ -215,7 +206,7 @@ func TestRemoveItems(t *testing.T) {
 		Debug:   false,
 	}
 
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 	require.NoError(t, err)
 	require.Equal(t, 2, ro.NotesRemoved)
 	require.Equal(t, 3, ro.TagsRemoved)
@@ -234,7 +225,7 @@ func TestRemoveItems(t *testing.T) {
 		Debug:   false,
 	}
 
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 
 	require.Error(t, err)
 
@@ -246,7 +237,7 @@ func TestRemoveItems(t *testing.T) {
 		Debug:   true,
 	}
 
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 	require.Error(t, err)
 }
 
@@ -277,7 +268,7 @@ func TestRemoveItemsRecursive(t *testing.T) {
 	require.NoError(t, createTemporaryFiles(fwc))
 	// add items
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{gitConfigPath, applePath, yellowPath, premiumPath}}
-	ao, err := Add(ai, true)
+	ao, err := Add(context.Background(), ai)
 	require.NoError(t, err)
 	require.Len(t, ao.PathsAdded, 4)
 	require.Len(t, ao.PathsExisting, 0)
@@ -291,7 +282,7 @@ func TestRemoveItemsRecursive(t *testing.T) {
 	}
 
 	var ro RemoveOutput
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 	require.NoError(t, err)
 	require.Equal(t, 2, ro.NotesRemoved)
 	require.Equal(t, 2, ro.TagsRemoved)
@@ -323,7 +314,7 @@ func TestRemoveItemsRecursiveTwo(t *testing.T) {
 	require.NoError(t, createTemporaryFiles(fwc))
 	// add items
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{gitConfigPath, greenPath, yellowPath, premiumPath}}
-	ao, err := Add(ai, true)
+	ao, err := Add(context.Background(), ai)
 	require.NoError(t, err)
 	require.Len(t, ao.PathsAdded, 4)
 	require.Len(t, ao.PathsExisting, 0)
@@ -336,7 +327,7 @@ func TestRemoveItemsRecursiveTwo(t *testing.T) {
 	}
 
 	var ro RemoveOutput
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 	require.NoError(t, err)
 	require.Equal(t, 2, ro.NotesRemoved)
 	require.Equal(t, 2, ro.TagsRemoved)
@@ -374,7 +365,7 @@ func TestRemoveItemsRecursiveThree(t *testing.T) {
 	// add items
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{gitConfigPath, greenPath, yellowPath, premiumPath, labradorPath}}
 
-	ao, err := Add(ai, true)
+	ao, err := Add(context.Background(), ai)
 	require.NoError(t, err)
 	require.Len(t, ao.PathsAdded, 5)
 	require.Len(t, ao.PathsExisting, 0)
@@ -387,7 +378,7 @@ func TestRemoveItemsRecursiveThree(t *testing.T) {
 	}
 
 	var ro RemoveOutput
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 
 	require.NoError(t, err)
 	require.Equal(t, 3, ro.NotesRemoved)
@@ -412,7 +403,7 @@ func TestRemoveAndCheckRemoved(t *testing.T) {
 	require.NoError(t, createTemporaryFiles(fwc))
 	// add items
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{gitConfigPath}}
-	ao, err := Add(ai, true)
+	ao, err := Add(context.Background(), ai)
 	require.NoError(t, err)
 	require.Len(t, ao.PathsAdded, 1)
 	require.Len(t, ao.PathsExisting, 0)
@@ -425,22 +416,18 @@ func TestRemoveAndCheckRemoved(t *testing.T) {
 	}
 
 	var ro RemoveOutput
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 
 	require.NoError(t, err)
 	require.Equal(t, 1, ro.NotesRemoved)
 	require.Equal(t, 1, ro.TagsRemoved)
 	require.Equal(t, 0, ro.NotTracked)
 
-	var cso cache.SyncOutput
-	cso, err = cache.Sync(cache.SyncInput{
-		Session: testCacheSession,
-	})
+	store := NewSessionStore(testCacheSession)
+	twn, _, err := getTagsWithNotes(context.Background(), store)
 	require.NoError(t, err)
-
-	twn, _ := getTagsWithNotes(cso.DB, testCacheSession)
 	require.Len(t, twn, 0)
-	require.NoError(t, cso.DB.Close())
+	require.NoError(t, store.Close())
 }
 
 func TestRemoveAndCheckRemovedOne(t *testing.T) {
@@ -463,7 +450,7 @@ func TestRemoveAndCheckRemovedOne(t *testing.T) {
 	require.NoError(t, createTemporaryFiles(fwc))
 	// add items
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{gitConfigPath, awsConfigPath, acmeConfigPath}}
-	ao, err := Add(ai, true)
+	ao, err := Add(context.Background(), ai)
 	require.NoError(t, err)
 	// sync tag, .gitconfig, and acmeConfig should exist
 	require.Len(t, ao.PathsAdded, 3)
@@ -477,20 +464,16 @@ func TestRemoveAndCheckRemovedOne(t *testing.T) {
 	}
 
 	var ro RemoveOutput
-	ro, err = Remove(ri, true)
+	ro, err = Remove(context.Background(), ri)
 
 	require.NoError(t, err)
 	require.Equal(t, 2, ro.NotesRemoved)
 	require.Equal(t, 1, ro.TagsRemoved)
 	require.Equal(t, 0, ro.NotTracked)
-	var cso cache.SyncOutput
-	cso, err = cache.Sync(cache.SyncInput{
-		Session: testCacheSession,
-	})
+	store := NewSessionStore(testCacheSession)
+	twn, _, err := getTagsWithNotes(context.Background(), store)
 	require.NoError(t, err)
-
-	twn, _ := getTagsWithNotes(cso.DB, testCacheSession)
 	// sync tag and .gitconfig note should exist
 	require.Len(t, twn, 2)
-	require.NoError(t, cso.DB.Close())
+	require.NoError(t, store.Close())
 }
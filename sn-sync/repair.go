@@ -0,0 +1,165 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/ryanuber/columnize"
+)
+
+type RepairInput struct {
+	Session  *cache.Session
+	Home     string
+	Paths    []string
+	PageSize int
+	Options  Options
+	Debug    bool
+}
+
+type RepairOutput struct {
+	NoRepaired, NoSkipped int
+	Msg                   string
+}
+
+// Repair resets tracked notes that have been opened in an editor other than plain text, so they can be synced again.
+// Notes whose content has been rewritten by an editor are restored from the local file, if it exists.
+func Repair(ctx context.Context, ri RepairInput) (ro RepairOutput, err error) {
+	r := ri.Options.reporter()
+	defer func() { r.Finished(err) }()
+
+	r.PhaseStarted(loadPhase(ri.Session, ri.Options), 0)
+
+	store := remoteStore(ri.Options, ri.Session)
+
+	var twn tagsWithNotes
+
+	var allItems items.Items
+
+	twn, allItems, err = getTagsWithNotes(ctx, store)
+	if err != nil {
+		return
+	}
+
+	if err = checkNoteTagConflicts(twn); err != nil {
+		_ = store.Close()
+
+		return
+	}
+
+	var diffs []ItemDiff
+
+	diffs, err = compare(ctx, twn, ri.Home, ri.Paths, []string{}, ri.Options, ri.Debug)
+	if err != nil {
+		_ = store.Close()
+
+		return
+	}
+
+	var toRepair []ItemDiff
+
+	var results []string
+
+	toRepair, results, ro.NoSkipped = repairItems(diffs)
+
+	if len(toRepair) == 0 {
+		if err = store.Close(); err != nil {
+			return
+		}
+
+		ro.Msg = fmt.Sprint(bold("nothing to repair"))
+		if len(results) > 0 {
+			ro.Msg = fmt.Sprint(columnize.SimpleFormat(results))
+		}
+
+		return
+	}
+
+	components := getEditorComponents(allItems)
+
+	uuids := make([]string, len(toRepair))
+	for i := range toRepair {
+		uuids[i] = toRepair[i].remote.UUID
+	}
+
+	b := &batch{}
+
+	if err = addToDB(b, toRepair, ri.Options); err != nil {
+		_ = store.Close()
+
+		return
+	}
+
+	detached := detachEditors(components, uuids)
+	for i := range detached {
+		b.add(&detached[i])
+	}
+
+	// sync changes back to SN
+	var rejected []string
+
+	r.PhaseStarted(PhasePushing, 0)
+
+	rejected, err = pushChanges(ctx, store, b)
+	if err != nil {
+		return
+	}
+
+	results = append(results, rejectedResults(toRepair, rejected, "repair")...)
+
+	ro.NoRepaired = len(toRepair) - len(rejected)
+	ro.Msg = fmt.Sprint(columnize.SimpleFormat(results))
+
+	return ro, err
+}
+
+// repairItems resets the editor of notes that aren't plain text, restoring content rewritten by an
+// editor, or older than the local file, from the local file. Sync skips these notes, so a newer local
+// file must be pushed here or the next sync would pull the remote content over it.
+// Notes with flags set are skipped, as pushing them would lose the flags.
+func repairItems(diffs []ItemDiff) (toRepair []ItemDiff, results []string, noSkipped int) {
+	for _, d := range diffs {
+		if d.diff != notPlainText {
+			continue
+		}
+
+		if flags := d.flags.String(); flags != "" {
+			results = append(results, fmt.Sprintf("%s | %s | note is %s, which would be lost", bold(d.homeRelPath), yellow(skipped), flags))
+			noSkipped++
+
+			continue
+		}
+
+		resetEditor(&d.remote)
+
+		result := green("repaired")
+
+		switch {
+		case d.compared == localNewer:
+			d.remote.Content.SetText(d.local)
+			result += " | content restored from newer local file"
+		case d.markup && d.local != "":
+			d.remote.Content.SetText(d.local)
+			result += " | content restored from local file"
+		case d.markup:
+			result += " | " + yellow("content contains editor markup")
+		}
+
+		toRepair = append(toRepair, d)
+		results = append(results, fmt.Sprintf("%s | %s", bold(d.homeRelPath), result))
+	}
+
+	return toRepair, results, noSkipped
+}
+
+// getEditorComponents returns the editor components installed, from the items loaded from the store
+func getEditorComponents(its items.Items) (components items.Components) {
+	for _, item := range its {
+		if c, ok := item.(*items.Component); ok && c.Content.Area == editorArea {
+			components = append(components, *c)
+		}
+	}
+
+	return components
+}
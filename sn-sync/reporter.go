@@ -0,0 +1,276 @@
+package snsync
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/mattn/go-isatty"
+)
+
+// Phase is a stage of a command, reported to a Reporter as it starts
+type Phase string
+
+const (
+	// PhaseSyncing fetches the latest items from the store
+	PhaseSyncing Phase = "syncing"
+	// PhaseInitializing fetches every item, as nothing has been synced before
+	PhaseInitializing Phase = "initializing"
+	// PhaseLoading loads the items last synced, without connecting to the server
+	PhaseLoading Phase = "loading"
+	// PhaseComparing compares tracked notes with local files
+	PhaseComparing Phase = "comparing"
+	// PhasePulling writes notes to local files
+	PhasePulling Phase = "pulling"
+	// PhasePushing pushes changes to the store
+	PhasePushing Phase = "pushing"
+	// PhaseConfirming waits for the user to confirm changes, so anything drawn on the terminal should be cleared
+	PhaseConfirming Phase = "confirming"
+)
+
+// progressBarWidth is the number of characters filled by a complete progress bar
+const progressBarWidth = 30
+
+// Reporter receives the progress of a command, such as to show it on a terminal. Its methods are called from
+// the goroutine running the command.
+type Reporter interface {
+	// PhaseStarted is called as each phase starts. total is the number of items it handles, or 0 if unknown.
+	PhaseStarted(phase Phase, total int)
+	// ItemCompared is called as each path is compared with its note, with the result, e.g. "local newer"
+	ItemCompared(path, status string)
+	// ItemPushed is called for each path whose change was pushed
+	ItemPushed(path string)
+	// ItemPulled is called for each path written from its note
+	ItemPulled(path string)
+	// Error is called for a path that failed without stopping the command
+	Error(path string, err error)
+	// Finished is called once the command ends, with its error if it failed
+	Finished(err error)
+}
+
+// NewReporter returns the Reporter to show progress on w: nothing if quiet, plain lines if w isn't a terminal,
+// such as when output is logged, and otherwise a spinner, or a progress bar with the time remaining if progressBar
+// is set
+func NewReporter(w io.Writer, quiet, progressBar bool) Reporter {
+	switch {
+	case quiet:
+		return NewSilentReporter()
+	case !isTerminal(w):
+		return NewLineReporter(w)
+	case progressBar:
+		return NewProgressReporter(w)
+	default:
+		return NewSpinnerReporter(w)
+	}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+
+	return ok && (isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd()))
+}
+
+// reporter returns the Reporter set in the options, or one that reports nothing
+func (o Options) reporter() Reporter {
+	if o.Reporter == nil {
+		return NewSilentReporter()
+	}
+
+	return o.Reporter
+}
+
+// loadPhase returns the phase reported while a command loads its items
+func loadPhase(session *cache.Session, opts Options) Phase {
+	if opts.Offline && opts.Store == nil {
+		return PhaseLoading
+	}
+
+	if _, err := os.Stat(session.CacheDBPath); os.IsNotExist(err) {
+		return PhaseInitializing
+	}
+
+	return PhaseSyncing
+}
+
+// silentReporter reports nothing
+type silentReporter struct{}
+
+// NewSilentReporter returns a Reporter that reports nothing
+func NewSilentReporter() Reporter {
+	return silentReporter{}
+}
+
+func (silentReporter) PhaseStarted(Phase, int)     {}
+func (silentReporter) ItemCompared(string, string) {}
+func (silentReporter) ItemPushed(string)           {}
+func (silentReporter) ItemPulled(string)           {}
+func (silentReporter) Error(string, error)         {}
+func (silentReporter) Finished(error)              {}
+
+// spinnerReporter shows a spinner prefixed with the current phase
+type spinnerReporter struct {
+	silentReporter
+	s *spinner.Spinner
+}
+
+// NewSpinnerReporter returns a Reporter that shows a spinner with the current phase on w, until the command finishes
+func NewSpinnerReporter(w io.Writer) Reporter {
+	return &spinnerReporter{s: spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(w))}
+}
+
+func (r *spinnerReporter) PhaseStarted(phase Phase, _ int) {
+	if phase == PhaseConfirming {
+		r.s.Stop()
+
+		return
+	}
+
+	r.s.Lock()
+	r.s.Prefix = HiWhite(string(phase) + " ")
+	r.s.Unlock()
+
+	if !r.s.Active() {
+		r.s.Start()
+	}
+}
+
+func (r *spinnerReporter) Finished(error) {
+	r.s.Stop()
+}
+
+// lineReporter writes each event as a line, for output that isn't a terminal
+type lineReporter struct {
+	w io.Writer
+}
+
+// NewLineReporter returns a Reporter that writes a line to w as each phase starts and each path changes
+func NewLineReporter(w io.Writer) Reporter {
+	return &lineReporter{w: w}
+}
+
+func (r *lineReporter) PhaseStarted(phase Phase, total int) {
+	switch {
+	case phase == PhaseConfirming:
+		return
+	case total > 0:
+		_, _ = fmt.Fprintf(r.w, "%s %d items\n", phase, total)
+	default:
+		_, _ = fmt.Fprintln(r.w, phase)
+	}
+}
+
+// ItemCompared writes paths that differ, as identical paths would drown out the rest
+func (r *lineReporter) ItemCompared(path, status string) {
+	if status != identical {
+		_, _ = fmt.Fprintf(r.w, "%s: %s\n", addDot(path), status)
+	}
+}
+
+func (r *lineReporter) ItemPushed(path string) {
+	_, _ = fmt.Fprintf(r.w, "pushed %s\n", addDot(path))
+}
+
+func (r *lineReporter) ItemPulled(path string) {
+	_, _ = fmt.Fprintf(r.w, "pulled %s\n", addDot(path))
+}
+
+func (r *lineReporter) Error(path string, err error) {
+	_, _ = fmt.Fprintf(r.w, "error: %s: %v\n", addDot(path), err)
+}
+
+func (r *lineReporter) Finished(error) {}
+
+// progressReporter draws a progress bar for the current phase, with the time remaining estimated from the
+// time taken so far
+type progressReporter struct {
+	w           io.Writer
+	phase       Phase
+	total, done int
+	started     time.Time
+	// drawn is the length of the line last drawn, so it can be cleared
+	drawn int
+	now   func() time.Time
+}
+
+// NewProgressReporter returns a Reporter that draws a progress bar and estimated time remaining on w
+func NewProgressReporter(w io.Writer) Reporter {
+	return &progressReporter{w: w, now: time.Now}
+}
+
+func (r *progressReporter) PhaseStarted(phase Phase, total int) {
+	r.clear()
+
+	if phase == PhaseConfirming {
+		r.phase = ""
+
+		return
+	}
+
+	r.phase, r.total, r.done, r.started = phase, total, 0, r.now()
+	r.draw()
+}
+
+func (r *progressReporter) ItemCompared(string, string) { r.advance() }
+func (r *progressReporter) ItemPushed(string)           { r.advance() }
+func (r *progressReporter) ItemPulled(string)           { r.advance() }
+func (r *progressReporter) Error(string, error)         {}
+
+func (r *progressReporter) Finished(error) {
+	r.clear()
+}
+
+func (r *progressReporter) advance() {
+	if r.phase == "" {
+		return
+	}
+
+	r.done++
+	r.draw()
+}
+
+func (r *progressReporter) draw() {
+	line := r.line()
+
+	// pad over the end of a longer line drawn before
+	pad := ""
+	if r.drawn > len(line) {
+		pad = strings.Repeat(" ", r.drawn-len(line))
+	}
+
+	_, _ = fmt.Fprintf(r.w, "\r%s%s", line, pad)
+	r.drawn = len(line)
+}
+
+// line returns the progress bar for the phase, or just its name if the number of items is unknown
+func (r *progressReporter) line() string {
+	if r.total <= 0 {
+		return string(r.phase) + "..."
+	}
+
+	done := min(r.done, r.total)
+	filled := done * progressBarWidth / r.total
+
+	line := fmt.Sprintf("%s [%s%s] %d/%d", r.phase, strings.Repeat("=", filled),
+		strings.Repeat(" ", progressBarWidth-filled), done, r.total)
+
+	if done > 0 && done < r.total {
+		elapsed := r.now().Sub(r.started)
+		remaining := elapsed / time.Duration(done) * time.Duration(r.total-done)
+		line += fmt.Sprintf(" ETA %s", remaining.Round(time.Second))
+	}
+
+	return line
+}
+
+func (r *progressReporter) clear() {
+	if r.drawn == 0 {
+		return
+	}
+
+	_, _ = fmt.Fprintf(r.w, "\r%s\r", strings.Repeat(" ", r.drawn))
+	r.drawn = 0
+}
@@ -0,0 +1,149 @@
+package snsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingReporter records each event as a line
+type recordingReporter struct {
+	events []string
+}
+
+func (r *recordingReporter) PhaseStarted(phase Phase, total int) {
+	r.events = append(r.events, fmt.Sprintf("%s %d", phase, total))
+}
+
+func (r *recordingReporter) ItemCompared(path, status string) {
+	r.events = append(r.events, fmt.Sprintf("compared %s: %s", path, status))
+}
+
+func (r *recordingReporter) ItemPushed(path string) {
+	r.events = append(r.events, "pushed "+path)
+}
+
+func (r *recordingReporter) ItemPulled(path string) {
+	r.events = append(r.events, "pulled "+path)
+}
+
+func (r *recordingReporter) Error(path string, err error) {
+	r.events = append(r.events, fmt.Sprintf("error %s: %v", path, err))
+}
+
+func (r *recordingReporter) Finished(err error) {
+	r.events = append(r.events, fmt.Sprintf("finished %v", err))
+}
+
+func TestReporterStatusEvents(t *testing.T) {
+	home := getTemporaryHome()
+	fwc := make(map[string]string)
+	fwc[fmt.Sprintf("%s/.gitconfig", home)] = "git config content"
+	fwc[fmt.Sprintf("%s/.fruit/apple", home)] = "apple content"
+	require.NoError(t, createTemporaryFiles(fwc))
+
+	r := &recordingReporter{}
+	_, _, err := status(context.Background(), testStatusSetup(), home, nil, Options{Reporter: r}, true)
+	require.NoError(t, err)
+
+	require.NotEmpty(t, r.events)
+	assert.Equal(t, "comparing 6", r.events[0])
+	assert.Contains(t, r.events, "compared .gitconfig: identical")
+	assert.Contains(t, r.events, "compared .fruit/apple: identical")
+	assert.Contains(t, r.events, "compared .fruit/lemon: local missing")
+	assert.Len(t, r.events, 7)
+}
+
+func TestReporterAddAndSyncEvents(t *testing.T) {
+	defer func() {
+		if err := CleanUp(*testCacheSession); err != nil {
+			fmt.Println("failed to wipe")
+		}
+	}()
+
+	home := getTemporaryHome()
+	applePath := fmt.Sprintf("%s/.apple", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{applePath: "apple content"}))
+
+	r := &recordingReporter{}
+	_, err := Add(context.Background(), AddInput{Session: testCacheSession, Home: home, Paths: []string{applePath},
+		Options: Options{Reporter: r}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"initializing 0", "pushing 1", "pushed .apple", "finished <nil>"}, r.events)
+
+	// delete local file so the sync pulls it back
+	require.NoError(t, os.Remove(applePath))
+
+	r = &recordingReporter{}
+	_, err = Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Options: Options{Reporter: r}})
+	require.NoError(t, err)
+	assert.Equal(t, "syncing 0", r.events[0])
+	assert.Contains(t, r.events, "compared .apple: local missing")
+	assert.Contains(t, r.events, "pulling 1")
+	assert.Contains(t, r.events, "pulled .apple")
+	assert.NotContains(t, r.events, "pushing 0")
+	assert.Equal(t, "finished <nil>", r.events[len(r.events)-1])
+}
+
+func TestProgressReporter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+
+	r := &progressReporter{w: &buf, now: func() time.Time { return now }}
+
+	r.PhaseStarted(PhasePushing, 4)
+	assert.Equal(t, "pushing [                              ] 0/4", r.line())
+
+	now = now.Add(10 * time.Second)
+	r.ItemPushed("a")
+	assert.Equal(t, "pushing [=======                       ] 1/4 ETA 30s", r.line())
+
+	r.ItemPushed("b")
+	r.ItemPushed("c")
+	r.ItemPushed("d")
+	assert.Equal(t, "pushing [==============================] 4/4", r.line())
+
+	// phases with an unknown number of items show only their name
+	r.PhaseStarted(PhaseSyncing, 0)
+	assert.Equal(t, "syncing...", r.line())
+
+	// the line is cleared once finished
+	buf.Reset()
+	r.Finished(nil)
+	assert.Equal(t, "\r          \r", buf.String())
+	assert.Zero(t, r.drawn)
+}
+
+func TestLineReporter(t *testing.T) {
+	var buf bytes.Buffer
+
+	r := NewLineReporter(&buf)
+	r.PhaseStarted(PhaseComparing, 2)
+	r.ItemCompared(".apple", identical)
+	r.ItemCompared(".banana", localNewer)
+	r.PhaseStarted(PhaseConfirming, 0)
+	r.PhaseStarted(PhasePushing, 0)
+	r.ItemPushed(".banana")
+	r.Error(".cherry", errors.New("permission denied"))
+	r.Finished(nil)
+
+	assert.Equal(t, "comparing 2 items\n.banana: local newer\npushing\npushed .banana\nerror: .cherry: permission denied\n",
+		buf.String())
+}
+
+func TestNewReporter(t *testing.T) {
+	var buf bytes.Buffer
+
+	assert.IsType(t, silentReporter{}, NewReporter(&buf, true, false))
+	// output that isn't a terminal gets plain lines, even if a progress bar is asked for
+	assert.IsType(t, &lineReporter{}, NewReporter(&buf, false, true))
+	assert.IsType(t, silentReporter{}, Options{}.reporter())
+}
@@ -0,0 +1,155 @@
+package snsync
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// SecretFinding describes content that looks like a private key or credential
+type SecretFinding struct {
+	Line int
+	Kind string
+}
+
+func (f SecretFinding) String() string {
+	return fmt.Sprintf("%s on line %d", f.Kind, f.Line)
+}
+
+var secretPatterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"private key", regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{"aws access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"aws secret key", regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]\s*\S+`)},
+	{"github token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"gitlab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"npm token", regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`)},
+	{"google api key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"stripe key", regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{24,}\b`)},
+}
+
+// SecretAllowMarker on a line stops it being reported as a secret, e.g. "# sn-sync:allow-secret"
+const SecretAllowMarker = "sn-sync:allow-secret"
+
+var (
+	entropyCandidateRegex = regexp.MustCompile(`[A-Za-z0-9+/=_-]{20,}`)
+	hexRegex              = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	// integrityRegex matches subresource integrity hashes, as found in lockfiles
+	integrityRegex = regexp.MustCompile(`^sha(1|256|384|512)-`)
+	// netrcMachineRegex matches the start of a netrc entry, after which password tokens are secrets
+	netrcMachineRegex  = regexp.MustCompile(`(?:^|\s)(machine\s+\S+|default)(?:\s|$)`)
+	netrcPasswordRegex = regexp.MustCompile(`(?:^|\s)password\s+\S+`)
+	hasDigitRegex      = regexp.MustCompile(`[0-9]`)
+	hasLetterRegex     = regexp.MustCompile(`[A-Za-z]`)
+)
+
+// shannonEntropy returns the number of bits of entropy per character of s
+func shannonEntropy(s string) (entropy float64) {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+
+	l := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / l
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+func isHighEntropy(s string) bool {
+	// random looking strings mix letters and digits
+	if !hasDigitRegex.MatchString(s) || !hasLetterRegex.MatchString(s) {
+		return false
+	}
+
+	// hex strings are far more often commit ids and checksums than secrets
+	if hexRegex.MatchString(s) || integrityRegex.MatchString(s) {
+		return false
+	}
+
+	return shannonEntropy(s) >= 4.0
+}
+
+// scanForSecrets returns anything in content that looks like a private key, a token in a
+// common format, a netrc password or a high entropy string. Secret placeholders and lines with
+// SecretAllowMarker are never reported.
+func scanForSecrets(content string) (findings []SecretFinding) {
+	var netrc bool
+
+	for i, line := range strings.Split(content, "\n") {
+		line = secretPlaceholderRegex.ReplaceAllString(line, "")
+
+		if strings.Contains(line, SecretAllowMarker) {
+			continue
+		}
+
+		// a password is only a netrc token once an entry has started, rather than any line mentioning one
+		netrc = netrc || netrcMachineRegex.MatchString(line)
+		if netrc && netrcPasswordRegex.MatchString(line) {
+			findings = append(findings, SecretFinding{Line: i + 1, Kind: "netrc password"})
+
+			continue
+		}
+
+		var found bool
+
+		for _, p := range secretPatterns {
+			if p.re.MatchString(line) {
+				findings = append(findings, SecretFinding{Line: i + 1, Kind: p.kind})
+				found = true
+
+				break
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		for _, candidate := range entropyCandidateRegex.FindAllString(line, -1) {
+			if isHighEntropy(candidate) {
+				findings = append(findings, SecretFinding{Line: i + 1, Kind: "high entropy string"})
+
+				break
+			}
+		}
+	}
+
+	return findings
+}
+
+// checkForSecrets returns an error if content about to be pushed looks like it contains secrets,
+// unless the path is allow-listed or the push is confirmed
+func checkForSecrets(homeRelPath, content string, opts Options) error {
+	if opts.SecretScan.Disabled || matchesPathGlobs(homeRelPath, opts.SecretScan.Allow) {
+		return nil
+	}
+
+	// content that's encrypted before it's pushed can contain anything
+	if opts.Encryption.encrypts(homeRelPath) {
+		return nil
+	}
+
+	findings := scanForSecrets(content)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	if opts.SecretScan.Confirm != nil && opts.SecretScan.Confirm(homeRelPath, findings) {
+		return nil
+	}
+
+	desc := make([]string, len(findings))
+	for i := range findings {
+		desc[i] = findings[i].String()
+	}
+
+	return fmt.Errorf("possible secrets: %s", strings.Join(desc, ", "))
+}
@@ -0,0 +1,224 @@
+package snsync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultSecretEnvPrefix defines the prefix of environment variables that hold secret values,
+// e.g. the value for {{secret "npm_token"}} is read from SN_SECRET_NPM_TOKEN
+const DefaultSecretEnvPrefix = "SN_SECRET_"
+
+// minSecretLength is the shortest value redacted from a file without a placeholder for it, so that values such as
+// "1" or "true" don't rewrite unrelated text
+const minSecretLength = 8
+
+var (
+	secretPlaceholderRegex = regexp.MustCompile(`\{\{\s*secret\s+"([^"]+)"\s*}}`)
+	secretEnvInvalidChars  = regexp.MustCompile("[^A-Z0-9]")
+)
+
+// SecretSources defines where the values of {{secret "name"}} placeholders are looked up.
+// Sources are tried in order: environment, file, then command.
+type SecretSources struct {
+	// EnvPrefix is prepended to the upper-cased secret name to get the environment variable name
+	EnvPrefix string
+	// File is the path to a file containing name=value lines
+	File string
+	// Command is run with the secret name appended as the final argument, e.g. "pass show"
+	Command string
+}
+
+func secretPlaceholder(name string) string {
+	return fmt.Sprintf(`{{secret "%s"}}`, name)
+}
+
+func secretEnvName(prefix, name string) string {
+	return prefix + secretEnvInvalidChars.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
+func placeholderNames(text string) (names []string) {
+	for _, m := range secretPlaceholderRegex.FindAllStringSubmatch(text, -1) {
+		names = append(names, m[1])
+	}
+
+	return dedupe(names)
+}
+
+func readSecretsFile(path string) (secrets map[string]string, err error) {
+	secrets = make(map[string]string)
+
+	var file *os.File
+
+	file, err = os.Open(path)
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+
+		secrets[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return secrets, scanner.Err()
+}
+
+// lookup returns the value of the named secret from the first source that provides it
+func (s *SecretSources) lookup(name string) (value string, found bool, err error) {
+	if s.EnvPrefix != "" {
+		if value, found = os.LookupEnv(secretEnvName(s.EnvPrefix, name)); found {
+			return
+		}
+	}
+
+	if s.File != "" {
+		var secrets map[string]string
+
+		secrets, err = readSecretsFile(s.File)
+		if err != nil {
+			return
+		}
+
+		if value, found = secrets[name]; found {
+			return
+		}
+	}
+
+	if s.Command != "" {
+		args := append(strings.Fields(s.Command), name)
+
+		var out []byte
+
+		out, err = exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			return "", false, fmt.Errorf("secret command failed for %q: %w", name, err)
+		}
+
+		// only the first line is used, as with 'pass show'
+		value, _, _ = strings.Cut(string(out), "\n")
+
+		return value, value != "", nil
+	}
+
+	return "", false, nil
+}
+
+// known returns the secrets that can be enumerated without being named, i.e. prefixed environment variables, by
+// the name following the prefix as written, and entries in the secrets file
+func (s *SecretSources) known() (secrets map[string]string, err error) {
+	secrets = make(map[string]string)
+
+	if s.File != "" {
+		secrets, err = readSecretsFile(s.File)
+		if err != nil {
+			return
+		}
+	}
+
+	if s.EnvPrefix != "" {
+		for _, e := range os.Environ() {
+			k, v, _ := strings.Cut(e, "=")
+			if strings.HasPrefix(k, s.EnvPrefix) && len(k) > len(s.EnvPrefix) {
+				secrets[k[len(s.EnvPrefix):]] = v
+			}
+		}
+	}
+
+	return secrets, err
+}
+
+// resolveSecrets replaces each placeholder in text with its secret value
+func resolveSecrets(text string, s *SecretSources) (string, error) {
+	if s == nil {
+		return text, nil
+	}
+
+	for _, name := range placeholderNames(text) {
+		value, found, err := s.lookup(name)
+		if err != nil {
+			return "", err
+		}
+
+		if !found {
+			return "", fmt.Errorf("secret %q not found", name)
+		}
+
+		text = regexp.MustCompile(`\{\{\s*secret\s+"`+regexp.QuoteMeta(name)+`"\s*}}`).ReplaceAllLiteralString(text, value)
+	}
+
+	return text, nil
+}
+
+// redactSecrets replaces secret values found in text with their placeholders so that resolved secrets are never
+// pushed. Secrets with a placeholder in the existing remote text are redacted by the name declared there, including
+// those only available via the command. Other known secrets are only redacted if at least minSecretLength long.
+func redactSecrets(text, remoteText string, s *SecretSources) (string, error) {
+	if s == nil {
+		return text, nil
+	}
+
+	secrets := make(map[string]string)
+	declared := make(map[string]bool)
+
+	for _, name := range placeholderNames(remoteText) {
+		value, found, err := s.lookup(name)
+		if err != nil {
+			return "", err
+		}
+
+		if found && value != "" {
+			secrets[name] = value
+			declared[value] = true
+		}
+	}
+
+	known, err := s.known()
+	if err != nil {
+		return "", err
+	}
+
+	for name, value := range known {
+		if len(value) >= minSecretLength && !declared[value] {
+			if _, ok := secrets[name]; !ok {
+				secrets[name] = value
+			}
+		}
+	}
+
+	// replace longest values first so a secret containing another is redacted whole
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if len(secrets[names[i]]) != len(secrets[names[j]]) {
+			return len(secrets[names[i]]) > len(secrets[names[j]])
+		}
+
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		text = strings.ReplaceAll(text, secrets[name], secretPlaceholder(name))
+	}
+
+	return text, nil
+}
@@ -0,0 +1,104 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecretsFromEnv(t *testing.T) {
+	t.Setenv("SN_SECRET_NPM_TOKEN", "abc123")
+
+	res, err := resolveSecrets(`//registry.npmjs.org/:_authToken={{secret "npm_token"}}`, &SecretSources{EnvPrefix: DefaultSecretEnvPrefix})
+	assert.NoError(t, err)
+	assert.Equal(t, "//registry.npmjs.org/:_authToken=abc123", res)
+}
+
+func TestResolveSecretsFromFile(t *testing.T) {
+	home := getTemporaryHome()
+	secretsPath := fmt.Sprintf("%s/secrets", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{secretsPath: "# comment\npypi = pypi-xyz\n"}))
+
+	res, err := resolveSecrets(`password = {{ secret "pypi" }}`, &SecretSources{File: secretsPath})
+	assert.NoError(t, err)
+	assert.Equal(t, "password = pypi-xyz", res)
+}
+
+func TestResolveSecretsFromCommand(t *testing.T) {
+	res, err := resolveSecrets(`token={{secret "first"}}`, &SecretSources{Command: "echo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "token=first", res)
+}
+
+func TestResolveSecretsMissing(t *testing.T) {
+	_, err := resolveSecrets(`token={{secret "missing"}}`, &SecretSources{EnvPrefix: DefaultSecretEnvPrefix})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing")
+
+	// no sources leaves content untouched
+	res, err := resolveSecrets(`token={{secret "missing"}}`, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `token={{secret "missing"}}`, res)
+}
+
+func TestRedactSecrets(t *testing.T) {
+	t.Setenv("SN_SECRET_NPM_TOKEN", "npm-abc123")
+	t.Setenv("SN_SECRET_DEBUG", "1")
+
+	s := &SecretSources{EnvPrefix: DefaultSecretEnvPrefix, Command: "echo"}
+
+	// environment secrets are redacted without a placeholder in the remote, by the name as declared
+	res, err := redactSecrets("_authToken=npm-abc123\nretries=1", "", s)
+	assert.NoError(t, err)
+	assert.Equal(t, "_authToken={{secret \"NPM_TOKEN\"}}\nretries=1", res)
+
+	// a placeholder in the remote keeps its name
+	res, err = redactSecrets("_authToken=npm-abc123", `_authToken={{secret "npm_token"}}`, s)
+	assert.NoError(t, err)
+	assert.Equal(t, `_authToken={{secret "npm_token"}}`, res)
+
+	// short values are only redacted if the remote references them
+	res, err = redactSecrets("debug=1", `debug={{secret "debug"}}`, s)
+	assert.NoError(t, err)
+	assert.Equal(t, `debug={{secret "debug"}}`, res)
+
+	// command secrets are only redacted if the remote references them
+	res, err = redactSecrets("user=deploy", "", s)
+	assert.NoError(t, err)
+	assert.Equal(t, "user=deploy", res)
+
+	res, err = redactSecrets("user=deploy", `user={{secret "deploy"}}`, s)
+	assert.NoError(t, err)
+	assert.Equal(t, `user={{secret "deploy"}}`, res)
+}
+
+func TestCompareWithSecrets(t *testing.T) {
+	t.Setenv("SN_SECRET_NPM_TOKEN", "abc123")
+
+	home := getTemporaryHome()
+	npmrcPath := fmt.Sprintf("%s/.npmrc", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{npmrcPath: "_authToken=abc123"}))
+
+	defer os.RemoveAll(home)
+
+	opts := Options{Secrets: &SecretSources{EnvPrefix: DefaultSecretEnvPrefix}}
+	note := createNote(".npmrc", `_authToken={{secret "npm_token"}}`)
+
+	iDiff, err := compareNoteWithFile(DotFilesTag, npmrcPath, home, note, opts, true)
+	require.NoError(t, err)
+	assert.Equal(t, identical, iDiff.diff)
+	assert.Equal(t, `_authToken={{secret "npm_token"}}`, iDiff.local)
+
+	// pulling writes the resolved value
+	require.NoError(t, os.Remove(npmrcPath))
+	_, err = createLocal(context.Background(), []ItemDiff{{path: npmrcPath, remote: note}}, home, opts)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(npmrcPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "_authToken=abc123", string(content))
+}
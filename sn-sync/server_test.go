@@ -0,0 +1,254 @@
+package snsync
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addToServer adds a file with the name and content to a temporary home and returns the home and path
+func addToServer(t *testing.T, name, content string) (home, path string) {
+	home = getTemporaryHome()
+	path = filepath.Join(home, name)
+	require.NoError(t, createTemporaryFiles(map[string]string{path: content}))
+
+	_, err := Add(context.Background(), AddInput{Session: testCacheSession, Home: home, Paths: []string{path}})
+	require.NoError(t, err)
+	require.Len(t, testServer.Items("Note"), 1)
+
+	return home, path
+}
+
+func statusOf(t *testing.T, home string) string {
+	diffs, _, err := Status(context.Background(), testCacheSession, home, nil, DefaultPageSize, Options{}, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+
+	return diffs[0].diff
+}
+
+func TestSyncConflictWithServer(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home, path := addToServer(t, ".apple", "apple content")
+	defer os.RemoveAll(home)
+
+	require.NoError(t, createPathWithContent(path, "apple content updated"))
+
+	// changed on another device while the push is made
+	uuid := testServer.Items("Note")[0].UUID
+	testServer.OnNextPush(func() { testServer.Touch(uuid) })
+
+	so, err := Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Debug: true})
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, 1, so.NoConflicted)
+	assert.Zero(t, so.NoPushed)
+	assert.Contains(t, so.Msg, "changed on another device while pushing")
+
+	// the copy gosn saved of the refused note is deleted
+	notes := testServer.Items("Note")
+	require.Len(t, notes, 1)
+	assert.Equal(t, uuid, notes[0].UUID)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "apple content updated", string(content))
+
+	// the cache holds the server's version
+	assert.Equal(t, remoteNewer, statusOf(t, home))
+}
+
+func TestSyncConflictRetriedWithServer(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home, path := addToServer(t, ".apple.json", `{"a":1}`)
+	defer os.RemoveAll(home)
+
+	// sync first, so the content last synced is known and the refused change can be merged
+	opts := Options{StateDir: t.TempDir()}
+	_, err := Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Options: opts, Debug: true})
+	require.NoError(t, err)
+
+	require.NoError(t, createPathWithContent(path, `{"a":2}`))
+
+	uuid := testServer.Items("Note")[0].UUID
+	testServer.OnNextPush(func() { testServer.Touch(uuid) })
+
+	so, err := Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Options: opts, Debug: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, so.NoPushed)
+	assert.Zero(t, so.NoConflicted)
+	assert.Contains(t, so.Msg, "retrying")
+	assert.Len(t, testServer.Items("Note"), 1)
+
+	assert.Equal(t, identical, statusOf(t, home))
+}
+
+func TestSyncPushFailsWithServer(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home, path := addToServer(t, ".apple", "apple content")
+	defer os.RemoveAll(home)
+
+	require.NoError(t, createPathWithContent(path, "apple content updated"))
+
+	testServer.FailPushes(1, http.StatusUnauthorized, false)
+
+	_, err := Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Debug: true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "changes not pushed")
+
+	// the cache was restored, so the change is still to be pushed
+	assert.Equal(t, localNewer, statusOf(t, home))
+
+	so, err := Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Debug: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, so.NoPushed)
+	assert.Equal(t, identical, statusOf(t, home))
+}
+
+func TestSyncPushSavedButFailedWithServer(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home, path := addToServer(t, ".apple", "apple content")
+	defer os.RemoveAll(home)
+
+	require.NoError(t, createPathWithContent(path, "apple content updated"))
+
+	// the server saves the push, but the response is lost
+	testServer.FailPushes(1, http.StatusUnauthorized, true)
+
+	_, err := Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Debug: true})
+	require.Error(t, err)
+
+	// the saved change is fetched on the next sync
+	assert.Equal(t, identical, statusOf(t, home))
+	assert.Len(t, testServer.Items("Note"), 1)
+}
+
+func TestSyncWriteFailsWithServer(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	apple := filepath.Join(home, ".apple")
+	npmrc := filepath.Join(home, ".npmrc")
+	require.NoError(t, createTemporaryFiles(map[string]string{
+		apple: "apple content",
+		npmrc: `token={{secret "missing"}}`,
+	}))
+
+	_, err := Add(context.Background(), AddInput{Session: testCacheSession, Home: home, Paths: []string{apple, npmrc}})
+	require.NoError(t, err)
+	require.Len(t, testServer.Items("Note"), 2)
+
+	// .npmrc is pulled but its secret can't be resolved, while .apple is pushed
+	require.NoError(t, os.Remove(npmrc))
+	require.NoError(t, createPathWithContent(apple, "apple content updated"))
+
+	opts := Options{Secrets: &SecretSources{EnvPrefix: DefaultSecretEnvPrefix}}
+	so, err := Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Options: opts, Debug: true})
+	assert.ErrorIs(t, err, ErrPathsFailed)
+	assert.Equal(t, 1, so.NoPushed)
+	assert.Zero(t, so.NoPulled)
+	assert.Equal(t, 1, so.NoFailed)
+	assert.Regexp(t, `\.apple\s+pushed`, so.Msg)
+	assert.Contains(t, so.Msg, `secret "missing" not found`)
+
+	assert.NoFileExists(t, npmrc)
+
+	// the push went through, so only .npmrc is left to pull
+	diffs, _, err := Status(context.Background(), testCacheSession, home, nil, DefaultPageSize, Options{}, true)
+	require.NoError(t, err)
+
+	got := make(map[string]string)
+	for _, d := range diffs {
+		got[d.homeRelPath] = d.diff
+	}
+
+	assert.Equal(t, map[string]string{".apple": identical, ".npmrc": localMissing}, got)
+}
+
+func TestSetEditorKeepsLocalEditsWithServer(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home, path := addToServer(t, ".bashrc", "alias ll='ls -l'")
+	defer os.RemoveAll(home)
+
+	// install the code editor
+	code := items.NewComponent()
+	code.Content = *items.NewComponentContent()
+	code.Content.Name = "Code Editor"
+	code.Content.Area = editorArea
+	code.Content.Identifier = CodeEditorIdentifier
+
+	store := NewSessionStore(testCacheSession)
+	_, err := store.Load(context.Background())
+	require.NoError(t, err)
+
+	b := &batch{}
+	b.add(&code)
+	_, err = pushChanges(context.Background(), store, b)
+	require.NoError(t, err)
+
+	require.NoError(t, createPathWithContent(path, "alias ll='ls -la'"))
+
+	opts := Options{Editors: map[string]EditorSetting{"*": {Editor: CodeEditorIdentifier, Mode: "shell"}}}
+	so, err := SetEditor(context.Background(), SetEditorInput{Session: testCacheSession, Home: home, Options: opts, Debug: true})
+	require.NoError(t, err)
+	assert.Zero(t, so.NoUpdated)
+	assert.Equal(t, 1, so.NoSkipped)
+	assert.Contains(t, so.Msg, "sync first")
+
+	_, err = Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Debug: true})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "alias ll='ls -la'", string(content))
+
+	// once synced the editor is set, and the edit is still kept
+	so, err = SetEditor(context.Background(), SetEditorInput{Session: testCacheSession, Home: home, Options: opts, Debug: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, so.NoUpdated)
+
+	_, err = Sync(context.Background(), SNDirSyncInput{Session: testCacheSession, Root: home, Debug: true})
+	require.NoError(t, err)
+
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "alias ll='ls -la'", string(content))
+	assert.Equal(t, identical, statusOf(t, home))
+}
+
+func TestVerifyWithServer(t *testing.T) {
+	defer func() { _ = CleanUp(*testCacheSession) }()
+
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	path := filepath.Join(home, ".ssh", "config")
+	require.NoError(t, createTemporaryFiles(map[string]string{path: "Host *\n"}))
+
+	opts := Options{Encryption: EncryptionOptions{Paths: []string{".ssh/*"}, Passphrase: []byte("secret")}}
+	_, err := Add(context.Background(), AddInput{Session: testCacheSession, Home: home, Paths: []string{path}, Options: opts})
+	require.NoError(t, err)
+
+	// only checksums are compared, so neither the passphrase nor the secrets command is needed
+	vo, err := Verify(context.Background(), VerifyInput{
+		Session: testCacheSession,
+		Home:    home,
+		Options: Options{Secrets: &SecretSources{Command: "false"}},
+		Debug:   true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, vo.NoVerified, vo.Msg)
+	assert.Regexp(t, `\.ssh/config\s+verified`, vo.Msg)
+}
@@ -0,0 +1,157 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/ryanuber/columnize"
+)
+
+type SetEditorInput struct {
+	Session  *cache.Session
+	Home     string
+	Paths    []string
+	PageSize int
+	Options  Options
+	Debug    bool
+}
+
+type SetEditorOutput struct {
+	NoUpdated, NoSkipped int
+	Msg                  string
+}
+
+// SetEditor sets tracked notes to open with the editor configured for their path
+func SetEditor(ctx context.Context, si SetEditorInput) (so SetEditorOutput, err error) {
+	r := si.Options.reporter()
+	defer func() { r.Finished(err) }()
+
+	if len(si.Options.Editors) == 0 {
+		return so, fmt.Errorf("no editors configured")
+	}
+
+	r.PhaseStarted(loadPhase(si.Session, si.Options), 0)
+
+	store := remoteStore(si.Options, si.Session)
+
+	var twn tagsWithNotes
+
+	var allItems items.Items
+
+	twn, allItems, err = getTagsWithNotes(ctx, store)
+	if err != nil {
+		return
+	}
+
+	if err = checkNoteTagConflicts(twn); err != nil {
+		_ = store.Close()
+
+		return
+	}
+
+	si.Options.editorComponents = getEditorComponents(allItems)
+
+	var diffs []ItemDiff
+
+	diffs, err = compare(ctx, twn, si.Home, si.Paths, []string{}, si.Options, si.Debug)
+	if err != nil {
+		_ = store.Close()
+
+		return
+	}
+
+	var toUpdate []ItemDiff
+
+	var results []string
+
+	toUpdate, results, so.NoSkipped = setEditorItems(diffs, si.Options)
+
+	var rejected []string
+
+	if len(toUpdate) == 0 {
+		if err = store.Close(); err != nil {
+			return
+		}
+	} else {
+		b := &batch{}
+
+		if err = addToDB(b, toUpdate, si.Options); err != nil {
+			_ = store.Close()
+
+			return
+		}
+
+		// sync changes back to SN
+		r.PhaseStarted(PhasePushing, 0)
+
+		rejected, err = pushChanges(ctx, store, b)
+		if err != nil {
+			return
+		}
+	}
+
+	results = append(results, rejectedResults(toUpdate, rejected, "set-editor")...)
+
+	so.NoUpdated = len(toUpdate) - len(rejected)
+
+	so.Msg = fmt.Sprint(bold("nothing to update"))
+	if len(results) > 0 {
+		so.Msg = fmt.Sprint(columnize.SimpleFormat(results))
+	}
+
+	return so, err
+}
+
+// setEditorItems applies the configured editor to each tracked note, returning those changed
+func setEditorItems(diffs []ItemDiff, opts Options) (toUpdate []ItemDiff, results []string, noSkipped int) {
+	for _, d := range diffs {
+		if d.diff == untracked || d.diff == invalidPath || d.diff == undecryptable {
+			continue
+		}
+
+		setting, found := editorFor(d.homeRelPath, opts.Editors)
+		if !found {
+			continue
+		}
+
+		var reason string
+
+		switch {
+		case d.diff == notPlainText:
+			reason = fmt.Sprintf("%s, use repair first", d.err)
+		case d.diff == localNewer:
+			// pushing the note would make it newer than the file, so the next sync would pull it over the edits
+			reason = "local file is newer, sync first"
+		case d.flags.String() != "":
+			reason = fmt.Sprintf("note is %s, which would be lost", d.flags)
+		}
+
+		if reason != "" {
+			results = append(results, fmt.Sprintf("%s | %s | %s", bold(d.homeRelPath), yellow(skipped), reason))
+			noSkipped++
+
+			continue
+		}
+
+		before := d.remote.Content
+
+		if err := applyEditor(&d.remote, setting, opts.editorComponents); err != nil {
+			results = append(results, fmt.Sprintf("%s | %s | %s", bold(d.homeRelPath), red(refused), err))
+			noSkipped++
+
+			continue
+		}
+
+		if reflect.DeepEqual(before, d.remote.Content) {
+			continue
+		}
+
+		toUpdate = append(toUpdate, d)
+		results = append(results, fmt.Sprintf("%s | %s | %s %s", bold(d.homeRelPath), green("updated"), setting.Editor, setting.Mode))
+	}
+
+	return toUpdate, results, noSkipped
+}
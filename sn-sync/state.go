@@ -0,0 +1,79 @@
+package snsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const baseDirName = "base"
+
+// StateDir returns the directory used to keep local sync state for the account using the cache db
+func StateDir(cacheDBPath string) string {
+	return strings.TrimSuffix(cacheDBPath, filepath.Ext(cacheDBPath)) + "-state"
+}
+
+// loadBase returns the content last synced for the note with the given uuid
+func loadBase(stateDir, uuid string) (content string, found bool, err error) {
+	if stateDir == "" || uuid == "" {
+		return
+	}
+
+	if err = validateNoteTitle(uuid); err != nil {
+		return
+	}
+
+	var b []byte
+
+	b, err = os.ReadFile(filepath.Join(stateDir, baseDirName, uuid))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	return string(b), true, nil
+}
+
+// saveBase records the content last synced for the note with the given uuid
+// so it can be used as the common ancestor when both sides later change
+func saveBase(stateDir, uuid, content string) error {
+	if stateDir == "" || uuid == "" {
+		return nil
+	}
+
+	existing, found, err := loadBase(stateDir, uuid)
+	if err != nil {
+		return err
+	}
+
+	if found && existing == content {
+		return nil
+	}
+
+	dir := filepath.Join(stateDir, baseDirName)
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, uuid), []byte(content), 0o600)
+}
+
+// removeBase removes the content last synced for the note with the given uuid
+func removeBase(stateDir, uuid string) error {
+	if stateDir == "" || uuid == "" {
+		return nil
+	}
+
+	if err := validateNoteTitle(uuid); err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(stateDir, baseDirName, uuid)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
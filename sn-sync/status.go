@@ -1,11 +1,9 @@
 package snsync
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"time"
 
-	"github.com/briandowns/spinner"
 	"github.com/jonhadfield/gosn-v2/cache"
 	"github.com/ryanuber/columnize"
 )
@@ -16,51 +14,41 @@ import (
 // - remote items that are newer
 // - local items that are untracked (if Paths specified)
 // - identical local and remote items
-func Status(session *cache.Session, home string, paths []string, pageSize int, debug bool, useStdErr bool) (diffs []ItemDiff, msg string, err error) {
+func Status(ctx context.Context, session *cache.Session, home string, paths []string, pageSize int, opts Options, debug bool) (diffs []ItemDiff, msg string, err error) {
+	r := opts.reporter()
+	defer func() { r.Finished(err) }()
+
 	// preflight checks
 	paths, err = preflight(home, paths)
 	if err != nil {
 		return
 	}
 
-	if !debug {
-		prefix := HiWhite("syncing ")
-		if _, err = os.Stat(session.CacheDBPath); os.IsNotExist(err) {
-			prefix = HiWhite("initializing ")
-		}
+	r.PhaseStarted(loadPhase(session, opts), 0)
 
-		s := spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stdout))
-		if useStdErr {
-			s = spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stderr))
-		}
+	// the cache db is written to when opened, so note when it was last synced first
+	warning := staleWarning(session, opts)
+	store := remoteStore(opts, session)
 
-		s.Prefix = prefix
-		s.Start()
-		defer s.Stop()
-	}
+	var remote tagsWithNotes
 
-	// get populated db
-	si := cache.SyncInput{
-		Session: session,
-		Close:   false,
-	}
-	var cso cache.SyncOutput
-	cso, err = cache.Sync(si)
+	remote, _, err = getTagsWithNotes(ctx, store)
 	if err != nil {
-		return
+		return diffs, msg, err
 	}
 
-	var remote tagsWithNotes
-
-	remote, err = getTagsWithNotes(cso.DB, session)
-	if err != nil {
+	// the store isn't needed to compare, so close it before walking the filesystem
+	if err = store.Close(); err != nil {
 		return diffs, msg, err
 	}
 
-	return status(remote, home, paths, debug)
+	diffs, msg, err = status(ctx, remote, home, paths, opts, debug)
+	msg = warning + msg
+
+	return diffs, msg, err
 }
 
-func status(twn tagsWithNotes, home string, paths []string, debug bool) (diffs []ItemDiff, msg string, err error) {
+func status(ctx context.Context, twn tagsWithNotes, home string, paths []string, opts Options, debug bool) (diffs []ItemDiff, msg string, err error) {
 	debugPrint(debug, fmt.Sprintf("status | %d remote items", len(twn)))
 
 	err = checkNoteTagConflicts(twn)
@@ -73,7 +61,7 @@ func status(twn tagsWithNotes, home string, paths []string, debug bool) (diffs [
 		return
 	}
 
-	diffs, err = compare(twn, home, paths, []string{}, debug)
+	diffs, err = compare(ctx, twn, home, paths, []string{}, opts, debug)
 	if err != nil {
 		return diffs, msg, err
 	}
@@ -84,13 +72,27 @@ func status(twn tagsWithNotes, home string, paths []string, debug bool) (diffs [
 		return diffs, msg, err
 	}
 
-	lines := make([]string, len(diffs))
+	results, failures := withoutFailures(diffs)
 
-	for i, diff := range diffs {
-		lines[i] = fmt.Sprintf("%s | %s \n", bold(diff.homeRelPath), colourDiff(diff.diff))
+	lines := make([]string, len(results))
+
+	for i, diff := range results {
+		line := fmt.Sprintf("%s | %s", bold(diff.homeRelPath), colourDiff(diff.diff))
+		if flags := diff.flags.String(); flags != "" {
+			line += fmt.Sprintf(" | %s", yellow(flags))
+		}
+
+		if diff.err != nil {
+			line += fmt.Sprintf(" | %s", diff.err)
+		}
+
+		lines[i] = line + " \n"
 	}
 
 	msg = columnize.SimpleFormat(lines)
 
+	summary, err := failureSummary(failures)
+	msg += summary
+
 	return diffs, msg, err
 }
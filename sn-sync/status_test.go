@@ -1,41 +1,42 @@
 package snsync
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
 	"time"
 
-	"github.com/jonhadfield/gosn-v2"
+	"github.com/jonhadfield/gosn-v2/items"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestStatusEmptyTWN(t *testing.T) {
 	home := getTemporaryHome()
-	_, msg, _ := status(tagsWithNotes{}, home, []string{}, true)
+	_, msg, _ := status(context.Background(), tagsWithNotes{}, home, []string{}, Options{}, true)
 	assert.Equal(t, "no sync being tracked", msg)
 }
 
 func testStatusSetup() (twn tagsWithNotes) {
-	syncTag := createTag("sync")
+	syncTag := mustCreateTag("sync")
 	gitconfigNote := createNote(".gitconfig", "git config content")
-	syncTagWithNote := tagWithNotes{tag: syncTag, notes: gosn.Notes{gitconfigNote}}
+	syncTagWithNote := tagWithNotes{tag: syncTag, notes: items.Notes{gitconfigNote}}
 
-	fruitTag := createTag("sync.fruit")
-	fruitBananaTag := createTag("sync.fruit.banana")
+	fruitTag := mustCreateTag("sync.fruit")
+	fruitBananaTag := mustCreateTag("sync.fruit.banana")
 	appleNote := createNote("apple", "apple content")
 	lemonNote := createNote("lemon", "lemon content")
 	grapeNote := createNote("grape", "grape content")
-	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: gosn.Notes{appleNote, lemonNote, grapeNote}}
+	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: items.Notes{appleNote, lemonNote, grapeNote}}
 
 	yellowNote := createNote("yellow", "yellow content")
-	fruitBananaTagWithNotes := tagWithNotes{tag: fruitBananaTag, notes: gosn.Notes{yellowNote}}
+	fruitBananaTagWithNotes := tagWithNotes{tag: fruitBananaTag, notes: items.Notes{yellowNote}}
 
 	premiumNote := createNote("premium", "premium content")
-	carsMercedesA250Tag := createTag("sync.cars.mercedes.a250")
-	carsMercedesA250TagWithNotes := tagWithNotes{tag: carsMercedesA250Tag, notes: gosn.Notes{premiumNote}}
+	carsMercedesA250Tag := mustCreateTag("sync.cars.mercedes.a250")
+	carsMercedesA250TagWithNotes := tagWithNotes{tag: carsMercedesA250Tag, notes: items.Notes{premiumNote}}
 
 	twn = tagsWithNotes{syncTagWithNote, fruitTagWithNotes, fruitBananaTagWithNotes, carsMercedesA250TagWithNotes}
 	return
@@ -60,7 +61,7 @@ func TestStatus(t *testing.T) {
 	var diffs []ItemDiff
 	var err error
 
-	diffs, _, err = status(twn, home, []string{gitConfigPath, applePath, yellowPath, premiumPath}, true)
+	diffs, _, err = status(context.Background(), twn, home, []string{gitConfigPath, applePath, yellowPath, premiumPath}, Options{}, true)
 	assert.NoError(t, err)
 	assert.Len(t, diffs, 4)
 	var pDiff int
@@ -102,17 +103,17 @@ func TestStatus1(t *testing.T) {
 
 	assert.NoError(t, createTemporaryFiles(fwc))
 
-	syncTag := createTag("sync")
+	syncTag := mustCreateTag("sync")
 	gitconfigNote := createNote(".gitconfig", "git config content")
-	syncTagWithNote := tagWithNotes{tag: syncTag, notes: gosn.Notes{gitconfigNote}}
+	syncTagWithNote := tagWithNotes{tag: syncTag, notes: items.Notes{gitconfigNote}}
 
-	awsTag := createTag("sync.aws")
+	awsTag := mustCreateTag("sync.aws")
 	awsConfigNote := createNote("config", "aws config content")
-	awsTagWithNotes := tagWithNotes{tag: awsTag, notes: gosn.Notes{awsConfigNote}}
+	awsTagWithNotes := tagWithNotes{tag: awsTag, notes: items.Notes{awsConfigNote}}
 
 	twn := tagsWithNotes{syncTagWithNote, awsTagWithNotes}
 
-	diffs, _, err := status(twn, home, []string{gitConfigPath}, true)
+	diffs, _, err := status(context.Background(), twn, home, []string{gitConfigPath}, Options{}, true)
 	assert.NoError(t, err)
 	assert.Len(t, diffs, 1)
 	assert.Equal(t, ".gitconfig", diffs[0].noteTitle)
@@ -135,21 +136,21 @@ func TestStatus2(t *testing.T) {
 	fwc[premiumPath] = "premium content"
 	assert.NoError(t, createTemporaryFiles(fwc))
 
-	syncTag := createTag("sync")
+	syncTag := mustCreateTag("sync")
 	gitconfigNote := createNote(".gitconfig", "git config content")
-	syncTagWithNote := tagWithNotes{tag: syncTag, notes: gosn.Notes{gitconfigNote}}
+	syncTagWithNote := tagWithNotes{tag: syncTag, notes: items.Notes{gitconfigNote}}
 
-	fruitTag := createTag("sync.fruit")
-	fruitBananaTag := createTag("sync.fruit.banana")
+	fruitTag := mustCreateTag("sync.fruit")
+	fruitBananaTag := mustCreateTag("sync.fruit.banana")
 	appleNote := createNote("apple", "apple content")
-	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: gosn.Notes{appleNote}}
+	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: items.Notes{appleNote}}
 
 	yellowNote := createNote("yellow", "yellow content")
-	fruitBananaTagWithNotes := tagWithNotes{tag: fruitBananaTag, notes: gosn.Notes{yellowNote}}
+	fruitBananaTagWithNotes := tagWithNotes{tag: fruitBananaTag, notes: items.Notes{yellowNote}}
 
 	premiumNote := createNote("premium", "premium content")
-	carsMercedesA250Tag := createTag("sync.cars.mercedes.a250")
-	carsMercedesA250TagWithNotes := tagWithNotes{tag: carsMercedesA250Tag, notes: gosn.Notes{premiumNote}}
+	carsMercedesA250Tag := mustCreateTag("sync.cars.mercedes.a250")
+	carsMercedesA250TagWithNotes := tagWithNotes{tag: carsMercedesA250Tag, notes: items.Notes{premiumNote}}
 
 	twn := tagsWithNotes{syncTagWithNote, fruitTagWithNotes, fruitBananaTagWithNotes, carsMercedesA250TagWithNotes}
 
@@ -173,12 +174,12 @@ func TestStatus2(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	// update premium remote to trigger remote newer condition
 	newPremiumNote := createNote("premium", "new content")
-	newCarsMercedesA250TagWithNotes := tagWithNotes{tag: carsMercedesA250Tag, notes: gosn.Notes{newPremiumNote}}
+	newCarsMercedesA250TagWithNotes := tagWithNotes{tag: carsMercedesA250Tag, notes: items.Notes{newPremiumNote}}
 	twn = tagsWithNotes{syncTagWithNote, fruitTagWithNotes, fruitBananaTagWithNotes, newCarsMercedesA250TagWithNotes}
 
 	var diffs []ItemDiff
 
-	diffs, _, err = status(twn, home, []string{fmt.Sprintf("%s/.fruit", home), fmt.Sprintf("%s/.cars", home)}, true)
+	diffs, _, err = status(context.Background(), twn, home, []string{fmt.Sprintf("%s/.fruit", home), fmt.Sprintf("%s/.cars", home)}, Options{}, true)
 	assert.NoError(t, err)
 	assert.Len(t, diffs, 4)
 	var pDiff int
@@ -0,0 +1,681 @@
+package snsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/common"
+	"github.com/jonhadfield/gosn-v2/items"
+)
+
+// RemoteStore is where tracked notes and tags are kept, such as a Standard Notes account.
+// Commands load the items, compare them with local files and then push their changes as one batch.
+type RemoteStore interface {
+	// Load fetches the latest items and returns the notes, tags and components, without those deleted.
+	// The store stays open until the changes are pushed or it's closed.
+	Load(ctx context.Context) (items.Items, error)
+	// Push saves the items, including any marked deleted, as one batch and closes the store. The uuids of
+	// items refused, as they'd been changed elsewhere since they were loaded, are returned.
+	Push(ctx context.Context, its items.Items) (rejected []string, err error)
+	// Delete marks the items deleted and pushes them as Push does
+	Delete(ctx context.Context, its items.Items) (rejected []string, err error)
+	// Close releases the store without pushing; it does nothing if the store isn't open
+	Close() error
+}
+
+// noteFlagReader is implemented by stores that can read note flags, such as archived, that items.Note doesn't expose
+type noteFlagReader interface {
+	noteFlags(uuids map[string]bool) (map[string]noteFlags, error)
+}
+
+// NewSessionStore returns a RemoteStore for the Standard Notes account of the session, using its cache db
+func NewSessionStore(session *cache.Session) RemoteStore {
+	return &sessionStore{session: session}
+}
+
+// remoteStore returns the store set in the options, or one for the Standard Notes account of the session
+func remoteStore(opts Options, session *cache.Session) RemoteStore {
+	if opts.Store != nil {
+		return opts.Store
+	}
+
+	if opts.Offline {
+		return &sessionStore{session: session, offline: true}
+	}
+
+	return NewSessionStore(session)
+}
+
+// checkSession returns ErrInvalidSession if the Standard Notes account of the session is used, and it isn't valid.
+// Other stores only use the session for its settings, such as Debug.
+func checkSession(session *cache.Session, opts Options) error {
+	if opts.Store == nil && !session.Valid() {
+		return ErrInvalidSession
+	}
+
+	return nil
+}
+
+// sessionStore keeps the tracked items in a Standard Notes account, via the cache db gosn syncs with it.
+// Changes made while the server can't be reached are queued, and pushed when it next loads online or pushes.
+type sessionStore struct {
+	session *cache.Session
+	db      *storm.DB
+	// cached are the items loaded from the cache db, before decryption
+	cached cache.Items
+	// offline loads the items from the cache db without syncing it, and queues changes
+	offline bool
+	// queue holds the changes waiting to be pushed. While it has any, later changes are pushed after them.
+	queue pushQueue
+	// loaded are the items loaded, with the queued changes applied, used to check changes before queueing them
+	loaded items.Items
+	// replayed, refused and queued count the queued changes pushed, those refused by the server, and the changes queued
+	replayed, refused, queued int
+}
+
+func (s *sessionStore) Load(ctx context.Context) (its items.Items, err error) {
+	if !s.session.Valid() {
+		return nil, ErrInvalidSession
+	}
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	if err = s.Close(); err != nil {
+		return
+	}
+
+	if s.queue, err = loadQueue(QueuePath(s.session.CacheDBPath)); err != nil {
+		return
+	}
+
+	if s.offline {
+		return s.loadOffline()
+	}
+
+	if its, err = s.sync(); err != nil {
+		if unreachable(err) {
+			err = fmt.Errorf("%w: %s, use --offline to use the items last synced and queue changes", ErrOffline, err)
+		}
+
+		return
+	}
+
+	if s.queue.due(time.Now()) {
+		if err = s.replay(ctx); err != nil {
+			return
+		}
+
+		if its, err = s.sync(); err != nil {
+			return
+		}
+	}
+
+	return s.applyQueue(its)
+}
+
+// sync syncs the cache db with SN, leaving it open, and returns its items
+func (s *sessionStore) sync() (its items.Items, err error) {
+	if s.db, err = syncCache(s.session, true); err != nil {
+		return
+	}
+
+	return s.readCached()
+}
+
+// syncCache syncs the cache db with SN, then opens it if open is set. It's closed by the sync either way, so
+// it isn't left open if the sync fails. gosn panics on errors it doesn't handle, such as failing to connect,
+// so the panic is returned as an error instead, wrapping the connection error if there was one.
+func syncCache(session *cache.Session, open bool) (db *storm.DB, err error) {
+	if session.HTTPClient == nil {
+		session.HTTPClient = common.NewHTTPClient()
+	}
+
+	hc := session.HTTPClient.HTTPClient
+	transport := hc.Transport
+
+	conn := &connErrors{base: transport}
+	if conn.base == nil {
+		conn.base = http.DefaultTransport
+	}
+
+	hc.Transport = conn
+
+	defer func() {
+		hc.Transport = transport
+
+		if r := recover(); r != nil {
+			if conn.err != nil {
+				err = fmt.Errorf("failed to sync: %w", conn.err)
+			} else {
+				err = fmt.Errorf("failed to sync: %v", r)
+			}
+
+			db = nil
+		}
+	}()
+
+	if _, err = cache.Sync(cache.SyncInput{Session: session, Close: true}); err != nil {
+		return nil, err
+	}
+
+	if !open {
+		return nil, nil
+	}
+
+	return storm.Open(session.CacheDBPath)
+}
+
+// connErrors records the last error from a request that didn't get a response
+type connErrors struct {
+	base http.RoundTripper
+	err  error
+}
+
+func (c *connErrors) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.base.RoundTrip(req)
+	if err != nil {
+		c.err = err
+	}
+
+	return resp, err
+}
+
+// loadOffline opens the cache db as last synced, without connecting to the server
+func (s *sessionStore) loadOffline() (its items.Items, err error) {
+	if _, err = os.Stat(s.session.CacheDBPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: no items have been synced to use offline", ErrOffline)
+		}
+
+		return
+	}
+
+	if s.db, err = storm.Open(s.session.CacheDBPath); err != nil {
+		return
+	}
+
+	debugPrint(s.session.Debug, fmt.Sprintf("Load | offline, using cache db %s", s.session.CacheDBPath))
+
+	// a session loaded without syncing has no items keys, so they're decrypted from the cache db
+	if len(s.session.ItemsKeys) == 0 {
+		if err = s.loadItemsKeys(); err != nil {
+			_ = s.Close()
+
+			return
+		}
+	}
+
+	if its, err = s.readCached(); err != nil {
+		return
+	}
+
+	return s.applyQueue(its)
+}
+
+// readCached returns the notes, tags and components in the open cache db
+func (s *sessionStore) readCached() (its items.Items, err error) {
+	s.cached = nil
+
+	if e := s.db.Select(q.In("ContentType", []string{"Note", "Tag", "SN|Component", "Extension"})).Find(&s.cached); e != nil {
+		if e.Error() != "not found" {
+			_ = s.Close()
+
+			return nil, e
+		}
+	}
+
+	its, err = s.cached.ToItems(s.session)
+	if err != nil {
+		_ = s.Close()
+
+		return nil, err
+	}
+
+	return its, nil
+}
+
+// loadItemsKeys decrypts the items keys in the open cache db with the session's master key, making the latest
+// the default, as a sync would
+func (s *sessionStore) loadItemsKeys() error {
+	var cached cache.Items
+
+	if e := s.db.Find("ContentType", common.SNItemTypeItemsKey, &cached); e != nil && e.Error() != "not found" {
+		return e
+	}
+
+	var eItems items.EncryptedItems
+
+	for _, ci := range cached {
+		eItems = append(eItems, items.EncryptedItem{
+			UUID:               ci.UUID,
+			Content:            ci.Content,
+			ContentType:        ci.ContentType,
+			ItemsKeyID:         ci.ItemsKeyID,
+			EncItemKey:         ci.EncItemKey,
+			Deleted:            ci.Deleted,
+			CreatedAt:          ci.CreatedAt,
+			UpdatedAt:          ci.UpdatedAt,
+			CreatedAtTimestamp: ci.CreatedAtTimestamp,
+			UpdatedAtTimestamp: ci.UpdatedAtTimestamp,
+		})
+	}
+
+	iks, err := eItems.DecryptAndParseItemsKeys(s.session.MasterKey, s.session.Debug)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt cached items keys: %w", err)
+	}
+
+	if len(iks) == 0 {
+		return fmt.Errorf("%w: no items keys have been synced to use offline", ErrOffline)
+	}
+
+	s.session.ItemsKeys = iks
+	s.session.DefaultItemsKey = iks[0]
+
+	for _, ik := range iks {
+		if ik.CreatedAtTimestamp > s.session.DefaultItemsKey.CreatedAtTimestamp {
+			s.session.DefaultItemsKey = ik
+		}
+	}
+
+	return nil
+}
+
+// applyQueue returns the items with any queued changes applied, as they'll be once the queue is pushed
+func (s *sessionStore) applyQueue(its items.Items) (items.Items, error) {
+	queued, err := s.queue.decrypt(s.session)
+	if err != nil {
+		_ = s.Close()
+
+		return nil, err
+	}
+
+	s.loaded = overlay(its, queued)
+
+	return s.loaded, nil
+}
+
+// replay pushes the queued changes, with the cache db open and synced. If the push fails, the queue is kept and
+// its next attempt delayed, and the error is only returned if the queue can't be saved.
+func (s *sessionStore) replay(ctx context.Context) error {
+	path := QueuePath(s.session.CacheDBPath)
+
+	queued, err := s.queue.decrypt(s.session)
+	if err != nil {
+		_ = s.Close()
+
+		return err
+	}
+
+	debugPrint(s.session.Debug, fmt.Sprintf("Load | pushing %d queued changes", len(queued)))
+
+	rejected, err := s.push(ctx, queued)
+	if err != nil {
+		debugPrint(s.session.Debug, fmt.Sprintf("Load | failed to push queued changes: %v", err))
+
+		s.queue.failed(err, time.Now())
+
+		return s.queue.save(path)
+	}
+
+	if len(rejected) > 0 {
+		debugPrint(s.session.Debug, fmt.Sprintf("Load | %d queued changes refused by the server: %s", len(rejected), strings.Join(rejected, ", ")))
+	}
+
+	s.replayed += len(queued) - len(rejected)
+	s.refused += len(rejected)
+	s.queue = pushQueue{}
+
+	return s.queue.save(path)
+}
+
+// enqueue queues the changes rather than pushing them, closing the cache db
+func (s *sessionStore) enqueue(its items.Items) error {
+	db := s.db
+	s.db = nil
+
+	existing := make(map[string]bool)
+
+	for _, it := range s.loaded {
+		existing[it.GetUUID()] = true
+	}
+
+	b := &batch{items: its}
+	if err := b.check(existing, s.loaded.Tags()); err != nil {
+		_ = db.Close()
+
+		return fmt.Errorf("changes not queued: %w", err)
+	}
+
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return s.addToQueue(its)
+}
+
+func (s *sessionStore) addToQueue(its items.Items) error {
+	if len(its) == 0 {
+		return nil
+	}
+
+	if err := s.queue.add(its, s.session); err != nil {
+		return fmt.Errorf("changes not queued: %w", err)
+	}
+
+	if err := s.queue.save(QueuePath(s.session.CacheDBPath)); err != nil {
+		return fmt.Errorf("changes not queued: %w", err)
+	}
+
+	debugPrint(s.session.Debug, fmt.Sprintf("Push | queued %d changes", len(its)))
+
+	s.queued += len(its)
+
+	return nil
+}
+
+func (s *sessionStore) queueResults() string {
+	var lines []string
+
+	if s.replayed > 0 {
+		lines = append(lines, fmt.Sprintf("pushed %d changes queued while offline", s.replayed))
+	}
+
+	if s.refused > 0 {
+		lines = append(lines, fmt.Sprintf("%d changes queued while offline were refused, as they'd been changed on another device", s.refused))
+	}
+
+	if s.queued > 0 {
+		lines = append(lines, fmt.Sprintf("%d changes queued until the server can be reached, see: sn-sync queue", s.queued))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (s *sessionStore) noteFlags(uuids map[string]bool) (map[string]noteFlags, error) {
+	return getNoteFlags(s.session, s.cached, uuids)
+}
+
+func (s *sessionStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+
+	db := s.db
+	s.db = nil
+
+	return db.Close()
+}
+
+func (s *sessionStore) Delete(ctx context.Context, its items.Items) (rejected []string, err error) {
+	for _, it := range its {
+		it.SetDeleted(true)
+	}
+
+	return s.Push(ctx, its)
+}
+
+// Push pushes the items to SN, after any changes still queued, whether or not the queue's next attempt is due.
+// They're queued instead if offline, or if the server can't be reached when pushing.
+func (s *sessionStore) Push(ctx context.Context, its items.Items) (rejected []string, err error) {
+	if s.db == nil {
+		return nil, errors.New("changes not pushed: store isn't loaded")
+	}
+
+	if s.offline {
+		return nil, s.enqueue(its)
+	}
+
+	if len(s.queue.Items) > 0 {
+		return s.pushWithQueue(ctx, its)
+	}
+
+	rejected, err = s.push(ctx, its)
+	if err != nil && unreachable(err) {
+		debugPrint(s.session.Debug, fmt.Sprintf("Push | server can't be reached, queueing changes: %v", err))
+
+		return nil, s.addToQueue(its)
+	}
+
+	return rejected, err
+}
+
+// pushWithQueue pushes the queued changes along with the items, which replace any queued for the same uuid.
+// Only the items' uuids are returned as rejected, with the queued changes refused counted as a replay's are.
+func (s *sessionStore) pushWithQueue(ctx context.Context, its items.Items) (rejected []string, err error) {
+	path := QueuePath(s.session.CacheDBPath)
+
+	queued, err := s.queue.decrypt(s.session)
+	if err != nil {
+		_ = s.Close()
+
+		return nil, err
+	}
+
+	pushing := make(map[string]bool)
+	for _, it := range its {
+		pushing[it.GetUUID()] = true
+	}
+
+	var all items.Items
+
+	for _, it := range queued {
+		if !pushing[it.GetUUID()] {
+			all = append(all, it)
+		}
+	}
+
+	replaying := len(all)
+	all = append(all, its...)
+
+	debugPrint(s.session.Debug, fmt.Sprintf("Push | pushing %d queued changes with %d new", replaying, len(its)))
+
+	pushRejected, err := s.push(ctx, all)
+	if err != nil {
+		s.queue.failed(err, time.Now())
+
+		if unreachable(err) {
+			debugPrint(s.session.Debug, fmt.Sprintf("Push | server can't be reached, queueing changes: %v", err))
+
+			return nil, s.addToQueue(its)
+		}
+
+		if sErr := s.queue.save(path); sErr != nil {
+			return nil, sErr
+		}
+
+		return nil, err
+	}
+
+	for _, uuid := range pushRejected {
+		if pushing[uuid] {
+			rejected = append(rejected, uuid)
+		} else {
+			s.refused++
+			replaying--
+		}
+	}
+
+	s.replayed += replaying
+	s.queue = pushQueue{}
+
+	return rejected, s.queue.save(path)
+}
+
+// push validates the items and stages them in the cache db, closing it, then pushes them to SN. If the push
+// fails, the cache db is restored to how it was. It then checks whether the server refused any of the pushed
+// items as they'd been changed on another device. If so, the cache is synced in full so it holds the server's
+// version of each, and the copies made of refused items are deleted, rather than left as conflicted copies.
+func (s *sessionStore) push(_ context.Context, its items.Items) (rejected []string, err error) {
+	db := s.db
+	s.db = nil
+
+	session := s.session
+	b := &batch{items: its}
+
+	var previous cache.Items
+
+	var added []string
+
+	if !b.empty() {
+		if err = b.validate(db, session); err != nil {
+			_ = db.Close()
+
+			return nil, fmt.Errorf("changes not pushed: %w", err)
+		}
+
+		previous, added, err = b.stage(db, session)
+		if err != nil {
+			_ = db.Close()
+
+			return nil, fmt.Errorf("changes not pushed: %w", err)
+		}
+	}
+
+	// note what was staged and which items are known, to tell which items were refused and which are copies
+	var staged map[string]string
+
+	known := make(map[string]bool)
+
+	if staged, err = stagedContent(db, b.items); err == nil {
+		err = knownItems(db, known)
+	}
+
+	if err != nil {
+		_ = db.Close()
+
+		return
+	}
+
+	if err = db.Close(); err != nil {
+		return
+	}
+
+	_, err = syncCache(session, false)
+	if err == nil && !b.empty() {
+		err = checkPushed(session.CacheDBPath, b.items)
+	}
+
+	if err != nil {
+		if b.empty() {
+			return
+		}
+
+		if rErr := rollbackCacheDB(session.CacheDBPath, previous, added); rErr != nil {
+			return nil, errors.Join(fmt.Errorf("changes not pushed: %w", err), fmt.Errorf("failed to restore cache: %w", rErr))
+		}
+
+		return nil, fmt.Errorf("changes not pushed: %w", err)
+	}
+
+	if len(staged) == 0 {
+		return
+	}
+
+	// items saved by the push are in the cache db, so it's checked without syncing again
+	db, err = storm.Open(session.CacheDBPath)
+	if err != nil {
+		return
+	}
+
+	if rejected, err = refusedItems(db, staged); err != nil || len(rejected) == 0 {
+		return nil, errors.Join(err, db.Close())
+	}
+
+	debugPrint(session.Debug, fmt.Sprintf("Push | %d items refused by the server: %s", len(rejected), strings.Join(rejected, ", ")))
+
+	// the copies gosn saves of refused items aren't in the cache db, so drop the sync token to fetch everything
+	if err = db.Drop(&cache.SyncToken{}); err != nil {
+		_ = db.Close()
+
+		return
+	}
+
+	if err = db.Close(); err != nil {
+		return
+	}
+
+	if db, err = syncCache(session, true); err != nil {
+		return
+	}
+
+	var refused items.Items
+
+	for _, it := range b.items {
+		if StringInSlice(it.GetUUID(), rejected, true) {
+			refused = append(refused, it)
+		}
+	}
+
+	var copies map[string]string
+
+	copies, err = conflictedCopies(db, session, refused, known)
+	if err != nil {
+		_ = db.Close()
+
+		return
+	}
+
+	var toDelete cache.Items
+
+	for copyUUID, original := range copies {
+		debugPrint(session.Debug, fmt.Sprintf("Push | %s was refused by the server, deleting copy %s", original, copyUUID))
+
+		var ci cache.Item
+		if err = db.One("UUID", copyUUID, &ci); err != nil {
+			_ = db.Close()
+
+			return
+		}
+
+		ci.Deleted = true
+		ci.Dirty = true
+		ci.DirtiedDate = time.Now()
+		toDelete = append(toDelete, ci)
+	}
+
+	if len(toDelete) == 0 {
+		return rejected, db.Close()
+	}
+
+	if err = cache.SaveCacheItems(db, toDelete, false); err != nil {
+		_ = db.Close()
+
+		return
+	}
+
+	if err = db.Close(); err != nil {
+		return
+	}
+
+	_, err = syncCache(session, false)
+
+	return rejected, err
+}
+
+// knownItems adds the uuid of each note and tag in the cache db to known
+func knownItems(db *storm.DB, known map[string]bool) error {
+	var cached cache.Items
+
+	if e := db.Select(q.In("ContentType", []string{"Note", "Tag"})).Find(&cached); e != nil {
+		if e.Error() != "not found" {
+			return e
+		}
+	}
+
+	for _, ci := range cached {
+		known[ci.UUID] = true
+	}
+
+	return nil
+}
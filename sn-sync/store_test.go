@@ -0,0 +1,178 @@
+package snsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/jonhadfield/gosn-v2/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore is a RemoteStore that keeps items in memory, so commands can be tested without a Standard Notes account
+type memoryStore struct {
+	items  map[string]items.Item
+	loaded bool
+	pushes int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{items: make(map[string]items.Item)}
+}
+
+// copyItem returns a copy of the note, tag or component, so changes aren't stored until pushed
+func copyItem(it items.Item) items.Item {
+	switch v := it.(type) {
+	case *items.Note:
+		n := *v
+
+		return &n
+	case *items.Tag:
+		t := *v
+		t.Content.ItemReferences = append(items.ItemReferences{}, v.Content.ItemReferences...)
+
+		return &t
+	case *items.Component:
+		c := *v
+
+		return &c
+	}
+
+	panic("unsupported item: " + it.GetContentType())
+}
+
+func (m *memoryStore) Load(ctx context.Context) (its items.Items, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	m.loaded = true
+
+	for _, it := range m.items {
+		its = append(its, copyItem(it))
+	}
+
+	return its, nil
+}
+
+func (m *memoryStore) Push(_ context.Context, its items.Items) (rejected []string, err error) {
+	if !m.loaded {
+		return nil, errors.New("store isn't loaded")
+	}
+
+	m.loaded = false
+	m.pushes++
+
+	b := &batch{items: its}
+
+	existing := make(map[string]bool)
+
+	var tags items.Tags
+
+	for uuid, it := range m.items {
+		existing[uuid] = true
+
+		if t, ok := it.(*items.Tag); ok {
+			tags = append(tags, *t)
+		}
+	}
+
+	if err = b.check(existing, tags); err != nil {
+		return nil, err
+	}
+
+	for _, it := range its {
+		if it.IsDeleted() {
+			delete(m.items, it.GetUUID())
+
+			continue
+		}
+
+		c := copyItem(it)
+		c.SetUpdatedAt(time.Now().UTC().Format("2006-01-02T15:04:05.000Z"))
+		m.items[it.GetUUID()] = c
+	}
+
+	return nil, nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, its items.Items) (rejected []string, err error) {
+	for _, it := range its {
+		it.SetDeleted(true)
+	}
+
+	return m.Push(ctx, its)
+}
+
+func (m *memoryStore) Close() error {
+	m.loaded = false
+
+	return nil
+}
+
+func (m *memoryStore) notes() (notes items.Notes) {
+	for _, it := range m.items {
+		if n, ok := it.(*items.Note); ok {
+			notes = append(notes, *n)
+		}
+	}
+
+	return notes
+}
+
+func TestCommandsWithStore(t *testing.T) {
+	home := getTemporaryHome()
+	defer os.RemoveAll(home)
+
+	bashrc := filepath.Join(home, ".bashrc")
+	require.NoError(t, createTemporaryFiles(map[string]string{bashrc: "export A=1\n"}))
+
+	store := newMemoryStore()
+	opts := Options{Store: store}
+	// only settings are used from the session, as it's not the store
+	session := &cache.Session{Session: &session.Session{Debug: true}}
+
+	ctx := context.Background()
+
+	ao, err := Add(ctx, AddInput{Session: session, Home: home, Paths: []string{bashrc}, Options: opts})
+	require.NoError(t, err)
+	assert.Equal(t, 1, ao.NotesPushed)
+	require.Len(t, store.notes(), 1)
+	assert.Equal(t, "export A=1\n", store.notes()[0].Content.GetText())
+
+	diffs, _, err := Status(ctx, session, home, nil, DefaultPageSize, opts, true)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, identical, diffs[0].diff)
+
+	// changed elsewhere, so it's pulled
+	note := store.notes()[0]
+	note.Content.SetText("export A=2\n")
+	note.UpdatedAt = time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05.000Z")
+	store.items[note.UUID] = &note
+
+	so, err := Sync(ctx, SNDirSyncInput{Session: session, Root: home, Options: opts, Debug: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, so.NoPulled)
+
+	content, err := os.ReadFile(bashrc)
+	require.NoError(t, err)
+	assert.Equal(t, "export A=2\n", string(content))
+
+	ro, err := Remove(ctx, RemoveInput{Session: session, Home: home, Paths: []string{bashrc}, Options: opts, Debug: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, ro.NotesRemoved)
+	assert.Empty(t, store.notes())
+	assert.False(t, store.loaded)
+}
+
+func TestCheckSession(t *testing.T) {
+	assert.ErrorIs(t, checkSession(&cache.Session{}, Options{}), ErrInvalidSession)
+	assert.NoError(t, checkSession(&cache.Session{}, Options{Store: newMemoryStore()}))
+}
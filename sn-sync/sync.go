@@ -1,14 +1,13 @@
 package snsync
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
-	"time"
 
-	"github.com/asdine/storm/v3"
-	"github.com/briandowns/spinner"
 	"github.com/fatih/color"
 	"github.com/jonhadfield/gosn-v2/cache"
 
@@ -22,91 +21,225 @@ var (
 // Sync compares local and remote items and then:
 // - pulls remotes if locals are older or missing
 // - pushes locals if remotes are newer
-func Sync(si SNDirSyncInput, useStdErr bool) (so SyncOutput, err error) {
+func Sync(ctx context.Context, si SNDirSyncInput) (so SyncOutput, err error) {
+	r := si.Options.reporter()
+	defer func() { r.Finished(err) }()
+
 	if err = checkPathsExist(si.Exclude); err != nil {
 		return
 	}
 
-	if !si.Debug {
-		prefix := HiWhite("syncing ")
-		if _, err = os.Stat(si.Session.CacheDBPath); os.IsNotExist(err) {
-			prefix = HiWhite("initializing ")
-		}
-
-		s := spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stdout))
-		if useStdErr {
-			s = spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stderr))
-		}
-
-		s.Prefix = prefix
-		s.Start()
-		defer s.Stop()
-	}
+	r.PhaseStarted(loadPhase(si.Session, si.Options), 0)
 
-	output, err := sync(syncInput{
+	output, err := sync(ctx, syncInput{
 		session: si.Session,
 		root:    si.Root,
 		paths:   si.Paths,
 		exclude: si.Exclude,
+		options: si.Options,
 		debug:   si.Debug,
 		close:   false,
 	})
 
-	return SyncOutput{
-		NoPushed: output.noPushed,
-		NoPulled: output.noPulled,
-		Msg:      output.msg,
-	}, err
-}
+	so = SyncOutput{
+		NoPushed:     output.noPushed,
+		NoPulled:     output.noPulled,
+		NoMerged:     output.noMerged,
+		NoConflicted: output.noConflicted,
+		NoRefused:    output.noRefused,
+		NoFailed:     len(output.failed),
+		Msg:          output.msg,
+	}
+
+	if err != nil {
+		return
+	}
+
+	var summary string
+
+	summary, err = failureSummary(output.failed)
+	so.Msg += summary
+
+	// conflicts are left to be resolved, so fail once everything else is synced
+	if so.NoConflicted > 0 {
+		err = errors.Join(err, fmt.Errorf("%w: %d items need resolving", ErrConflict, so.NoConflicted))
+	}
 
-func sync(input syncInput) (output syncOutput, err error) {
-	// get populated db
-	csi := cache.SyncInput{
-		Session: input.session,
-		Close:   false,
+	// as are unsafe paths, which doctor can fix
+	if len(output.invalid) > 0 {
+		err = errors.Join(append([]error{err}, output.invalid...)...)
 	}
 
-	var cso cache.SyncOutput
-	cso, err = cache.Sync(csi)
+	return so, err
+}
+
+func sync(ctx context.Context, input syncInput) (output syncOutput, err error) {
+	output, err = syncOnce(ctx, input)
 	if err != nil {
 		return
 	}
 
+	// compare paths refused by the server again, now the cache holds the server's version
+	for attempt := 0; len(output.rejected) > 0; attempt++ {
+		var retry, lines []string
+
+		for _, uuid := range output.rejected {
+			p := output.pushed[uuid]
+
+			// the content pushed was never synced, so compare against what was
+			if err = restoreBase(input.options.StateDir, uuid, p); err != nil {
+				return
+			}
+
+			if p.merged {
+				output.noMerged--
+			} else {
+				output.noPushed--
+			}
+
+			reason := "changed on another device while pushing"
+
+			switch {
+			case !p.hadBase:
+				reason += ", local file kept, use diff to compare"
+			case attempt == maxConflictRetries:
+				reason += ", local file kept, run sync again"
+			default:
+				retry = append(retry, p.path)
+				lines = append(lines, fmt.Sprintf("%s | %s | %s", bold(addDot(p.homeRelPath)), yellow("retrying"), reason))
+
+				continue
+			}
+
+			lines = append(lines, fmt.Sprintf("%s | %s | %s", bold(addDot(p.homeRelPath)), red(conflicted), reason))
+			output.noConflicted++
+		}
+
+		output.msg += "\n" + columnize.SimpleFormat(lines)
+
+		if len(retry) == 0 {
+			break
+		}
+
+		retryInput := input
+		retryInput.paths = retry
+
+		var ro syncOutput
+
+		ro, err = syncOnce(ctx, retryInput)
+		if err != nil {
+			output.msg += "\n" + ro.msg
+
+			return
+		}
+
+		output.noPushed += ro.noPushed
+		output.noPulled += ro.noPulled
+		output.noMerged += ro.noMerged
+		output.noConflicted += ro.noConflicted
+		output.noRefused += ro.noRefused
+		output.failed = append(output.failed, ro.failed...)
+		output.invalid = append(output.invalid, ro.invalid...)
+		output.msg += "\n" + ro.msg
+		output.pushed = ro.pushed
+		output.rejected = ro.rejected
+	}
+
+	return output, err
+}
+
+func syncOnce(ctx context.Context, input syncInput) (output syncOutput, err error) {
+	store := remoteStore(input.options, input.session)
+
 	var remote tagsWithNotes
-	remote, err = getTagsWithNotes(cso.DB, input.session)
+	remote, _, err = getTagsWithNotes(ctx, store)
 	if err != nil {
 		return
 	}
 
 	err = checkNoteTagConflicts(remote)
 	if err != nil {
+		_ = store.Close()
+
 		return
 	}
 
-	output, err = syncDBwithFS(syncInput{
-		db:      cso.DB,
+	output, err = syncDBwithFS(ctx, syncInput{
+		store:   store,
 		session: input.session,
 		twn:     remote,
 		root:    input.root,
 		paths:   input.paths,
 		exclude: input.exclude,
+		options: input.options,
 		debug:   input.debug})
-	if err != nil {
+	if err == nil {
+		r := input.options.reporter()
+		if len(output.pushed) > 0 {
+			r.PhaseStarted(PhasePushing, len(output.pushed))
+		}
 
-		return
+		// files have been pulled, so finish by pushing even if cancelled
+		output.rejected, err = pushChanges(context.WithoutCancel(ctx), store, output.batch)
+		output.msg = appendQueueResults(output.msg, store)
+
+		if err == nil {
+			var pushed []string
+
+			for uuid, p := range output.pushed {
+				if !StringInSlice(uuid, output.rejected, true) {
+					pushed = append(pushed, p.homeRelPath)
+				}
+			}
+
+			sort.Strings(pushed)
+
+			for _, path := range pushed {
+				r.ItemPushed(path)
+			}
+		}
+	} else {
+		_ = store.Close()
 	}
 
-	if err = cso.DB.Close(); err != nil {
-		return
+	if err != nil {
+		// nothing was pushed, so the content last synced is as it was
+		for uuid, p := range output.pushed {
+			if rErr := restoreBase(input.options.StateDir, uuid, p); rErr != nil {
+				return output, errors.Join(err, rErr)
+			}
+		}
 	}
 
-	// TODO: Check every editor component and ensure no sync are associated (ensure plain text editor)
+	return
+}
 
-	// persist changes
-	csi.Close = true
-	_, err = cache.Sync(csi)
+// pushedItem is a note pushed by sync, with the content last synced before it
+type pushedItem struct {
+	path, homeRelPath string
+	merged            bool
+	base              string
+	hadBase           bool
+}
 
-	return
+func newPushedItem(stateDir string, itemDiff ItemDiff, merged bool) (p pushedItem, err error) {
+	p = pushedItem{
+		path:        itemDiff.path,
+		homeRelPath: itemDiff.homeRelPath,
+		merged:      merged,
+	}
+
+	p.base, p.hadBase, err = loadBase(stateDir, itemDiff.remote.UUID)
+
+	return p, err
+}
+
+func restoreBase(stateDir, uuid string, p pushedItem) error {
+	if p.hadBase {
+		return saveBase(stateDir, uuid, p.base)
+	}
+
+	return removeBase(stateDir, uuid)
 }
 
 type SNDirSyncInput struct {
@@ -114,6 +247,7 @@ type SNDirSyncInput struct {
 	Root           string
 	Paths, Exclude []string
 	PageSize       int
+	Options        Options
 	Debug          bool
 }
 
@@ -125,26 +259,24 @@ type SNDotfilesSyncInput struct {
 	Debug          bool
 }
 type SyncOutput struct {
-	NoPushed, NoPulled int
-	Msg                string
+	NoPushed, NoPulled, NoMerged, NoConflicted, NoRefused, NoFailed int
+	Msg                                                             string
 }
 
-func syncDBwithFS(si syncInput) (so syncOutput, err error) {
-	if si.db == nil {
-		panic("didn't get db sent to syncDBwithFS")
+func syncDBwithFS(ctx context.Context, si syncInput) (so syncOutput, err error) {
+	if si.store == nil {
+		return so, errors.New("didn't get store sent to syncDBwithFS")
 	}
 	var itemDiffs []ItemDiff
 
-	itemDiffs, err = compare(si.twn, si.root, si.paths, si.exclude, si.debug)
+	itemDiffs, err = compare(ctx, si.twn, si.root, si.paths, si.exclude, si.options, si.debug)
 	if err != nil {
-		if strings.Contains(err.Error(), "tags with notes not supplied") {
-			err = errors.New("no remote sync found")
-		}
-
 		return
 	}
 
-	var itemsToPush, itemsToPull []ItemDiff
+	var itemsToPush, itemsToPull, itemsMerged, itemsConflicted []ItemDiff
+
+	var res []string
 
 	var itemsToSync bool
 	for _, itemDiff := range itemDiffs {
@@ -154,16 +286,78 @@ func syncDBwithFS(si syncInput) (so syncOutput, err error) {
 			continue
 		}
 
+		// use the last synced content to resolve structured files changed on both sides
+		if itemDiff.diff == localNewer || itemDiff.diff == remoteNewer {
+			itemDiff, err = resolveWithBase(itemDiff, si.options, si.debug)
+			if err != nil {
+				return
+			}
+		}
+
+		// archived, locked and protected notes control what's synced
+		if status, reason := checkNoteFlags(itemDiff, si.options); status != "" {
+			debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | %s %s: %s", status, itemDiff.homeRelPath, reason))
+
+			if status == refused {
+				so.noRefused++
+			}
+
+			if itemDiff.diff != identical {
+				res = append(res, fmt.Sprintf("%s | %s | %s", bold(addDot(itemDiff.homeRelPath)), colourDiff(status), reason))
+			}
+
+			continue
+		}
+
+		// refuse to push anything that looks like a private key or credential
+		if itemDiff.diff == localNewer || itemDiff.diff == merged {
+			if sErr := checkForSecrets(itemDiff.homeRelPath, itemDiff.local, si.options); sErr != nil {
+				debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | refusing to push %s: %s", itemDiff.homeRelPath, sErr))
+				res = append(res, fmt.Sprintf("%s | %s | %s", bold(addDot(itemDiff.homeRelPath)), red(refused), sErr))
+				so.noRefused++
+
+				continue
+			}
+		}
+
+		// refuse to write through a symlinked directory
+		if itemDiff.diff == localMissing || itemDiff.diff == blockMissing || itemDiff.diff == remoteNewer || itemDiff.diff == merged {
+			if pErr := checkNoSymlinkDirs(itemDiff.path, si.root); pErr != nil {
+				itemDiff.diff = invalidPath
+				itemDiff.err = pErr
+			}
+		}
+
 		switch itemDiff.diff {
+		case failed:
+			debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | skipping %s: %s", itemDiff.homeRelPath, itemDiff.err))
+			so.failed = append(so.failed, itemDiff)
+		case invalidPath, undecryptable, notPlainText:
+			debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | skipping %s: %s", itemDiff.homeRelPath, itemDiff.err))
+
+			reason := itemDiff.err.Error()
+			if itemDiff.diff == notPlainText {
+				reason += ", use repair to reset it"
+			}
+
+			if itemDiff.diff == invalidPath {
+				so.invalid = append(so.invalid, itemDiff.err)
+			}
+
+			res = append(res, fmt.Sprintf("%s | %s | %s", bold(addDot(itemDiff.homeRelPath)), red(itemDiff.diff), reason))
+		case identical:
+			if err = recordSynced(si.options.StateDir, itemDiff, itemDiff.local); err != nil {
+				return
+			}
 		case localNewer:
 			//addToDB
 			debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | local %s is newer", itemDiff.homeRelPath))
 			itemDiff.remote.Content.SetText(itemDiff.local)
 			itemsToPush = append(itemsToPush, itemDiff)
 			itemsToSync = true
-		case localMissing:
+		case localMissing, blockMissing:
 			// createLocal
-			debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | %s is missing", itemDiff.homeRelPath))
+			debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | %s is %s", itemDiff.homeRelPath, itemDiff.diff))
 			itemsToPull = append(itemsToPull, itemDiff)
 			itemsToSync = true
 		case remoteNewer:
@@ -171,43 +365,120 @@ func syncDBwithFS(si syncInput) (so syncOutput, err error) {
 			debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | remote %s is newer", itemDiff.homeRelPath))
 			itemsToPull = append(itemsToPull, itemDiff)
 			itemsToSync = true
+		case merged:
+			// merged content is both pushed and pulled
+			debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | merged %s", itemDiff.homeRelPath))
+			itemDiff.remote.Content.SetText(itemDiff.local)
+			itemsMerged = append(itemsMerged, itemDiff)
+			itemsToSync = true
+		case conflicted:
+			debugPrint(si.debug, fmt.Sprintf("syncDBwithFS | %s has conflicts: %s", itemDiff.homeRelPath, strings.Join(itemDiff.conflicts, ", ")))
+			itemsConflicted = append(itemsConflicted, itemDiff)
 		}
 	}
 
+	for _, conflictItem := range itemsConflicted {
+		line := fmt.Sprintf("%s | %s | %s", bold(addDot(conflictItem.homeRelPath)), red(conflicted), strings.Join(conflictItem.conflicts, ", "))
+		res = append(res, line)
+	}
+
+	so.noConflicted = len(itemsConflicted)
+
 	// check items to sync
 	if !itemsToSync {
+		if len(res) > 0 {
+			so.msg = fmt.Sprint(columnize.SimpleFormat(res))
+			return
+		}
+
 		so.msg = fmt.Sprint(bold("nothing to do"))
+
 		return
 	}
 
-	// addToDB
-	if len(itemsToPush) > 0 {
-		err = addToDB(si.db, si.session, itemsToPush, si.close)
+	if !si.options.Force {
+		err = si.options.Limits.checkChanges(countTrackedNotes(si.twn), map[string]int{
+			"push": len(itemsToPush) + len(itemsMerged),
+			"pull": countOverwrites(itemsToPull) + len(itemsMerged),
+		})
 		if err != nil {
 			return
 		}
-		so.noPushed = len(itemsToPush)
 	}
 
-	res := make([]string, len(itemsToPush))
 	strPushed := green("pushed")
 	strPulled := green("pulled")
+	strMerged := green(merged)
 
-	for i, pushItem := range itemsToPush {
-		line := fmt.Sprintf("%s | %s", bold(addDot(pushItem.homeRelPath)), strPushed)
-		res[i] = line
-	}
+	// results so far are kept if cancelled, as they don't depend on anything being applied
+	reported := len(res)
+
+	// create local, before pushing, so a merge that can't be written isn't pushed either
+	var pending, writeFailed []ItemDiff
+
+	pending, writeFailed, err = writeLocal(ctx, append(append([]ItemDiff{}, itemsToPull...), itemsMerged...), si.root, si.options)
+
+	so.failed = append(so.failed, writeFailed...)
+	itemsToPull = withoutFailed(itemsToPull, writeFailed)
+	itemsMerged = withoutFailed(itemsMerged, writeFailed)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			so.noPulled, so.msg = cancelledResults(res[:reported], itemsToPull, itemsToPush, itemsMerged, pending)
+		}
 
-	// create local
-	if err = createLocal(itemsToPull); err != nil {
 		return
 	}
 
+	// addToDB
+	if len(itemsToPush)+len(itemsMerged) > 0 {
+		so.batch = &batch{}
+
+		err = addToDB(so.batch, append(append([]ItemDiff{}, itemsToPush...), itemsMerged...), si.options)
+		if err != nil {
+			return
+		}
+		so.noPushed = len(itemsToPush)
+		so.noMerged = len(itemsMerged)
+	}
+
+	so.pushed = make(map[string]pushedItem)
+
+	for _, pushItem := range itemsToPush {
+		line := fmt.Sprintf("%s | %s", bold(addDot(pushItem.homeRelPath)), strPushed)
+		res = append(res, line)
+
+		if so.pushed[pushItem.remote.UUID], err = newPushedItem(si.options.StateDir, pushItem, false); err != nil {
+			return
+		}
+
+		if err = recordSynced(si.options.StateDir, pushItem, pushItem.local); err != nil {
+			return
+		}
+	}
+
 	so.noPulled = len(itemsToPull)
 
 	for _, pullItem := range itemsToPull {
 		line := fmt.Sprintf("%s | %s\n", bold(addDot(pullItem.homeRelPath)), strPulled)
 		res = append(res, line)
+
+		if err = recordSynced(si.options.StateDir, pullItem, pullItem.remote.Content.GetText()); err != nil {
+			return
+		}
+	}
+
+	for _, mergeItem := range itemsMerged {
+		line := fmt.Sprintf("%s | %s", bold(addDot(mergeItem.homeRelPath)), strMerged)
+		res = append(res, line)
+
+		if so.pushed[mergeItem.remote.UUID], err = newPushedItem(si.options.StateDir, mergeItem, true); err != nil {
+			return
+		}
+
+		if err = recordSynced(si.options.StateDir, mergeItem, mergeItem.local); err != nil {
+			return
+		}
 	}
 
 	so.msg = fmt.Sprint(columnize.SimpleFormat(res))
@@ -215,19 +486,46 @@ func syncDBwithFS(si syncInput) (so syncOutput, err error) {
 	return so, err
 }
 
+// withoutFailed returns the item diffs whose paths aren't in failures
+func withoutFailed(itemDiffs, failures []ItemDiff) (res []ItemDiff) {
+	failedPaths := make(map[string]bool)
+	for _, f := range failures {
+		failedPaths[f.path] = true
+	}
+
+	for _, d := range itemDiffs {
+		if !failedPaths[d.path] {
+			res = append(res, d)
+		}
+	}
+
+	return res
+}
+
 type syncInput struct {
-	db             *storm.DB
+	store          RemoteStore
 	session        *cache.Session
 	twn            tagsWithNotes
 	root           string
 	paths, exclude []string
+	options        Options
 	debug          bool
 	close          bool
 }
 
 type syncOutput struct {
-	noPushed, noPulled int
-	msg                string
+	noPushed, noPulled, noMerged, noConflicted, noRefused int
+	msg                                                   string
+	// pushed are the notes pushed, by uuid
+	pushed map[string]pushedItem
+	// rejected are the uuids of pushed notes refused by the server
+	rejected []string
+	// batch holds the changes to push
+	batch *batch
+	// failed are the paths that couldn't be read, compared or written
+	failed []ItemDiff
+	// invalid are the errors of paths skipped as they'd be unsafe to write
+	invalid []error
 }
 
 func ensureTrailingPathSep(in string) string {
@@ -253,3 +551,38 @@ func matchesPathsToExclude(home, path string, pathsToExclude []string) bool {
 
 	return false
 }
+
+// cancelledResults reports the files pulled before sync was cancelled, and the changes it didn't apply
+func cancelledResults(res []string, pulls, pushes, merges, pending []ItemDiff) (noPulled int, msg string) {
+	notWritten := make(map[string]bool)
+	for _, d := range pending {
+		notWritten[d.path] = true
+	}
+
+	for _, d := range pulls {
+		if notWritten[d.path] {
+			res = append(res, fmt.Sprintf("%s | %s | %s", bold(addDot(d.homeRelPath)), colourDiff(cancelled), "not pulled"))
+
+			continue
+		}
+
+		noPulled++
+
+		res = append(res, fmt.Sprintf("%s | %s", bold(addDot(d.homeRelPath)), green("pulled")))
+	}
+
+	for _, d := range pushes {
+		res = append(res, fmt.Sprintf("%s | %s | %s", bold(addDot(d.homeRelPath)), colourDiff(cancelled), "not pushed"))
+	}
+
+	for _, d := range merges {
+		reason := "merged locally, not pushed"
+		if notWritten[d.path] {
+			reason = "not merged"
+		}
+
+		res = append(res, fmt.Sprintf("%s | %s | %s", bold(addDot(d.homeRelPath)), colourDiff(cancelled), reason))
+	}
+
+	return noPulled, fmt.Sprint(columnize.SimpleFormat(res))
+}
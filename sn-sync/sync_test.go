@@ -1,31 +1,32 @@
 package snsync
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
 	"time"
 
-	"github.com/jonhadfield/gosn-v2"
 	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
 	"github.com/stretchr/testify/require"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestSyncInvalidSession(t *testing.T) {
-	_, err := Sync(SNDotfilesSyncInput{
+	_, err := Sync(context.Background(), SNDirSyncInput{
 		Session: &cache.Session{
 			Session:     nil,
 			CacheDB:     nil,
 			CacheDBPath: "",
 		},
-		Home:    getTemporaryHome(),
+		Root:    getTemporaryHome(),
 		Paths:   []string{},
 		Exclude: []string{},
 		Debug:   true,
-	}, true)
+	})
 	assert.Error(t, err)
 }
 
@@ -39,15 +40,15 @@ func TestSyncNoItems(t *testing.T) {
 	var err error
 	// add item
 	var so SyncOutput
-	so, err = Sync(SNDotfilesSyncInput{
+	so, err = Sync(context.Background(), SNDirSyncInput{
 		Session: testCacheSession,
-		Home:    home,
+		Root:    home,
 		Paths:   []string{},
 		Exclude: []string{},
 		Debug:   true,
-	}, true)
+	})
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "no remote sync found")
+	assert.ErrorIs(t, err, ErrNoRemoteItems)
 	assert.Equal(t, 0, so.NoPushed)
 	assert.Equal(t, 0, so.NoPulled)
 }
@@ -71,7 +72,7 @@ func TestBasicSync(t *testing.T) {
 	assert.NoError(t, createTemporaryFiles(fwc))
 	// add item
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{applePath}}
-	ao, err := Add(ai, true)
+	ao, err := Add(context.Background(), ai)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(ao.PathsAdded))
 	assert.Equal(t, applePath, ao.PathsAdded[0])
@@ -81,13 +82,13 @@ func TestBasicSync(t *testing.T) {
 	// delete local file so we can sync it back
 	require.NoError(t, os.Remove(applePath))
 
-	so, err := Sync(SNDotfilesSyncInput{
+	so, err := Sync(context.Background(), SNDirSyncInput{
 		Session: testCacheSession,
-		Home:    home,
+		Root:    home,
 		Paths:   []string{},
 		Exclude: []string{},
 		Debug:   true,
-	}, true)
+	})
 
 	require.NoError(t, err)
 	assert.Equal(t, 0, so.NoPushed)
@@ -117,7 +118,7 @@ func TestSyncTwoFilesUpdatedFiles(t *testing.T) {
 
 	assert.NoError(t, createTemporaryFiles(fwc))
 	ai := AddInput{Session: testCacheSession, Home: home, Paths: []string{applePath, lemonPath}}
-	ao, err := Add(ai, true)
+	ao, err := Add(context.Background(), ai)
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(ao.PathsAdded))
 	assert.Equal(t, applePath, ao.PathsAdded[0])
@@ -128,13 +129,13 @@ func TestSyncTwoFilesUpdatedFiles(t *testing.T) {
 	assert.NoError(t, createPathWithContent(lemonPath, "lemon content updated"))
 
 	var so SyncOutput
-	so, err = Sync(SNDotfilesSyncInput{
+	so, err = Sync(context.Background(), SNDirSyncInput{
 		Session: testCacheSession,
-		Home:    home,
+		Root:    home,
 		Paths:   []string{applePath, lemonPath},
 		Exclude: []string{},
 		Debug:   true,
-	}, true)
+	})
 
 	require.NoError(t, err)
 	require.Equal(t, so.NoPushed, 2)
@@ -150,23 +151,23 @@ func TestSync(t *testing.T) {
 	}()
 	home := getTemporaryHome()
 
-	fruitTag := createTag("sync.fruit")
-	bananaTag := createTag("sync.fruit.banana")
-	carsTag := createTag("sync.cars")
-	vwTag := createTag("sync.cars.vw")
-	mercedesTag := createTag("sync.cars.mercedes")
-	a250Tag := createTag("sync.cars.mercedes.a250")
+	fruitTag := mustCreateTag("sync.fruit")
+	bananaTag := mustCreateTag("sync.fruit.banana")
+	carsTag := mustCreateTag("sync.cars")
+	vwTag := mustCreateTag("sync.cars.vw")
+	mercedesTag := mustCreateTag("sync.cars.mercedes")
+	a250Tag := mustCreateTag("sync.cars.mercedes.a250")
 	appleNote := createNote("apple", "apple content")
 	yellowNote := createNote("yellow", "yellow content")
 	golfNote := createNote("golf.txt", "golf content")
 	premiumNote := createNote("premium", "premium content")
 
-	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: gosn.Notes{appleNote}}
-	carsTagWithNotes := tagWithNotes{tag: carsTag, notes: gosn.Notes{}}
-	bananaTagWithNotes := tagWithNotes{tag: bananaTag, notes: gosn.Notes{yellowNote}}
-	vwTagWithNotes := tagWithNotes{tag: vwTag, notes: gosn.Notes{golfNote}}
-	mercedesTagWithNotes := tagWithNotes{tag: mercedesTag, notes: gosn.Notes{}}
-	a250TagWithNotes := tagWithNotes{tag: a250Tag, notes: gosn.Notes{premiumNote}}
+	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: items.Notes{appleNote}}
+	carsTagWithNotes := tagWithNotes{tag: carsTag, notes: items.Notes{}}
+	bananaTagWithNotes := tagWithNotes{tag: bananaTag, notes: items.Notes{yellowNote}}
+	vwTagWithNotes := tagWithNotes{tag: vwTag, notes: items.Notes{golfNote}}
+	mercedesTagWithNotes := tagWithNotes{tag: mercedesTag, notes: items.Notes{}}
+	a250TagWithNotes := tagWithNotes{tag: a250Tag, notes: items.Notes{premiumNote}}
 	twn := tagsWithNotes{fruitTagWithNotes, carsTagWithNotes, bananaTagWithNotes, vwTagWithNotes, mercedesTagWithNotes, a250TagWithNotes}
 
 	fwc := make(map[string]string)
@@ -179,23 +180,20 @@ func TestSync(t *testing.T) {
 
 	assert.NoError(t, createTemporaryFiles(fwc))
 
-	// get populated db
-	si := cache.SyncInput{
-		Session: testCacheSession,
-		Close:   false,
-	}
-	cso, err := cache.Sync(si)
+	// get populated store
+	store := NewSessionStore(testCacheSession)
+	_, err := store.Load(context.Background())
 	require.NoError(t, err)
 
 	// Sync with changes to createLocal based on missing local
 	var noPushed, noPulled int
 	debugPrint(true, "test | syncDBwithFS with changes to createLocal based on missing local")
 	var so syncOutput
-	so, err = syncDBwithFS(syncInput{
-		db:      cso.DB,
+	so, err = syncDBwithFS(context.Background(), syncInput{
+		store:   store,
 		session: testCacheSession,
 		twn:     twn,
-		home:    home,
+		root:    home,
 		debug:   true,
 		close:   false,
 	})
@@ -211,11 +209,11 @@ func TestSync(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	fwc[applePath] = "new apple content"
 	assert.NoError(t, createTemporaryFiles(fwc))
-	so, err = syncDBwithFS(syncInput{
-		db:      cso.DB,
+	so, err = syncDBwithFS(context.Background(), syncInput{
+		store:   store,
 		session: testCacheSession,
 		twn:     twn,
-		home:    home,
+		root:    home,
 		paths:   []string{},
 		exclude: []string{},
 		debug:   true,
@@ -232,7 +230,7 @@ func TestSync(t *testing.T) {
 	var uTwn tagsWithNotes
 	for _, x := range twn {
 		if x.tag.Content.GetTitle() == "sync.fruit" {
-			var nnotes gosn.Notes
+			var nnotes items.Notes
 			for _, note := range x.notes {
 				if note.Content.GetTitle() == "apple" {
 					note.Content.SetText("new note content")
@@ -246,11 +244,11 @@ func TestSync(t *testing.T) {
 		uTwn = append(uTwn, x)
 	}
 	assert.NoError(t, err)
-	so, err = syncDBwithFS(syncInput{
-		db:      cso.DB,
+	so, err = syncDBwithFS(context.Background(), syncInput{
+		store:   store,
 		session: testCacheSession,
 		twn:     uTwn,
-		home:    home,
+		root:    home,
 		paths:   []string{},
 		exclude: []string{},
 		debug:   true,
@@ -262,11 +260,11 @@ func TestSync(t *testing.T) {
 
 	// Sync with nothing to do
 	debugPrint(true, "test | syncDBwithFS with nothing to do")
-	so, err = syncDBwithFS(syncInput{
-		db:      cso.DB,
+	so, err = syncDBwithFS(context.Background(), syncInput{
+		store:   store,
 		session: testCacheSession,
 		twn:     uTwn,
-		home:    home,
+		root:    home,
 		paths:   []string{},
 		exclude: []string{},
 		debug:   true,
@@ -285,42 +283,39 @@ func TestSyncWithExcludeAbsolutePaths(t *testing.T) {
 	}()
 	home := getTemporaryHome()
 
-	fruitTag := createTag("sync.fruit")
-	bananaTag := createTag("sync.fruit.banana")
-	carsTag := createTag("sync.cars")
-	vwTag := createTag("sync.cars.vw")
-	mercedesTag := createTag("sync.cars.mercedes")
-	a250Tag := createTag("sync.cars.mercedes.a250")
+	fruitTag := mustCreateTag("sync.fruit")
+	bananaTag := mustCreateTag("sync.fruit.banana")
+	carsTag := mustCreateTag("sync.cars")
+	vwTag := mustCreateTag("sync.cars.vw")
+	mercedesTag := mustCreateTag("sync.cars.mercedes")
+	a250Tag := mustCreateTag("sync.cars.mercedes.a250")
 	appleNote := createNote("apple", "apple content")
 	yellowNote := createNote("yellow", "yellow content")
 	golfNote := createNote("golf.txt", "golf content")
 	premiumNote := createNote("premium", "premium content")
 
-	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: gosn.Notes{appleNote}}
-	carsTagWithNotes := tagWithNotes{tag: carsTag, notes: gosn.Notes{}}
-	bananaTagWithNotes := tagWithNotes{tag: bananaTag, notes: gosn.Notes{yellowNote}}
-	vwTagWithNotes := tagWithNotes{tag: vwTag, notes: gosn.Notes{golfNote}}
-	mercedesTagWithNotes := tagWithNotes{tag: mercedesTag, notes: gosn.Notes{}}
-	a250TagWithNotes := tagWithNotes{tag: a250Tag, notes: gosn.Notes{premiumNote}}
+	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: items.Notes{appleNote}}
+	carsTagWithNotes := tagWithNotes{tag: carsTag, notes: items.Notes{}}
+	bananaTagWithNotes := tagWithNotes{tag: bananaTag, notes: items.Notes{yellowNote}}
+	vwTagWithNotes := tagWithNotes{tag: vwTag, notes: items.Notes{golfNote}}
+	mercedesTagWithNotes := tagWithNotes{tag: mercedesTag, notes: items.Notes{}}
+	a250TagWithNotes := tagWithNotes{tag: a250Tag, notes: items.Notes{premiumNote}}
 	twn := tagsWithNotes{fruitTagWithNotes, carsTagWithNotes, bananaTagWithNotes, vwTagWithNotes, mercedesTagWithNotes, a250TagWithNotes}
 
-	// get populated db
-	si := cache.SyncInput{
-		Session: testCacheSession,
-		Close:   false,
-	}
-	cso, err := cache.Sync(si)
+	// get populated store
+	store := NewSessionStore(testCacheSession)
+	_, err := store.Load(context.Background())
 	require.NoError(t, err)
 
 	debugPrint(true, "test | syncDBwithFS with three changes to createLocal based on exclusion of golf path")
 	golfPath := fmt.Sprintf("%s/.cars/vw/golf.txt", home)
 
 	var so syncOutput
-	so, err = syncDBwithFS(syncInput{
-		db:      cso.DB,
+	so, err = syncDBwithFS(context.Background(), syncInput{
+		store:   store,
 		session: testCacheSession,
 		twn:     twn,
-		home:    home,
+		root:    home,
 		paths:   []string{},
 		exclude: []string{golfPath},
 		debug:   true,
@@ -339,41 +334,38 @@ func TestSyncWithExcludeParentPaths(t *testing.T) {
 
 	home := getTemporaryHome()
 
-	fruitTag := createTag("sync.fruit")
-	bananaTag := createTag("sync.fruit.banana")
-	carsTag := createTag("sync.cars")
-	vwTag := createTag("sync.cars.vw")
-	mercedesTag := createTag("sync.cars.mercedes")
-	a250Tag := createTag("sync.cars.mercedes.a250")
+	fruitTag := mustCreateTag("sync.fruit")
+	bananaTag := mustCreateTag("sync.fruit.banana")
+	carsTag := mustCreateTag("sync.cars")
+	vwTag := mustCreateTag("sync.cars.vw")
+	mercedesTag := mustCreateTag("sync.cars.mercedes")
+	a250Tag := mustCreateTag("sync.cars.mercedes.a250")
 	appleNote := createNote("apple", "apple content")
 	yellowNote := createNote("yellow", "yellow content")
 	golfNote := createNote("golf.txt", "golf content")
 	premiumNote := createNote("premium", "premium content")
 
-	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: gosn.Notes{appleNote}}
-	carsTagWithNotes := tagWithNotes{tag: carsTag, notes: gosn.Notes{}}
-	bananaTagWithNotes := tagWithNotes{tag: bananaTag, notes: gosn.Notes{yellowNote}}
-	vwTagWithNotes := tagWithNotes{tag: vwTag, notes: gosn.Notes{golfNote}}
-	mercedesTagWithNotes := tagWithNotes{tag: mercedesTag, notes: gosn.Notes{}}
-	a250TagWithNotes := tagWithNotes{tag: a250Tag, notes: gosn.Notes{premiumNote}}
+	fruitTagWithNotes := tagWithNotes{tag: fruitTag, notes: items.Notes{appleNote}}
+	carsTagWithNotes := tagWithNotes{tag: carsTag, notes: items.Notes{}}
+	bananaTagWithNotes := tagWithNotes{tag: bananaTag, notes: items.Notes{yellowNote}}
+	vwTagWithNotes := tagWithNotes{tag: vwTag, notes: items.Notes{golfNote}}
+	mercedesTagWithNotes := tagWithNotes{tag: mercedesTag, notes: items.Notes{}}
+	a250TagWithNotes := tagWithNotes{tag: a250Tag, notes: items.Notes{premiumNote}}
 	twn := tagsWithNotes{fruitTagWithNotes, carsTagWithNotes, bananaTagWithNotes, vwTagWithNotes, mercedesTagWithNotes, a250TagWithNotes}
 
-	// get populated db
-	si := cache.SyncInput{
-		Session: testCacheSession,
-		Close:   false,
-	}
-	cso, err := cache.Sync(si)
+	// get populated store
+	store := NewSessionStore(testCacheSession)
+	_, err := store.Load(context.Background())
 	assert.NoError(t, err)
 
 	debugPrint(true, "test | syncDBwithFS with two changes to createLocal based on exclusion of cars path")
 	carsPath := fmt.Sprintf("%s/.cars", home)
 	var so syncOutput
-	so, err = syncDBwithFS(syncInput{
-		db:      cso.DB,
+	so, err = syncDBwithFS(context.Background(), syncInput{
+		store:   store,
 		session: testCacheSession,
 		twn:     twn,
-		home:    home,
+		root:    home,
 		paths:   []string{},
 		exclude: []string{carsPath},
 		debug:   true,
@@ -382,3 +374,12 @@ func TestSyncWithExcludeParentPaths(t *testing.T) {
 	assert.Equal(t, 0, so.noPushed)
 	assert.Equal(t, 2, so.noPulled)
 }
+
+func TestSyncDBwithFSWithoutStore(t *testing.T) {
+	_, err := syncDBwithFS(context.Background(), syncInput{
+		session: testCacheSession,
+		root:    getTemporaryHome(),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "store")
+}
@@ -0,0 +1,182 @@
+package snsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jonhadfield/gosn-v2/cache"
+	"github.com/jonhadfield/gosn-v2/items"
+	"github.com/ryanuber/columnize"
+)
+
+const (
+	verified         = "verified"
+	corrupt          = "corrupt"
+	unverified       = "unverified"
+	modified         = "modified"
+	changedElsewhere = "changed elsewhere"
+)
+
+type VerifyInput struct {
+	Session  *cache.Session
+	Home     string
+	Paths    []string
+	PageSize int
+	Options  Options
+	Debug    bool
+}
+
+type VerifyOutput struct {
+	NoVerified, NoCorrupt, NoUnverified int
+	Msg                                 string
+}
+
+// Verify checks the content of tracked notes against the checksum recorded when they were pushed,
+// and local files against their checksum when last synced, without changing either. Only the
+// checksums are compared, so nothing is decrypted and no secrets are resolved.
+func Verify(ctx context.Context, vi VerifyInput) (vo VerifyOutput, err error) {
+	r := vi.Options.reporter()
+	defer func() { r.Finished(err) }()
+
+	r.PhaseStarted(loadPhase(vi.Session, vi.Options), 0)
+
+	store := remoteStore(vi.Options, vi.Session)
+
+	var twn tagsWithNotes
+
+	twn, _, err = getTagsWithNotes(ctx, store)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if cErr := store.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+
+	if err = checkNoteTagConflicts(twn); err != nil {
+		return
+	}
+
+	var lines []string
+
+	for _, t := range twn {
+		tagTitle := t.tag.Content.GetTitle()
+
+		dir, dErr := tagTitleToFSDir(tagTitle, vi.Home)
+		if dErr != nil && !errors.Is(dErr, errUnsafePath) {
+			return vo, dErr
+		}
+
+		if dErr == nil && len(vi.Paths) > 0 && !pathIsPrefixOfPaths(dir, vi.Paths) {
+			continue
+		}
+
+		for _, note := range t.notes {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+
+			homeRelPath := tagTitle + "/" + note.Content.GetTitle()
+
+			var status, reason, path string
+
+			// a note under an unsafe tag has no path to check
+			pErr := dErr
+			if pErr == nil {
+				path, pErr = remoteNotePath(dir, note.Content.GetTitle(), vi.Home)
+			}
+
+			switch {
+			case pErr != nil:
+				status, reason = unverified, pErr.Error()
+			case len(vi.Paths) > 0 && !noteInPaths(dir+note.Content.GetTitle(), vi.Paths):
+				continue
+			default:
+				homeRelPath = stripHome(path, vi.Home)
+				status, reason = verifyNote(note, path, vi.Options.StateDir)
+			}
+
+			switch status {
+			case verified:
+				vo.NoVerified++
+			case corrupt:
+				vo.NoCorrupt++
+			default:
+				vo.NoUnverified++
+			}
+
+			lines = append(lines, fmt.Sprintf("%s | %s | %s", bold(addDot(homeRelPath)), colourDiff(status), reason))
+		}
+	}
+
+	if len(lines) == 0 {
+		vo.Msg = fmt.Sprint(bold("nothing to verify"))
+
+		return
+	}
+
+	vo.Msg = fmt.Sprint(columnize.SimpleFormat(lines))
+
+	return vo, err
+}
+
+// verifyNote checks the note's text, as stored, against its recorded checksum, and the local file
+// against its checksum when last synced. A note that doesn't match is only reported as corrupt if
+// its update time is the one recorded with the checksum, as other clients don't update the checksum
+// when editing it. Likewise a file that's changed since without its modification time changing is
+// reported as corrupt, but a file edited since is only reported as modified.
+func verifyNote(note items.Note, path, stateDir string) (status, reason string) {
+	sum, updatedAt, found := getChecksum(note)
+
+	switch {
+	case !found:
+		status, reason = unverified, "no checksum recorded for note"
+	case sum == checksum([]byte(note.Content.GetText())):
+		status, reason = verified, "note"
+	case updatedAt != "" && updatedAt == note.Content.GetAppData().OrgStandardNotesSN.ClientUpdatedAt:
+		return corrupt, "note content doesn't match its checksum"
+	default:
+		status, reason = changedElsewhere, "note edited by another client since it was pushed"
+	}
+
+	lc, found, err := loadLocalChecksum(stateDir, note.UUID)
+	if err != nil {
+		return corrupt, fmt.Sprintf("failed to read local checksum: %s", err)
+	}
+
+	if !found {
+		return status, reason + ", no checksum recorded for local file"
+	}
+
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return modified, "local file missing"
+	}
+
+	if err != nil {
+		return corrupt, err.Error()
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return corrupt, err.Error()
+	}
+
+	if checksum(b) != lc.SHA256 {
+		if stat.ModTime().After(lc.ModTime) {
+			return modified, "local file changed since last sync"
+		}
+
+		return corrupt, "local file doesn't match its checksum"
+	}
+
+	if status == verified {
+		return verified, "note and local file"
+	}
+
+	return status, reason
+}
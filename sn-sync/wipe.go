@@ -1,64 +1,36 @@
 package snsync
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"time"
 
-	"github.com/briandowns/spinner"
 	"github.com/jonhadfield/gosn-v2/cache"
 	"github.com/jonhadfield/gosn-v2/items"
 )
 
-func WipeDotfileTagsAndNotes(session *cache.Session, pageSize int, useStdErr bool) (int, error) {
-	if session.Valid() && !session.Debug {
-		prefix := HiWhite("syncing ")
-		if _, err := os.Stat(session.CacheDBPath); os.IsNotExist(err) {
-			prefix = HiWhite("initializing ")
-		}
+// WipeDotfileTagsAndNotes deletes every tracked note and tag from the Standard Notes account of the session,
+// returning how many were deleted. reporter receives its progress, if not nil.
+func WipeDotfileTagsAndNotes(ctx context.Context, session *cache.Session, pageSize int, reporter Reporter) (num int, err error) {
+	opts := Options{Reporter: reporter}
 
-		s := spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stdout))
-		if useStdErr {
-			s = spinner.New(spinner.CharSets[SpinnerCharSet], SpinnerDelay*time.Millisecond, spinner.WithWriter(os.Stderr))
-		}
+	r := opts.reporter()
+	defer func() { r.Finished(err) }()
 
-		s.Prefix = prefix
-		s.Start()
-		defer s.Stop()
-	}
+	r.PhaseStarted(loadPhase(session, opts), 0)
 
-	// get populated db
-	si := cache.SyncInput{
-		Session: session,
-		Close:   false,
-	}
+	store := NewSessionStore(session)
 
-	var err error
-	var cso cache.SyncOutput
-	cso, err = cache.Sync(si)
+	remote, _, err := getTagsWithNotes(ctx, store)
 	if err != nil {
 		return 0, err
 	}
-
-	var remote tagsWithNotes
-
-	remote, err = getTagsWithNotes(cso.DB, session)
-	if err != nil {
-		return 0, err
-	}
-	if err = cso.DB.Close(); err != nil {
-		return 0, err
-	}
-
 	var itemsToRemove items.Items
 
 	for _, twn := range remote {
-		twn.tag.Deleted = true
 		t := twn.tag
 		itemsToRemove = append(itemsToRemove, &t)
 
 		for n := range twn.notes {
-			twn.notes[n].Deleted = true
 			itemsToRemove = append(itemsToRemove, &twn.notes[n])
 		}
 	}
@@ -66,16 +38,15 @@ func WipeDotfileTagsAndNotes(session *cache.Session, pageSize int, useStdErr boo
 	debugPrint(session.Debug, fmt.Sprintf("WipeDotfileTagsAndNotes | removing %d items", len(itemsToRemove)))
 
 	if len(itemsToRemove) == 0 {
-		return 0, nil
+		return 0, store.Close()
 	}
 
-	pii := cache.SyncInput{
-		Session: session,
-		Close:   true,
-	}
+	b := &batch{}
+	b.add(itemsToRemove...)
 
-	_, err = cache.Sync(pii)
-	if err != nil {
+	r.PhaseStarted(PhasePushing, 0)
+
+	if _, err = deleteChanges(ctx, store, b); err != nil {
 		return 0, err
 	}
 
@@ -1,6 +1,7 @@
 package snsync
 
 import (
+	"context"
 	"testing"
 
 	"github.com/jonhadfield/gosn-v2/cache"
@@ -8,7 +9,7 @@ import (
 )
 
 func TestWipeInvalidSession(t *testing.T) {
-	n, err := WipeDotfileTagsAndNotes(&cache.Session{}, DefaultPageSize, true)
+	n, err := WipeDotfileTagsAndNotes(context.Background(), &cache.Session{}, DefaultPageSize, nil)
 	assert.Zero(t, n)
 	assert.Error(t, err)
 }
@@ -16,7 +17,7 @@ func TestWipeInvalidSession(t *testing.T) {
 func TestWipeNoItems(t *testing.T) {
 	var num int
 	var err error
-	num, err = WipeDotfileTagsAndNotes(testCacheSession, DefaultPageSize, true)
+	num, err = WipeDotfileTagsAndNotes(context.Background(), testCacheSession, DefaultPageSize, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, 0, num)
 }
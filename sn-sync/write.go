@@ -0,0 +1,177 @@
+package snsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// createLocal writes the remote content of each item to its path, continuing past any failures
+// and returning an error for each path that couldn't be written. If ctx is cancelled, no more
+// files are written and the items left are returned as pending.
+func createLocal(ctx context.Context, itemDiffs []ItemDiff, home string, opts Options) (pending []ItemDiff, err error) {
+	pending, failures, err := writeLocal(ctx, itemDiffs, home, opts)
+
+	errs := make([]error, 0, len(failures)+1)
+	for _, f := range failures {
+		errs = append(errs, fmt.Errorf("%s: %w", f.homeRelPath, f.err))
+	}
+
+	return pending, errors.Join(append(errs, err)...)
+}
+
+// writeLocal writes each item's remote content to its path, carrying on past paths that can't be written,
+// which are returned as failures. If ctx is cancelled, the items not yet written are returned with its error.
+func writeLocal(ctx context.Context, itemDiffs []ItemDiff, home string, opts Options) (pending, failures []ItemDiff, err error) {
+	r := opts.reporter()
+	if len(itemDiffs) > 0 {
+		r.PhaseStarted(PhasePulling, len(itemDiffs))
+	}
+
+	for i, item := range itemDiffs {
+		if err = ctx.Err(); err != nil {
+			return itemDiffs[i:], failures, err
+		}
+
+		if wErr := createLocalItem(item, home, opts); wErr != nil {
+			r.Error(item.homeRelPath, wErr)
+
+			item.diff = failed
+			item.err = wErr
+			failures = append(failures, item)
+
+			continue
+		}
+
+		r.ItemPulled(item.homeRelPath)
+	}
+
+	return nil, failures, nil
+}
+
+func createLocalItem(item ItemDiff, home string, opts Options) error {
+	// never write outside home or through a symlinked directory
+	if err := checkNoSymlinkDirs(item.path, home); err != nil {
+		return err
+	}
+
+	// write through a symlinked file, such as one managed by stow, only if it links within home
+	path, err := resolveFileLink(item.path, home)
+	if err != nil {
+		return err
+	}
+
+	text, err := decryptText(item.remote.Content.GetText(), opts.Encryption.Passphrase)
+	if err != nil {
+		return err
+	}
+
+	text, err = resolveSecrets(text, opts.Secrets)
+	if err != nil {
+		return fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+
+	// only replace the managed block of an existing file
+	if isManagedBlock(text) && localExists(path) {
+		var existing []byte
+
+		existing, err = os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		text = spliceManagedBlock(string(existing), text)
+	}
+
+	dir, _ := filepath.Split(path)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, []byte(text))
+}
+
+// tempFileAttempts is the number of names tried for a temporary file before giving up
+const tempFileAttempts = 100
+
+// writeFileAtomic replaces the content of path by writing to a temporary file in the same
+// directory, syncing it to disk and renaming it into place, so a crash or full disk never leaves
+// a partially written file. The mode and ownership of an existing file are preserved, and new
+// files get a mode following the umask. A symlink at path is replaced rather than written through.
+func writeFileAtomic(path string, content []byte) (err error) {
+	existing, statErr := os.Lstat(path)
+	if statErr != nil && !os.IsNotExist(statErr) {
+		return statErr
+	}
+
+	if existing != nil && existing.Mode()&os.ModeSymlink != 0 {
+		existing = nil
+	}
+
+	dir := filepath.Dir(path)
+
+	tmp, err := createTemp(dir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmp.Name()
+
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err = tmp.Write(content); err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if existing != nil {
+		if err = os.Chmod(tmpPath, existing.Mode().Perm()); err != nil {
+			return err
+		}
+
+		if err = copyOwner(tmpPath, existing); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// createTemp creates a hidden temporary file for name in dir. Unlike os.CreateTemp, which always uses mode 0600,
+// its mode follows the umask as any new file's would.
+func createTemp(dir, name string) (*os.File, error) {
+	for i := 0; i < tempFileAttempts; i++ {
+		path := filepath.Join(dir, fmt.Sprintf(".%s.sn-sync-%d", name, rand.Uint32()))
+
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if os.IsExist(err) {
+			continue
+		}
+
+		return f, err
+	}
+
+	return nil, fmt.Errorf("failed to create a temporary file for %s in %s", name, dir)
+}
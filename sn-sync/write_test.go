@@ -0,0 +1,112 @@
+package snsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	home := getTemporaryHome()
+	zshrcPath := fmt.Sprintf("%s/.zshrc", home)
+	require.NoError(t, createTemporaryFiles(map[string]string{zshrcPath: "old"}))
+	require.NoError(t, os.Chmod(zshrcPath, 0o600))
+
+	defer os.RemoveAll(home)
+
+	require.NoError(t, writeFileAtomic(zshrcPath, []byte("new")))
+
+	content, err := os.ReadFile(zshrcPath)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+
+	stat, err := os.Stat(zshrcPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), stat.Mode().Perm())
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(home)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// new files get the mode of any file created under the umask
+	umaskPath := filepath.Join(home, ".umask")
+	require.NoError(t, os.WriteFile(umaskPath, nil, 0o666))
+	umaskStat, err := os.Stat(umaskPath)
+	require.NoError(t, err)
+
+	newPath := filepath.Join(home, ".newrc")
+	require.NoError(t, writeFileAtomic(newPath, []byte("x")))
+
+	stat, err = os.Stat(newPath)
+	require.NoError(t, err)
+	assert.Equal(t, umaskStat.Mode().Perm(), stat.Mode().Perm())
+}
+
+func TestCreateLocalSymlinkedFile(t *testing.T) {
+	home := getTemporaryHome()
+	outside := getTemporaryHome()
+	require.NoError(t, createTemporaryFiles(map[string]string{
+		home + "/dotfiles/vimrc": "old",
+		outside + "/target":      "untouched",
+	}))
+
+	defer os.RemoveAll(home)
+	defer os.RemoveAll(outside)
+
+	require.NoError(t, os.Symlink(home+"/dotfiles/vimrc", home+"/.vimrc"))
+	require.NoError(t, os.Symlink(outside+"/target", home+"/.profile"))
+
+	itemDiffs := []ItemDiff{
+		{homeRelPath: ".vimrc", path: home + "/.vimrc", remote: createNote(".vimrc", "set nu")},
+		{homeRelPath: ".profile", path: home + "/.profile", remote: createNote(".profile", "evil")},
+	}
+
+	_, err := createLocal(context.Background(), itemDiffs, home, Options{})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errUnsafePath)
+	assert.Contains(t, err.Error(), ".profile")
+
+	// a link within home is written through, keeping the link
+	content, err := os.ReadFile(home + "/dotfiles/vimrc")
+	require.NoError(t, err)
+	assert.Equal(t, "set nu", string(content))
+
+	stat, err := os.Lstat(home + "/.vimrc")
+	require.NoError(t, err)
+	assert.NotZero(t, stat.Mode()&os.ModeSymlink)
+
+	// a link outside home is refused
+	content, err = os.ReadFile(outside + "/target")
+	require.NoError(t, err)
+	assert.Equal(t, "untouched", string(content))
+}
+
+func TestCreateLocalCollectsErrors(t *testing.T) {
+	home := getTemporaryHome()
+	require.NoError(t, os.MkdirAll(home, 0o755))
+
+	defer os.RemoveAll(home)
+
+	opts := Options{Secrets: &SecretSources{EnvPrefix: DefaultSecretEnvPrefix}}
+	itemDiffs := []ItemDiff{
+		{homeRelPath: ".npmrc", path: home + "/.npmrc", remote: createNote(".npmrc", `{{secret "sn_sync_test_missing"}}`)},
+		{homeRelPath: ".vimrc", path: home + "/.vimrc", remote: createNote(".vimrc", "set nu")},
+		{homeRelPath: "../.profile", path: home + "/../.profile", remote: createNote(".profile", "evil")},
+	}
+
+	_, err := createLocal(context.Background(), itemDiffs, home, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ".npmrc: failed to resolve secrets")
+	assert.ErrorIs(t, err, errUnsafePath)
+
+	// items after a failure are still written
+	content, err := os.ReadFile(home + "/.vimrc")
+	require.NoError(t, err)
+	assert.Equal(t, "set nu", string(content))
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package snsync
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner sets the owner and group of path to those of the file described by like
+func copyOwner(path string, like os.FileInfo) error {
+	st, ok := like.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	if int(st.Uid) == os.Getuid() && int(st.Gid) == os.Getgid() {
+		return nil
+	}
+
+	return os.Chown(path, int(st.Uid), int(st.Gid))
+}
+
+// syncDir flushes a directory so a rename within it is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+
+		return err
+	}
+
+	return d.Close()
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package snsync
+
+import "os"
+
+// copyOwner is a no-op as ownership isn't inherited from the file being replaced
+func copyOwner(_ string, _ os.FileInfo) error {
+	return nil
+}
+
+// syncDir is a no-op as directories can't be synced
+func syncDir(_ string) error {
+	return nil
+}